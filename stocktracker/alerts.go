@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Armed alerts one owner may have at a time
+	maxAlertsPerOwner = 100
+
+	// Fired alerts held for an owner with no listening connection; the
+	// oldest are dropped beyond this
+	maxPendingAlerts = 50
+
+	// Bound on one webhook POST
+	webhookTimeout = 10 * time.Second
+
+	// Tries at delivering a webhook before giving up
+	webhookAttempts = 3
+)
+
+// Wait before the first webhook retry; it doubles with each one
+var webhookRetryBase = time.Second
+
+// errWebhookNotPublic refuses a webhook connection to a non-public address
+var errWebhookNotPublic = errors.New("webhook address is not public")
+
+// alert fires once, the first time Symbol trades at or beyond Price in
+// the direction of Condition ("above" or "below"), and is then disarmed.
+type alert struct {
+	ID        string    `json:"id"`
+	Symbol    string    `json:"symbol"`
+	Condition string    `json:"condition"`
+	Price     float64   `json:"price"`
+	Created   time.Time `json:"created"`
+
+	// Also POSTed the fired alert when set
+	WebhookURL string `json:"webhookURL,omitempty"`
+
+	owner string // auth token it was created with; "" in single-user mode
+}
+
+func (a *alert) met(price float64) bool {
+	if a.Condition == "above" {
+		return price >= a.Price
+	}
+	return price <= a.Price
+}
+
+// alertEvent is a fired alert
+type alertEvent struct {
+	alert        alert
+	triggerPrice float64
+	at           time.Time
+}
+
+func (ev alertEvent) msg() map[string]any {
+	return map[string]any{
+		"type":         "alert",
+		"id":           ev.alert.ID,
+		"symbol":       ev.alert.Symbol,
+		"condition":    ev.alert.Condition,
+		"price":        ev.alert.Price,
+		"triggerPrice": ev.triggerPrice,
+		"time":         ev.at.UnixMilli(),
+	}
+}
+
+// ---------------- Engine ----------------
+
+// alertEngine watches quotes for every symbol with an armed alert,
+// through the hub like any other subscriber, and hands fired alerts to
+// fire.
+type alertEngine struct {
+	hub     *Hub
+	fire    func(alertEvent)
+	updates *updateQueue
+
+	mu     sync.Mutex
+	armed  map[string]*alert // by ID
+	nextID uint64
+}
+
+func newAlertEngine(hub *Hub, fire func(alertEvent)) *alertEngine {
+	return &alertEngine{
+		hub:     hub,
+		fire:    fire,
+		updates: newUpdateQueue(),
+		armed:   make(map[string]*alert),
+	}
+}
+
+// add arms a, assigning its ID. A quote already past the threshold fires
+// it straight away.
+func (e *alertEngine) add(a alert) (alert, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n, watched := 0, false
+	for _, b := range e.armed {
+		if b.owner == a.owner {
+			n++
+		}
+		watched = watched || b.Symbol == a.Symbol
+	}
+	if n >= maxAlertsPerOwner {
+		return a, fmt.Errorf("alert limit reached (%d)", maxAlertsPerOwner)
+	}
+	if !watched {
+		if err := e.hub.Subscribe(a.Symbol, e.updates, cfg.PollInterval); err != nil {
+			return a, err
+		}
+	}
+	e.nextID++
+	a.ID = strconv.FormatUint(e.nextID, 10)
+	a.Created = time.Now()
+	e.armed[a.ID] = &a
+	return a, nil
+}
+
+// list returns owner's armed alerts, oldest first
+func (e *alertEngine) list(owner string) []alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := []alert{}
+	for _, a := range e.armed {
+		if a.owner == owner {
+			out = append(out, *a)
+		}
+	}
+	slices.SortFunc(out, func(a, b alert) int { return a.Created.Compare(b.Created) })
+	return out
+}
+
+// remove disarms owner's alert id, reporting false if there is none
+func (e *alertEngine) remove(owner, id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a, ok := e.armed[id]
+	if !ok || a.owner != owner {
+		return false
+	}
+	delete(e.armed, id)
+	for _, b := range e.armed {
+		if b.Symbol == a.Symbol {
+			return true
+		}
+	}
+	e.hub.Unsubscribe(a.Symbol, e.updates)
+	return true
+}
+
+func (e *alertEngine) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			e.hub.Unregister(e.updates)
+			return
+		case <-e.updates.ready:
+		}
+		for {
+			u, ok := e.updates.pop()
+			if !ok {
+				break
+			}
+			if u.Err == nil {
+				e.check(u)
+			}
+		}
+	}
+}
+
+// check disarms and fires every alert on u's symbol that u trips
+func (e *alertEngine) check(u quoteUpdate) {
+	// An empty or zero-priced quote is Finnhub's answer for a symbol it
+	// doesn't know, not a price; it would fire every "below" alert.
+	if u.Quote == nil || u.Quote.Empty() || u.Quote.Current == 0 {
+		return
+	}
+	var fired []alertEvent
+	e.mu.Lock()
+	remaining := 0
+	for id, a := range e.armed {
+		if a.Symbol != u.Symbol {
+			continue
+		}
+		if a.met(u.Quote.Current) {
+			delete(e.armed, id)
+			fired = append(fired, alertEvent{alert: *a, triggerPrice: u.Quote.Current, at: u.Time})
+		} else {
+			remaining++
+		}
+	}
+	if len(fired) > 0 && remaining == 0 {
+		e.hub.Unsubscribe(u.Symbol, e.updates)
+	}
+	e.mu.Unlock()
+
+	for _, ev := range fired {
+		slog.Info("alert fired", "id", ev.alert.ID, "symbol", ev.alert.Symbol,
+			"condition", ev.alert.Condition, "price", ev.alert.Price, "trigger_price", ev.triggerPrice)
+		e.fire(ev)
+	}
+}
+
+// ---------------- Delivery ----------------
+
+// alertInbox routes fired alerts to their owner's connections that opted
+// in, holding them for the owner's next connection if there are none.
+type alertInbox struct {
+	mu        sync.Mutex
+	listeners map[string]map[*wsClient]struct{} // by owner
+	pending   map[string][]alertEvent           // by owner, oldest first
+}
+
+func newAlertInbox() *alertInbox {
+	return &alertInbox{
+		listeners: make(map[string]map[*wsClient]struct{}),
+		pending:   make(map[string][]alertEvent),
+	}
+}
+
+// deliver sends ev to every listener of its owner, or holds it
+func (in *alertInbox) deliver(ev alertEvent) {
+	in.mu.Lock()
+	var to []*wsClient
+	for c := range in.listeners[ev.alert.owner] {
+		to = append(to, c)
+	}
+	if len(to) == 0 {
+		q := append(in.pending[ev.alert.owner], ev)
+		if len(q) > maxPendingAlerts {
+			q = q[len(q)-maxPendingAlerts:]
+		}
+		in.pending[ev.alert.owner] = q
+	}
+	in.mu.Unlock()
+
+	for _, c := range to {
+		c.queue(ev.msg())
+	}
+}
+
+// listen opts c in to its owner's alerts, first sending any held for it
+func (in *alertInbox) listen(c *wsClient) error {
+	in.mu.Lock()
+	if in.listeners[c.owner] == nil {
+		in.listeners[c.owner] = make(map[*wsClient]struct{})
+	}
+	in.listeners[c.owner][c] = struct{}{}
+	held := in.pending[c.owner]
+	delete(in.pending, c.owner)
+	in.mu.Unlock()
+
+	for _, ev := range held {
+		if err := c.queue(ev.msg()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *alertInbox) forget(c *wsClient) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	delete(in.listeners[c.owner], c)
+	if len(in.listeners[c.owner]) == 0 {
+		delete(in.listeners, c.owner)
+	}
+}
+
+// webhookClient posts fired alerts to their webhook URLs. It ignores proxy
+// settings and dials through dialWebhook, so the server can't be made to
+// POST to itself or its private network.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         dialWebhook,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+}
+
+// publicIP reports whether ip is a public unicast address: not loopback,
+// private, link-local, multicast or unspecified
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// webhookHostAllowed reports whether host is in WEBHOOK_ALLOWED_HOSTS,
+// exempt from the public address check
+func webhookHostAllowed(host string) bool {
+	return slices.ContainsFunc(cfg.WebhookAllowedHosts, func(h string) bool {
+		return strings.EqualFold(h, host)
+	})
+}
+
+// dialWebhook connects to a webhook target, refusing non-public addresses
+// unless the host is allowed. The check runs on the resolved address, so
+// a name that re-resolves (or a redirect) to a private one is refused too.
+func dialWebhook(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: webhookTimeout}
+	if host, _, err := net.SplitHostPort(addr); err != nil || !webhookHostAllowed(host) {
+		d.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, _ := net.SplitHostPort(address)
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", errWebhookNotPublic, host)
+			}
+			return nil
+		}
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// postWebhook POSTs ev's alert message to its webhook URL, retrying
+// network errors, 429s and 5xx with a doubling wait; it logs rather than
+// returns the final failure.
+func postWebhook(ev alertEvent) {
+	body, err := json.Marshal(ev.msg())
+	if err != nil {
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := deliverWebhook(ev.alert.WebhookURL, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt+1 >= webhookAttempts {
+			slog.Warn("alert webhook failed", "id", ev.alert.ID, "attempts", attempt+1, "err", err)
+			return
+		}
+		time.Sleep(webhookRetryBase << attempt)
+	}
+}
+
+// deliverWebhook makes one POST, reporting whether a failure is worth
+// retrying
+func deliverWebhook(u string, body []byte) (retry bool, err error) {
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return !errors.Is(err, errWebhookNotPublic), err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// checkWebhook returns why u can't be a webhook URL, if it can't: it must
+// be an absolute http or https URL whose host resolves only to public
+// addresses, unless the host is in WEBHOOK_ALLOWED_HOSTS.
+func checkWebhook(ctx context.Context, u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("webhookURL must be an http or https URL")
+	}
+	host := parsed.Hostname()
+	if webhookHostAllowed(host) {
+		return nil
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return fmt.Errorf("webhookURL host %s does not resolve", host)
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return errors.New("webhookURL must not point at a loopback, private or link-local address")
+		}
+	}
+	return nil
+}
+
+// ---------------- HTTP Handler ----------------
+
+// POST /api/alerts {"symbol":"AAPL","condition":"above","price":200}
+// Arms an alert for the caller; it is pushed as {"type":"alert"} to the
+// caller's /ws connections that opted in with "alerts":true. With
+// "webhookURL":"https://..." the same message is also POSTed there. A
+// symbol Finnhub has no quote for answers 404 unknown_symbol.
+func (s *server) handleCreateAlert(w http.ResponseWriter, r *http.Request) {
+	var a alert
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		badRequest(w, "malformed alert")
+		return
+	}
+	var err error
+	if a.Symbol, err = normalizeSymbol(a.Symbol); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	switch {
+	case a.Condition != "above" && a.Condition != "below":
+		badRequest(w, `condition must be "above" or "below"`)
+		return
+	case a.Price <= 0:
+		badRequest(w, "price must be positive")
+		return
+	}
+	if a.WebhookURL != "" {
+		if err := checkWebhook(r.Context(), a.WebhookURL); err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+	}
+	q, err := s.provider.Quote(r.Context(), a.Symbol)
+	if err != nil {
+		badGateway(w, r, err)
+		return
+	}
+	if q.Empty() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown_symbol"})
+		return
+	}
+	a.owner = authOwner(r)
+
+	a, err = s.alerts.add(a)
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+// GET /api/alerts
+// The caller's armed alerts, oldest first.
+func (s *server) handleListAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.alerts.list(authOwner(r)))
+}
+
+// DELETE /api/alerts/{id}
+// Disarms one of the caller's alerts; 404 if it has fired or never was.
+func (s *server) handleDeleteAlert(w http.ResponseWriter, r *http.Request) {
+	if !s.alerts.remove(authOwner(r), r.PathValue("id")) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown_alert"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
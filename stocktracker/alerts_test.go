@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// allowWebhookHosts sets WEBHOOK_ALLOWED_HOSTS for one test
+func allowWebhookHosts(t *testing.T, hosts ...string) {
+	t.Helper()
+	prev := cfg.WebhookAllowedHosts
+	cfg.WebhookAllowedHosts = hosts
+	t.Cleanup(func() { cfg.WebhookAllowedHosts = prev })
+}
+
+func TestCheckWebhook(t *testing.T) {
+	allowWebhookHosts(t, "hooks.internal", "127.0.0.2")
+	tests := []struct {
+		url     string
+		wantErr string
+	}{
+		{"https://93.184.216.34/hook", ""},
+		{"http://[2606:4700::1111]:8080/", ""},
+		{"http://hooks.internal/alert", ""}, // allowed without resolving
+		{"http://127.0.0.2:9000/", ""},
+		{"ftp://93.184.216.34/", "http or https"},
+		{"/relative", "http or https"},
+		{"http://", "http or https"},
+		{"http://127.0.0.1:8080/", "loopback"},
+		{"http://localhost/", "loopback"},
+		{"http://169.254.169.254/latest/meta-data", "loopback"},
+		{"http://10.1.2.3/", "loopback"},
+		{"http://192.168.0.10/", "loopback"},
+		{"http://[::1]/", "loopback"},
+		{"http://[fd00::1]/", "loopback"},
+		{"http://[::ffff:127.0.0.1]/", "loopback"},
+		{"http://0.0.0.0/", "loopback"},
+	}
+	for _, tt := range tests {
+		err := checkWebhook(context.Background(), tt.url)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("checkWebhook(%q) = %v, want ok", tt.url, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("checkWebhook(%q) = %v, want an error mentioning %q", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+// webhookTarget is an httptest server answering each POST with the next
+// of statuses (the last one repeating), recording the bodies
+func webhookTarget(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32, chan map[string]any) {
+	t.Helper()
+	var hits atomic.Int32
+	bodies := make(chan map[string]any, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(hits.Add(1))
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits, bodies
+}
+
+func TestPostWebhook(t *testing.T) {
+	prev := webhookRetryBase
+	webhookRetryBase = time.Millisecond
+	t.Cleanup(func() { webhookRetryBase = prev })
+
+	ev := alertEvent{
+		alert:        alert{ID: "a1", Symbol: "AAPL", Condition: "above", Price: 200},
+		triggerPrice: 200.5,
+		at:           time.UnixMilli(1717000000000),
+	}
+	tests := []struct {
+		name      string
+		allowed   bool
+		statuses  []int
+		wantHits  int32
+		delivered bool
+	}{
+		{"delivered", true, []int{http.StatusOK}, 1, true},
+		{"retried after 5xx", true, []int{http.StatusServiceUnavailable, http.StatusNoContent}, 2, true},
+		{"retried after 429", true, []int{http.StatusTooManyRequests, http.StatusOK}, 2, true},
+		{"gives up", true, []int{http.StatusBadGateway}, webhookAttempts, false},
+		{"4xx not retried", true, []int{http.StatusNotFound}, 1, false},
+		{"loopback refused", false, []int{http.StatusOK}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits, bodies := webhookTarget(t, tt.statuses...)
+			if tt.allowed {
+				u, _ := url.Parse(srv.URL)
+				allowWebhookHosts(t, u.Hostname())
+			} else {
+				allowWebhookHosts(t)
+			}
+			ev := ev
+			ev.alert.WebhookURL = srv.URL + "/hook"
+			postWebhook(ev)
+
+			if got := hits.Load(); got != tt.wantHits {
+				t.Errorf("target hit %d times, want %d", got, tt.wantHits)
+			}
+			if tt.wantHits == 0 {
+				return
+			}
+			body := <-bodies
+			if body["type"] != "alert" || body["id"] != "a1" || body["symbol"] != "AAPL" || body["triggerPrice"] != 200.5 {
+				t.Errorf("payload %v", body)
+			}
+		})
+	}
+}
+
+func TestCreateAlertRefusesPrivateWebhook(t *testing.T) {
+	allowWebhookHosts(t)
+	s := &server{alerts: newAlertEngine(newHub(nil), func(alertEvent) {})}
+	for _, hook := range []string{"http://169.254.169.254/", "http://127.0.0.1:6379/", "gopher://example.com/"} {
+		body := `{"symbol":"AAPL","condition":"above","price":200,"webhookURL":"` + hook + `"}`
+		rec := httptest.NewRecorder()
+		s.handleCreateAlert(rec, httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("webhookURL %s: status %d, want 400", hook, rec.Code)
+		}
+	}
+}
+
+func TestCreateAlertUnknownSymbol(t *testing.T) {
+	s := &server{
+		provider: &stubProvider{quote: func(ctx context.Context, symbol string) (*Quote, error) {
+			switch symbol {
+			case "FAIL":
+				return nil, errors.New("upstream down")
+			case "ZZZZ":
+				return &Quote{}, nil // Finnhub's answer for a symbol it doesn't know
+			}
+			return &Quote{Current: 190, PrevClose: 188}, nil
+		}},
+		alerts: newAlertEngine(newHub((&countingFetch{}).fetch), func(alertEvent) {}),
+	}
+	tests := []struct {
+		symbol    string
+		wantCode  int
+		wantArmed int
+	}{
+		{"AAPL", http.StatusCreated, 1},
+		{"ZZZZ", http.StatusNotFound, 0},
+		{"FAIL", http.StatusBadGateway, 0},
+	}
+	for _, tt := range tests {
+		body := `{"symbol":"` + tt.symbol + `","condition":"above","price":200}`
+		rec := httptest.NewRecorder()
+		s.handleCreateAlert(rec, httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(body)))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.symbol, rec.Code, tt.wantCode, rec.Body)
+		}
+		armed := 0
+		for _, a := range s.alerts.list("") {
+			if a.Symbol == tt.symbol {
+				armed++
+			}
+		}
+		if armed != tt.wantArmed {
+			t.Errorf("%s: %d alerts armed, want %d", tt.symbol, armed, tt.wantArmed)
+		}
+	}
+	var body map[string]string
+	rec := httptest.NewRecorder()
+	s.handleCreateAlert(rec, httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(`{"symbol":"ZZZZ","condition":"below","price":1}`)))
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["error"] != "unknown_symbol" {
+		t.Errorf("body %s, want error unknown_symbol", rec.Body)
+	}
+}
+
+func TestAlertCheckSkipsEmptyQuotes(t *testing.T) {
+	tests := []struct {
+		name      string
+		quote     *Quote
+		wantFired bool
+	}{
+		{"empty quote", &Quote{}, false},
+		{"zero price", &Quote{PrevClose: 188}, false},
+		{"real price", &Quote{Current: 99, PrevClose: 101}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fired []alertEvent
+			e := newAlertEngine(newHub(nil), func(ev alertEvent) { fired = append(fired, ev) })
+			e.armed["1"] = &alert{ID: "1", Symbol: "AAPL", Condition: "below", Price: 100}
+			e.check(quoteUpdate{Symbol: "AAPL", Quote: tt.quote, Time: time.Now()})
+			if (len(fired) > 0) != tt.wantFired {
+				t.Errorf("fired %v, want fired %v", fired, tt.wantFired)
+			}
+			if _, armed := e.armed["1"]; armed == tt.wantFired {
+				t.Errorf("still armed %v, want %v", armed, !tt.wantFired)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Optional shared-token auth. With no AUTH_TOKENS configured every
+// request is let through, as before.
+
+// requireToken rejects requests that don't carry a configured token
+// with 401. It wraps both the /api handlers and /ws, so the check runs
+// before any WebSocket upgrade.
+func requireToken(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(cfg.AuthTokens) > 0 && !validToken(requestToken(r)) && tokenProtocol(r) == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requestToken reads ?token= or an "Authorization: Bearer" header
+func requestToken(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(t)
+	}
+	return ""
+}
+
+// tokenProtocol returns the Sec-WebSocket-Protocol entry that is a valid
+// token, if any. Browsers can't set headers on WebSockets, so this is how
+// they pass a token without putting it in the URL:
+//
+//	new WebSocket(url, [token])
+//
+// The server must echo the chosen protocol back or the browser aborts.
+func tokenProtocol(r *http.Request) string {
+	if len(cfg.AuthTokens) == 0 {
+		return ""
+	}
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for p := range strings.SplitSeq(h, ",") {
+			if p = strings.TrimSpace(p); validToken(p) {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// authOwner identifies whose alerts a request may create or receive: the
+// token it authenticated with, or "" when auth is off (single-user mode,
+// where every connection belongs to the same user).
+func authOwner(r *http.Request) string {
+	if len(cfg.AuthTokens) == 0 {
+		return ""
+	}
+	if t := requestToken(r); validToken(t) {
+		return t
+	}
+	return tokenProtocol(r)
+}
+
+// validToken compares t against every configured token in constant time
+func validToken(t string) bool {
+	if t == "" {
+		return false
+	}
+	ok := 0
+	for _, want := range cfg.AuthTokens {
+		ok |= subtle.ConstantTimeCompare([]byte(t), []byte(want))
+	}
+	return ok == 1
+}
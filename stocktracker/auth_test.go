@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// withAuthTokens locks the app down to tokens for the rest of the test
+func withAuthTokens(t *testing.T, tokens ...string) {
+	prev := cfg.AuthTokens
+	cfg.AuthTokens = tokens
+	t.Cleanup(func() { cfg.AuthTokens = prev })
+}
+
+func TestRequireToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []string
+		query  string
+		header http.Header
+		want   int
+	}{
+		{"open, no token", nil, "", nil, http.StatusOK},
+		{"open ignores a token", nil, "?token=whatever", nil, http.StatusOK},
+		{"locked, no token", []string{"s3cret"}, "", nil, http.StatusUnauthorized},
+		{"locked, query token", []string{"s3cret"}, "?token=s3cret", nil, http.StatusOK},
+		{"locked, wrong token", []string{"s3cret"}, "?token=guess", nil, http.StatusUnauthorized},
+		{"locked, bearer header", []string{"s3cret"}, "", http.Header{"Authorization": {"Bearer s3cret"}}, http.StatusOK},
+		{"locked, second token", []string{"s3cret", "other"}, "?token=other", nil, http.StatusOK},
+		{"locked, subprotocol", []string{"s3cret"}, "", http.Header{"Sec-WebSocket-Protocol": {"stocktracker.v2, s3cret"}}, http.StatusOK},
+		{"locked, empty token", []string{"s3cret"}, "?token=", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAuthTokens(t, tt.tokens...)
+			h := requireToken(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/quote"+tt.query, nil)
+			for k, vs := range tt.header {
+				for _, v := range vs {
+					req.Header.Add(k, v)
+				}
+			}
+			h(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("401 without a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestWSTokenAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		tokens       []string
+		query        string
+		subprotocols []string
+		wantStatus   int    // of the handshake
+		wantProtocol string // echoed back
+	}{
+		{"open", nil, "", nil, http.StatusSwitchingProtocols, ""},
+		{"locked, no token", []string{"s3cret"}, "", nil, http.StatusUnauthorized, ""},
+		{"locked, query token", []string{"s3cret"}, "?token=s3cret", nil, http.StatusSwitchingProtocols, ""},
+		{"locked, token as subprotocol", []string{"s3cret"}, "", []string{"s3cret"}, http.StatusSwitchingProtocols, "s3cret"},
+		{"locked, wrong subprotocol", []string{"s3cret"}, "", []string{"guess"}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAuthTokens(t, tt.tokens...)
+			f := &countingFetch{}
+			s := &server{hub: newHub(f.fetch)}
+			ts := httptest.NewServer(requireToken(s.handleWS))
+			defer ts.Close()
+
+			d := websocket.Dialer{Subprotocols: tt.subprotocols}
+			conn, resp, err := d.Dial("ws"+ts.URL[len("http"):]+"/ws"+tt.query, nil)
+			if conn != nil {
+				defer conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("no handshake response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("handshake status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if conn != nil && conn.Subprotocol() != tt.wantProtocol {
+				t.Errorf("subprotocol %q, want %q", conn.Subprotocol(), tt.wantProtocol)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Bar is one OHLC bar; Time is the bar's start in UNIX seconds.
+type Bar struct {
+	Time  int64   `json:"t"`
+	Open  float64 `json:"o"`
+	High  float64 `json:"h"`
+	Low   float64 `json:"l"`
+	Close float64 `json:"c"`
+}
+
+// barBuilder aggregates a stream of prices into fixed-period bars aligned
+// to wall-clock boundaries. Callers pass the tick time in, so it is
+// deterministic under a fake clock.
+type barBuilder struct {
+	period time.Duration
+	cur    *Bar
+}
+
+func newBarBuilder(period time.Duration) *barBuilder {
+	return &barBuilder{period: period}
+}
+
+// add folds a price observed at time at into the current bar. It returns
+// the in-progress bar and, when at starts a new period, the bar that was
+// just completed.
+func (b *barBuilder) add(price float64, at time.Time) (cur Bar, closed *Bar) {
+	start := at.Truncate(b.period).Unix()
+
+	// A late tick from an earlier period is folded into the current bar
+	if b.cur != nil && start > b.cur.Time {
+		done := *b.cur
+		closed = &done
+		b.cur = nil
+	}
+	if b.cur == nil {
+		b.cur = &Bar{Time: start, Open: price, High: price, Low: price, Close: price}
+	} else {
+		b.cur.High = max(b.cur.High, price)
+		b.cur.Low = min(b.cur.Low, price)
+		b.cur.Close = price
+	}
+	return *b.cur, closed
+}
+
+// resampleCandles merges c's bars into period-long buckets aligned to UTC
+// wall-clock boundaries: open is the first open, close the last close,
+// high and low the extremes and volume the sum. Buckets without bars are
+// left out. partial reports that the last bucket runs past end and so is
+// still missing bars.
+func resampleCandles(c *Candles, period time.Duration, end time.Time) (out *Candles, partial bool) {
+	out = &Candles{S: c.S}
+	step := int64(period / time.Second)
+	for i := range c.Len() {
+		start := c.Time[i] - c.Time[i]%step
+		if n := len(out.Time); n > 0 && out.Time[n-1] == start {
+			out.High[n-1] = max(out.High[n-1], c.High[i])
+			out.Low[n-1] = min(out.Low[n-1], c.Low[i])
+			out.Close[n-1] = c.Close[i]
+			out.Volume[n-1] += c.Volume[i]
+			continue
+		}
+		out.Time = append(out.Time, start)
+		out.Open = append(out.Open, c.Open[i])
+		out.High = append(out.High, c.High[i])
+		out.Low = append(out.Low, c.Low[i])
+		out.Close = append(out.Close, c.Close[i])
+		out.Volume = append(out.Volume, c.Volume[i])
+	}
+	if n := len(out.Time); n > 0 {
+		partial = out.Time[n-1]+step > end.Unix()
+	}
+	return out, partial
+}
+
+// fillGaps returns c with a bar for every step between its first and last
+// bars: each missing one repeats the previous close as open, high, low and
+// close, with zero volume. It fails with ErrTooManyBars rather than grow
+// past maxCandleBars.
+func fillGaps(c *Candles, step time.Duration) (*Candles, error) {
+	n := c.Len()
+	if n == 0 {
+		return c, nil
+	}
+	sec := int64(step / time.Second)
+	if (c.Time[n-1]-c.Time[0])/sec >= maxCandleBars {
+		return nil, ErrTooManyBars
+	}
+	out := &Candles{S: c.S}
+	for i := range n {
+		if i > 0 {
+			prev := c.Close[i-1]
+			for t := c.Time[i-1] + sec; t < c.Time[i]; t += sec {
+				out.Time = append(out.Time, t)
+				out.Open = append(out.Open, prev)
+				out.High = append(out.High, prev)
+				out.Low = append(out.Low, prev)
+				out.Close = append(out.Close, prev)
+				out.Volume = append(out.Volume, 0)
+			}
+		}
+		out.Time = append(out.Time, c.Time[i])
+		out.Open = append(out.Open, c.Open[i])
+		out.High = append(out.High, c.High[i])
+		out.Low = append(out.Low, c.Low[i])
+		out.Close = append(out.Close, c.Close[i])
+		out.Volume = append(out.Volume, c.Volume[i])
+	}
+	return out, nil
+}
+
+// Candle styles /api/candles and /ws snapshots can send
+const (
+	styleCandles    = "candles"
+	styleHeikinAshi = "heikin-ashi"
+)
+
+// heikinAshi converts c to Heikin-Ashi bars: close is the bar's OHLC
+// average, open the midpoint of the previous Heikin-Ashi bar (of the
+// first bar's open and close to start), and high and low stretch to cover
+// both. Times and volumes are kept.
+func heikinAshi(c *Candles) *Candles {
+	n := c.Len()
+	out := &Candles{
+		Close:  make([]float64, n),
+		High:   make([]float64, n),
+		Low:    make([]float64, n),
+		Open:   make([]float64, n),
+		Time:   c.Time[:n],
+		Volume: c.Volume[:n],
+		S:      c.S,
+	}
+	for i := range n {
+		out.Close[i] = (c.Open[i] + c.High[i] + c.Low[i] + c.Close[i]) / 4
+		if i == 0 {
+			out.Open[i] = (c.Open[i] + c.Close[i]) / 2
+		} else {
+			out.Open[i] = (out.Open[i-1] + out.Close[i-1]) / 2
+		}
+		out.High[i] = max(c.High[i], out.Open[i], out.Close[i])
+		out.Low[i] = min(c.Low[i], out.Open[i], out.Close[i])
+	}
+	return out
+}
+
+// parseStyle reads a ?style= value, defaulting to plain candles
+func parseStyle(s string) (string, error) {
+	switch s {
+	case "", styleCandles:
+		return styleCandles, nil
+	case styleHeikinAshi:
+		return s, nil
+	}
+	return "", errors.New("style must be candles or heikin-ashi")
+}
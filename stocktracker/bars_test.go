@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBarBuilder(t *testing.T) {
+	t0 := time.Unix(1717000020, 0) // on a minute boundary
+	type tick struct {
+		price float64
+		after time.Duration // since t0
+	}
+	tests := []struct {
+		name   string
+		ticks  []tick
+		cur    Bar
+		closed []Bar // bars completed along the way, in order
+	}{
+		{
+			"first tick opens the bar",
+			[]tick{{190, 5 * time.Second}},
+			Bar{Time: 1717000020, Open: 190, High: 190, Low: 190, Close: 190},
+			nil,
+		},
+		{
+			"rolling high, low and close",
+			[]tick{{190, 0}, {190.4, 10 * time.Second}, {189.9, 20 * time.Second}, {190.1, 59 * time.Second}},
+			Bar{Time: 1717000020, Open: 190, High: 190.4, Low: 189.9, Close: 190.1},
+			nil,
+		},
+		{
+			"minute rollover closes the bar",
+			[]tick{{190, 0}, {190.6, 30 * time.Second}, {191, 60 * time.Second}},
+			Bar{Time: 1717000080, Open: 191, High: 191, Low: 191, Close: 191},
+			[]Bar{{Time: 1717000020, Open: 190, High: 190.6, Low: 190, Close: 190.6}},
+		},
+		{
+			"quiet minutes leave no bars",
+			[]tick{{190, 0}, {192, 3*time.Minute + time.Second}},
+			Bar{Time: 1717000200, Open: 192, High: 192, Low: 192, Close: 192},
+			[]Bar{{Time: 1717000020, Open: 190, High: 190, Low: 190, Close: 190}},
+		},
+		{
+			"late tick folds into the current bar",
+			[]tick{{190, 0}, {191, 61 * time.Second}, {185, 59 * time.Second}},
+			Bar{Time: 1717000080, Open: 191, High: 191, Low: 185, Close: 185},
+			[]Bar{{Time: 1717000020, Open: 190, High: 190, Low: 190, Close: 190}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBarBuilder(time.Minute)
+			var cur Bar
+			var closed []Bar
+			for _, tk := range tt.ticks {
+				var c *Bar
+				cur, c = b.add(tk.price, t0.Add(tk.after))
+				if c != nil {
+					closed = append(closed, *c)
+				}
+			}
+			if cur != tt.cur {
+				t.Errorf("current bar = %+v, want %+v", cur, tt.cur)
+			}
+			if len(closed) != len(tt.closed) {
+				t.Fatalf("closed bars = %+v, want %+v", closed, tt.closed)
+			}
+			for i := range closed {
+				if closed[i] != tt.closed[i] {
+					t.Errorf("closed bar %d = %+v, want %+v", i, closed[i], tt.closed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResampleCandles(t *testing.T) {
+	const t0 = 1717000200 // 16:30 UTC, on a 10-minute boundary
+	type ohlcv struct {
+		Time                   int64
+		Open, High, Low, Close float64
+		Volume                 float64
+	}
+	bar := func(at int64, o, h, l, c, v float64) ohlcv {
+		return ohlcv{at, o, h, l, c, v}
+	}
+	tests := []struct {
+		name        string
+		in          []ohlcv
+		period      time.Duration
+		end         int64
+		want        []ohlcv
+		wantPartial bool
+	}{
+		{"empty", nil, 2 * time.Minute, t0, nil, false},
+		{
+			"pairs of minutes",
+			[]ohlcv{
+				bar(t0, 10, 12, 9, 11, 100),
+				bar(t0+60, 11, 13, 10, 12, 50),
+				bar(t0+120, 12, 12.5, 8, 9, 10),
+				bar(t0+180, 9, 10, 7, 7.5, 20),
+			},
+			2 * time.Minute, t0 + 240,
+			[]ohlcv{bar(t0, 10, 13, 9, 12, 150), bar(t0+120, 12, 12.5, 7, 7.5, 30)},
+			false,
+		},
+		{
+			"single-bar bucket",
+			[]ohlcv{bar(t0, 10, 11, 9, 10.5, 5)},
+			5 * time.Minute, t0 + 300,
+			[]ohlcv{bar(t0, 10, 11, 9, 10.5, 5)},
+			false,
+		},
+		{
+			"aligned to the wall clock, not the first bar",
+			[]ohlcv{bar(t0+180, 10, 11, 9, 10, 1), bar(t0+240, 10, 12, 10, 11, 1), bar(t0+300, 11, 11, 10, 10, 1)},
+			5 * time.Minute, t0 + 600,
+			[]ohlcv{bar(t0, 10, 12, 9, 11, 2), bar(t0+300, 11, 11, 10, 10, 1)},
+			false,
+		},
+		{
+			"gap leaves buckets out",
+			[]ohlcv{bar(t0, 10, 10, 10, 10, 1), bar(t0+1800, 20, 20, 20, 20, 2)},
+			10 * time.Minute, t0 + 2400,
+			[]ohlcv{bar(t0, 10, 10, 10, 10, 1), bar(t0+1800, 20, 20, 20, 20, 2)},
+			false,
+		},
+		{
+			"partial trailing bucket",
+			[]ohlcv{bar(t0, 10, 11, 9, 10, 1), bar(t0+120, 10, 12, 10, 11, 1), bar(t0+300, 11, 13, 11, 12, 1)},
+			5 * time.Minute, t0 + 360,
+			[]ohlcv{bar(t0, 10, 12, 9, 11, 2), bar(t0+300, 11, 13, 11, 12, 1)},
+			true,
+		},
+		{
+			"hours",
+			[]ohlcv{bar(1717027200, 1, 2, 1, 2, 1), bar(1717027200+3*3600, 2, 5, 1, 4, 1), bar(1717027200+4*3600, 4, 4, 3, 3, 1)},
+			4 * time.Hour, 1717027200 + 8*3600,
+			[]ohlcv{bar(1717027200, 1, 5, 1, 4, 2), bar(1717027200+4*3600, 4, 4, 3, 3, 1)},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Candles{S: "ok"}
+			for _, b := range tt.in {
+				c.Time = append(c.Time, b.Time)
+				c.Open = append(c.Open, b.Open)
+				c.High = append(c.High, b.High)
+				c.Low = append(c.Low, b.Low)
+				c.Close = append(c.Close, b.Close)
+				c.Volume = append(c.Volume, b.Volume)
+			}
+			out, partial := resampleCandles(c, tt.period, time.Unix(tt.end, 0))
+			if partial != tt.wantPartial {
+				t.Errorf("partial = %t, want %t", partial, tt.wantPartial)
+			}
+			if out.S != "ok" || out.Len() != len(tt.want) {
+				t.Fatalf("status %q, %d buckets, want %d", out.S, out.Len(), len(tt.want))
+			}
+			for i, w := range tt.want {
+				got := bar(out.Time[i], out.Open[i], out.High[i], out.Low[i], out.Close[i], out.Volume[i])
+				if got != w {
+					t.Errorf("bucket %d = %+v, want %+v", i, got, w)
+				}
+			}
+		})
+	}
+}
+
+func TestFillGaps(t *testing.T) {
+	// series makes candles at times, opening 0.5 under and ranging 1 around
+	// each close, with volume 10
+	series := func(times []int64, closes []float64) *Candles {
+		c := &Candles{S: "ok", Time: times}
+		for _, v := range closes {
+			c.Open = append(c.Open, v-0.5)
+			c.High = append(c.High, v+1)
+			c.Low = append(c.Low, v-1)
+			c.Close = append(c.Close, v)
+			c.Volume = append(c.Volume, 10)
+		}
+		return c
+	}
+	const t0 = 1717000200
+	tests := []struct {
+		name       string
+		in         *Candles
+		step       time.Duration
+		wantTime   []int64
+		wantClose  []float64
+		wantVolume []float64
+		wantErr    error
+	}{
+		{"empty", &Candles{S: "no_data"}, time.Minute, nil, nil, nil, nil},
+		{"single bar", series([]int64{t0}, []float64{5}), time.Minute, []int64{t0}, []float64{5}, []float64{10}, nil},
+		{
+			"no gaps",
+			series([]int64{t0, t0 + 60, t0 + 120}, []float64{5, 6, 7}), time.Minute,
+			[]int64{t0, t0 + 60, t0 + 120}, []float64{5, 6, 7}, []float64{10, 10, 10}, nil,
+		},
+		{
+			"one missing minute",
+			series([]int64{t0, t0 + 120}, []float64{5, 7}), time.Minute,
+			[]int64{t0, t0 + 60, t0 + 120}, []float64{5, 5, 7}, []float64{10, 0, 10}, nil,
+		},
+		{
+			"several gaps",
+			series([]int64{t0, t0 + 180, t0 + 240, t0 + 360}, []float64{5, 8, 9, 4}), time.Minute,
+			[]int64{t0, t0 + 60, t0 + 120, t0 + 180, t0 + 240, t0 + 300, t0 + 360},
+			[]float64{5, 5, 5, 8, 9, 9, 4},
+			[]float64{10, 0, 0, 10, 10, 0, 10},
+			nil,
+		},
+		{
+			"five-minute bars",
+			series([]int64{t0, t0 + 900}, []float64{5, 6}), 5 * time.Minute,
+			[]int64{t0, t0 + 300, t0 + 600, t0 + 900}, []float64{5, 5, 5, 6}, []float64{10, 0, 0, 10}, nil,
+		},
+		{
+			"too many bars",
+			series([]int64{t0, t0 + 60*maxCandleBars}, []float64{5, 6}), time.Minute,
+			nil, nil, nil, ErrTooManyBars,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := fillGaps(tt.in, tt.step)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if out.S != tt.in.S || !slices.Equal(out.Time, tt.wantTime) || !slices.Equal(out.Close, tt.wantClose) || !slices.Equal(out.Volume, tt.wantVolume) {
+				t.Fatalf("got %s t=%v c=%v v=%v, want t=%v c=%v v=%v", out.S, out.Time, out.Close, out.Volume, tt.wantTime, tt.wantClose, tt.wantVolume)
+			}
+			for i := range out.Len() {
+				if out.Volume[i] == 0 && (out.Open[i] != out.Close[i] || out.High[i] != out.Close[i] || out.Low[i] != out.Close[i]) {
+					t.Errorf("filled bar %d has OHLC %v %v %v %v, want the previous close throughout", i, out.Open[i], out.High[i], out.Low[i], out.Close[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResampleMinuteSeries(t *testing.T) {
+	// Minute i of a half hour from 16:30 UTC opens at i+0.5, ranges i to
+	// i+2, closes at i+1 and trades 1
+	const t0 = 1717000200
+	minutes := func(skip int) *Candles {
+		c := &Candles{S: "ok"}
+		for i := skip; i < 30; i++ {
+			c.Time = append(c.Time, t0+int64(i)*60)
+			c.Open = append(c.Open, float64(i)+0.5)
+			c.High = append(c.High, float64(i)+2)
+			c.Low = append(c.Low, float64(i))
+			c.Close = append(c.Close, float64(i)+1)
+			c.Volume = append(c.Volume, 1)
+		}
+		return c
+	}
+	tests := []struct {
+		name   string
+		skip   int // leading minutes missing
+		period time.Duration
+	}{
+		{"5m", 0, 5 * time.Minute},
+		{"15m", 0, 15 * time.Minute},
+		{"30m", 0, 30 * time.Minute},
+		{"5m starting mid-bucket", 2, 5 * time.Minute},
+		{"15m starting mid-bucket", 7, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, partial := resampleCandles(minutes(tt.skip), tt.period, time.Unix(t0+1800, 0))
+			if partial {
+				t.Error("partial, want the half hour complete")
+			}
+			size := int(tt.period / time.Minute)
+			if out.Len() != 30/size {
+				t.Fatalf("%d buckets, want %d", out.Len(), 30/size)
+			}
+			for k := range out.Len() {
+				first := max(k*size, tt.skip) // first minute in the bucket
+				last := (k+1)*size - 1
+				want := []float64{float64(first) + 0.5, float64(last) + 2, float64(first), float64(last) + 1, float64(last - first + 1)}
+				got := []float64{out.Open[k], out.High[k], out.Low[k], out.Close[k], out.Volume[k]}
+				if out.Time[k] != t0+int64(k*size*60) || !slices.Equal(got, want) {
+					t.Errorf("bucket %d at %d = %v, want %v at %d", k, out.Time[k], got, want, t0+int64(k*size*60))
+				}
+			}
+		})
+	}
+}
+
+func TestHeikinAshi(t *testing.T) {
+	// ohlc builds candles from o, h, l, c rows, trading 100 times the row
+	ohlc := func(rows ...[4]float64) *Candles {
+		c := &Candles{S: "ok"}
+		for i, r := range rows {
+			c.Time = append(c.Time, 1717000200+int64(i)*60)
+			c.Open = append(c.Open, r[0])
+			c.High = append(c.High, r[1])
+			c.Low = append(c.Low, r[2])
+			c.Close = append(c.Close, r[3])
+			c.Volume = append(c.Volume, float64(100*(i+1)))
+		}
+		return c
+	}
+	tests := []struct {
+		name string
+		in   *Candles
+		want [][4]float64 // o, h, l, c
+	}{
+		{"empty", ohlc(), nil},
+		{
+			// Seeded from the bar's own open and close
+			"single bar",
+			ohlc([4]float64{5, 6, 4, 5.5}),
+			[][4]float64{{5.25, 6, 4, 5.125}},
+		},
+		{
+			// Each open is the midpoint of the previous Heikin-Ashi bar; the
+			// last one's low stretches down to its open
+			"recursion",
+			ohlc([4]float64{10, 12, 9, 11}, [4]float64{11, 13, 10, 12}, [4]float64{12, 12.5, 11.8, 12.2}),
+			[][4]float64{{10.5, 12, 9, 10.5}, {10.5, 13, 10, 11.5}, {11, 12.5, 11, 12.125}},
+		},
+		{
+			"high stretches up to the open",
+			ohlc([4]float64{20, 21, 19, 20}, [4]float64{10, 11, 9, 10}),
+			[][4]float64{{20, 21, 19, 20}, {20, 20, 9, 10}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := heikinAshi(tt.in)
+			if out.S != tt.in.S || out.Len() != len(tt.want) {
+				t.Fatalf("status %q, %d bars, want %d", out.S, out.Len(), len(tt.want))
+			}
+			for i, w := range tt.want {
+				if got := [4]float64{out.Open[i], out.High[i], out.Low[i], out.Close[i]}; got != w {
+					t.Errorf("bar %d = %v, want %v", i, got, w)
+				}
+			}
+			if !slices.Equal(out.Time, tt.in.Time) || !slices.Equal(out.Volume, tt.in.Volume) {
+				t.Errorf("times %v, volumes %v; want %v, %v untouched", out.Time, out.Volume, tt.in.Time, tt.in.Volume)
+			}
+		})
+	}
+}
+
+func TestParseStyle(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", styleCandles, false},
+		{"candles", styleCandles, false},
+		{"heikin-ashi", styleHeikinAshi, false},
+		{"Heikin-Ashi", "", true},
+		{"heikinashi", "", true},
+		{"renko", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseStyle(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStyle(%q) = %q, %v; want %q, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// quoteCache keeps the last quote per symbol for a short TTL so repeated
+// lookups don't each cost an upstream call.
+type quoteCache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]cachedQuote
+}
+
+type cachedQuote struct {
+	quote   Quote
+	fetched time.Time
+}
+
+func newQuoteCache(ttl time.Duration) *quoteCache {
+	return &quoteCache{ttl: ttl, entries: make(map[string]cachedQuote)}
+}
+
+// get returns a copy of the cached quote if it is still fresh
+func (c *quoteCache) get(symbol string) (*Quote, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[symbol]
+	c.mu.RUnlock()
+	if !ok || time.Since(e.fetched) > c.ttl {
+		return nil, false
+	}
+	q := e.quote
+	return &q, true
+}
+
+func (c *quoteCache) put(symbol string, q *Quote) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[symbol] = cachedQuote{quote: *q, fetched: time.Now()}
+}
+
+// cachedProvider serves quotes from a quoteCache, falling through to the
+// wrapped Provider on a miss. Candles are passed through untouched.
+type cachedProvider struct {
+	Provider
+	quotes *quoteCache
+}
+
+func newCachedProvider(p Provider, ttl time.Duration) *cachedProvider {
+	return &cachedProvider{Provider: p, quotes: newQuoteCache(ttl)}
+}
+
+func (p *cachedProvider) Quote(ctx context.Context, symbol string) (*Quote, error) {
+	if q, ok := p.quotes.get(symbol); ok {
+		return q, nil
+	}
+	q, err := p.Provider.Quote(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	p.quotes.put(symbol, q)
+	return q, nil
+}
+
+// Most keys each lookup cache (search, profiles) holds
+const lookupCacheSize = 1000
+
+// ttlCache is a small map of values that expire after ttl, for lookups
+// that change far more slowly than quotes. It holds at most size entries.
+type ttlCache[V any] struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[string]ttlEntry[V]
+}
+
+type ttlEntry[V any] struct {
+	v       V
+	fetched time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration, size int) *ttlCache[V] {
+	return &ttlCache[V]{ttl: ttl, size: size, entries: make(map[string]ttlEntry[V])}
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.fetched) > c.ttl {
+		var zero V
+		return zero, false
+	}
+	return e.v, true
+}
+
+// put stores v, first dropping expired entries when the cache is full
+// and, failing that, an arbitrary one.
+func (c *ttlCache[V]) put(key string, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		for k, e := range c.entries {
+			if time.Since(e.fetched) > c.ttl {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = ttlEntry[V]{v: v, fetched: time.Now()}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachedQuoteRoundTrips(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		status int // the upstream's reply
+		want   int32
+	}{
+		{"fresh within ttl", time.Hour, http.StatusOK, 1},
+		{"expired", time.Nanosecond, http.StatusOK, 2},
+		{"errors are not cached", time.Hour, http.StatusNotFound, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				if tt.status != http.StatusOK {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write([]byte(`{"c":190.1,"h":191,"l":187.5,"o":188.2,"pc":188}`))
+			}))
+			defer ts.Close()
+			fh := NewFinnhubProvider("test")
+			fh.baseURL = ts.URL
+			p := newCachedProvider(fh, tt.ttl)
+
+			for range 2 {
+				q, err := p.Quote(context.Background(), "AAPL")
+				if tt.status == http.StatusOK && (err != nil || q.Current != 190.1) {
+					t.Fatalf("Quote = %v, %v", q, err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if n := calls.Load(); n != tt.want {
+				t.Errorf("%d upstream round-trips, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteCacheReturnsCopies(t *testing.T) {
+	c := newQuoteCache(time.Hour)
+	c.put("AAPL", &Quote{Current: 1})
+	q, _ := c.get("AAPL")
+	q.Current = 2
+	if q, _ := c.get("AAPL"); q.Current != 1 {
+		t.Errorf("cached quote changed to %v through a returned copy", q.Current)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ranges /api/change can compare the current price over
+var changeRanges = map[string]time.Duration{
+	"1w": 7 * day,
+	"1m": 30 * day,
+	"3m": 91 * day,
+	"6m": 182 * day,
+	"1y": 365 * day,
+}
+
+// Extra days of daily candles fetched before the longest range, so one
+// that starts on a weekend or holiday still finds an earlier close
+const changeRangeSlack = 10 * day
+
+// GET /api/change?symbol=AAPL
+// GET /api/change?symbol=AAPL&ranges=1w,1m
+// Today's move from the quote. change and changePercent are left out when
+// there is no previous close (a new listing, or bad data):
+//
+//	{"symbol":"AAPL","current":190.1,"prevClose":188,"change":2.1,
+//	 "changePercent":1.12,"dayHigh":191,"dayLow":187.5,"dayOpen":188.2}
+//
+// ranges (any of 1w, 1m, 3m, 6m, 1y) adds the move since the last daily
+// close at or before the start of each, or null where the symbol has no
+// candles that far back:
+//
+//	"ranges":{"1w":{"since":1716768000,"close":185.3,"change":4.8,
+//	 "changePercent":2.59},"1y":null}
+func (s *server) handleChange(w http.ResponseWriter, r *http.Request) {
+	symbol, err := querySymbol(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	var ranges []string
+	if v := r.URL.Query().Get("ranges"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := changeRanges[name]; !ok {
+				badRequest(w, fmt.Sprintf("unknown range %q; valid ranges are 1w, 1m, 3m, 6m, 1y", name))
+				return
+			}
+			if !slices.Contains(ranges, name) {
+				ranges = append(ranges, name)
+			}
+		}
+	}
+
+	q, err := s.provider.Quote(r.Context(), symbol)
+	if err != nil {
+		badGateway(w, r, err)
+		return
+	}
+	if q.Empty() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown_symbol"})
+		return
+	}
+	resp := map[string]any{
+		"symbol":    symbol,
+		"current":   q.Current,
+		"prevClose": q.PrevClose,
+		"dayHigh":   q.High,
+		"dayLow":    q.Low,
+		"dayOpen":   q.Open,
+	}
+	addChange(resp, q.PrevClose, q.Current)
+	if len(ranges) == 0 {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	var longest time.Duration
+	for _, name := range ranges {
+		longest = max(longest, changeRanges[name])
+	}
+	now := time.Now()
+	c, err := s.provider.Candles(r.Context(), symbol, now.Add(-longest-changeRangeSlack), now, "D")
+	if err != nil {
+		badGateway(w, r, err)
+		return
+	}
+	out := make(map[string]any, len(ranges))
+	for _, name := range ranges {
+		out[name] = nil
+		if c.S != "ok" {
+			continue
+		}
+		// The last close at or before the range's start
+		start := now.Add(-changeRanges[name]).Unix()
+		i, _ := slices.BinarySearch(c.Time[:c.Len()], start+1)
+		if i == 0 {
+			continue // no candle that old: a younger listing
+		}
+		ref := map[string]any{"since": c.Time[i-1], "close": c.Close[i-1]}
+		addChange(ref, c.Close[i-1], q.Current)
+		out[name] = ref
+	}
+	resp["ranges"] = out
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// addChange sets m's change and changePercent for a move from base to v,
+// leaving both out when base is 0.
+func addChange(m map[string]any, base, v float64) {
+	pct, ok := percentChange(base, v)
+	if !ok {
+		return
+	}
+	m["change"] = round2(v - base)
+	m["changePercent"] = pct
+}
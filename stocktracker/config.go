@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults used when the corresponding env var is unset
+const (
+	// Rate: be mindful of Finnhub free-tier limits
+	defaultPollInterval    = 5 * time.Second
+	defaultMinPollInterval = 1 * time.Second
+	defaultMaxPollInterval = 5 * time.Minute
+	defaultQuoteCacheTTL   = 3 * time.Second
+	defaultFinnhubRate     = 60 // free tier quota, calls per minute
+	defaultFinnhubRetries  = 2
+	defaultFinnhubBackoff  = 5 * time.Second
+	defaultPingPeriod      = 30 * time.Second
+	defaultHeartbeat       = 15 * time.Second
+	defaultForceSend       = 60 * time.Second
+	defaultMaxConns        = 1000
+	defaultMaxConnsPerIP   = 20
+	defaultMaxSubs         = 20
+	defaultMaxSymbols      = 100
+	defaultNewsLimit       = 50
+	defaultBufferSize      = 1024
+	defaultHandshake       = 10 * time.Second
+	defaultWriteWait       = 5 * time.Second
+	defaultMaxMessageSize  = 8 << 10
+	defaultServerAddr      = ":8080"
+	defaultStaticDir       = "./static"
+)
+
+// Config holds the runtime settings of the server.
+type Config struct {
+	APIKey       string        // FINNHUB_API_KEY
+	ServerAddr   string        // SERVER_ADDR
+	PollInterval time.Duration // POLL_INTERVAL, e.g. "5s"
+	StaticDir    string        // STATIC_DIR
+
+	// Bounds for the per-connection ?interval= override
+	MinPollInterval time.Duration // POLL_INTERVAL_MIN
+	MaxPollInterval time.Duration // POLL_INTERVAL_MAX
+
+	QuoteCacheTTL time.Duration // QUOTE_CACHE_TTL, 0 disables the cache
+
+	// Finnhub REST calls allowed per minute; 0 disables the limiter
+	FinnhubRate int // FINNHUB_RATE_LIMIT
+
+	// Retries of a Finnhub call that failed with a network error, 5xx or
+	// 429; 429s wait out Retry-After, the rest back off exponentially, and
+	// no wait exceeds FinnhubMaxBackoff
+	FinnhubRetries    int           // FINNHUB_RETRIES
+	FinnhubMaxBackoff time.Duration // FINNHUB_MAX_BACKOFF
+
+	// Use Finnhub's trade WebSocket, with REST polling as the fallback
+	Stream bool // FINNHUB_STREAM
+
+	// How often /ws clients are pinged; see pongWait
+	PingPeriod time.Duration // WS_PING_PERIOD
+
+	// Quiet time after which /ws clients get a heartbeat message; 0 disables
+	Heartbeat time.Duration // WS_HEARTBEAT
+
+	// Unchanged quotes are still re-sent this often
+	ForceSend time.Duration // WS_FORCE_SEND
+
+	// Negotiate permessage-deflate with /ws clients that offer it
+	Compression bool // WS_COMPRESSION
+
+	// Caps on simultaneous /ws connections
+	MaxConns      int // WS_MAX_CONNS
+	MaxConnsPerIP int // WS_MAX_CONNS_PER_IP
+
+	// Caps on polled symbols: per /ws connection, and distinct ones
+	// across the server, which bounds Finnhub usage
+	MaxSubscriptions int // WS_MAX_SUBSCRIPTIONS
+	MaxSymbols       int // MAX_SYMBOLS
+
+	// Most articles /api/news returns
+	NewsLimit int // NEWS_LIMIT
+
+	// WebSocket transport limits
+	ReadBufferSize   int           // WS_READ_BUFFER, bytes
+	WriteBufferSize  int           // WS_WRITE_BUFFER, bytes
+	HandshakeTimeout time.Duration // WS_HANDSHAKE_TIMEOUT
+	WriteWait        time.Duration // WS_WRITE_WAIT, bound on every write
+	MaxMessageSize   int64         // WS_MAX_MESSAGE, largest inbound frame in bytes
+
+	// Tokens accepted by /ws and /api; empty leaves the app open
+	AuthTokens []string // AUTH_TOKENS, comma-separated
+
+	// Origins besides the server's own that may call the API (CORS) and
+	// open /ws, e.g. https://app.example.com, https://*.example.com or *
+	AllowedOrigins []string // ALLOWED_ORIGINS (formerly WS_ALLOWED_ORIGINS), comma-separated
+	AllowNoOrigin  bool     // WS_ALLOW_NO_ORIGIN: admit clients that send no Origin
+
+	// SQLite file that records every streamed quote; empty disables history
+	DBPath string // QUOTE_DB
+
+	// JSON file the watchlist is kept in; empty disables /api/watchlist
+	WatchlistPath string // WATCHLIST_FILE
+
+	// Alert webhook hosts exempt from the public address check, for
+	// receivers on the server's own network
+	WebhookAllowedHosts []string // WEBHOOK_ALLOWED_HOSTS, comma-separated
+
+	// Least severe level logged: debug, info, warn or error
+	LogLevel slog.Level // LOG_LEVEL
+}
+
+// cfg is the active configuration, set once in main().
+var cfg Config
+
+// loadConfig reads the configuration from the environment,
+// falling back to the defaults above for unset values.
+func loadConfig() (Config, error) {
+	c := Config{
+		APIKey:     os.Getenv("FINNHUB_API_KEY"),
+		ServerAddr: envOr("SERVER_ADDR", defaultServerAddr),
+		StaticDir:  envOr("STATIC_DIR", defaultStaticDir),
+		AuthTokens: envList("AUTH_TOKENS"),
+		DBPath:     os.Getenv("QUOTE_DB"),
+
+		WatchlistPath:       os.Getenv("WATCHLIST_FILE"),
+		WebhookAllowedHosts: envList("WEBHOOK_ALLOWED_HOSTS"),
+
+		AllowedOrigins: envList("ALLOWED_ORIGINS"),
+	}
+
+	if len(c.AllowedOrigins) == 0 {
+		c.AllowedOrigins = envList("WS_ALLOWED_ORIGINS")
+	}
+
+	var err error
+	if c.LogLevel, err = envLogLevel("LOG_LEVEL", slog.LevelInfo); err != nil {
+		return c, err
+	}
+	if c.Stream, err = envBool("FINNHUB_STREAM", true); err != nil {
+		return c, err
+	}
+	if c.AllowNoOrigin, err = envBool("WS_ALLOW_NO_ORIGIN", true); err != nil {
+		return c, err
+	}
+	if c.Compression, err = envBool("WS_COMPRESSION", true); err != nil {
+		return c, err
+	}
+	if c.PollInterval, err = envDuration("POLL_INTERVAL", defaultPollInterval); err != nil {
+		return c, err
+	}
+	if c.MinPollInterval, err = envDuration("POLL_INTERVAL_MIN", defaultMinPollInterval); err != nil {
+		return c, err
+	}
+	if c.MaxPollInterval, err = envDuration("POLL_INTERVAL_MAX", defaultMaxPollInterval); err != nil {
+		return c, err
+	}
+	if c.QuoteCacheTTL, err = envDuration("QUOTE_CACHE_TTL", defaultQuoteCacheTTL); err != nil {
+		return c, err
+	}
+	if c.PingPeriod, err = envDuration("WS_PING_PERIOD", defaultPingPeriod); err != nil {
+		return c, err
+	}
+	if c.Heartbeat, err = envDuration("WS_HEARTBEAT", defaultHeartbeat); err != nil {
+		return c, err
+	}
+	if c.ForceSend, err = envDuration("WS_FORCE_SEND", defaultForceSend); err != nil {
+		return c, err
+	}
+	if c.MaxConns, err = envInt("WS_MAX_CONNS", defaultMaxConns); err != nil {
+		return c, err
+	}
+	if c.MaxConnsPerIP, err = envInt("WS_MAX_CONNS_PER_IP", defaultMaxConnsPerIP); err != nil {
+		return c, err
+	}
+	if c.FinnhubRate, err = envInt("FINNHUB_RATE_LIMIT", defaultFinnhubRate); err != nil {
+		return c, err
+	}
+	if c.FinnhubRetries, err = envInt("FINNHUB_RETRIES", defaultFinnhubRetries); err != nil {
+		return c, err
+	}
+	if c.FinnhubMaxBackoff, err = envDuration("FINNHUB_MAX_BACKOFF", defaultFinnhubBackoff); err != nil {
+		return c, err
+	}
+	if c.MaxSubscriptions, err = envInt("WS_MAX_SUBSCRIPTIONS", defaultMaxSubs); err != nil {
+		return c, err
+	}
+	if c.MaxSymbols, err = envInt("MAX_SYMBOLS", defaultMaxSymbols); err != nil {
+		return c, err
+	}
+	if c.NewsLimit, err = envInt("NEWS_LIMIT", defaultNewsLimit); err != nil {
+		return c, err
+	}
+	if c.ReadBufferSize, err = envInt("WS_READ_BUFFER", defaultBufferSize); err != nil {
+		return c, err
+	}
+	if c.WriteBufferSize, err = envInt("WS_WRITE_BUFFER", defaultBufferSize); err != nil {
+		return c, err
+	}
+	if c.HandshakeTimeout, err = envDuration("WS_HANDSHAKE_TIMEOUT", defaultHandshake); err != nil {
+		return c, err
+	}
+	if c.WriteWait, err = envDuration("WS_WRITE_WAIT", defaultWriteWait); err != nil {
+		return c, err
+	}
+	maxMessage, err := envInt("WS_MAX_MESSAGE", defaultMaxMessageSize)
+	if err != nil {
+		return c, err
+	}
+	c.MaxMessageSize = int64(maxMessage)
+	return c, nil
+}
+
+// validate checks settings that may come from either env vars or flags
+func (c Config) validate() error {
+	if c.APIKey == "" {
+		return errors.New("FINNHUB_API_KEY is not set; get a free key at https://finnhub.io")
+	}
+	if c.MinPollInterval <= 0 || c.MaxPollInterval < c.MinPollInterval {
+		return fmt.Errorf("poll interval bounds must satisfy 0 < min <= max, got %s..%s",
+			c.MinPollInterval, c.MaxPollInterval)
+	}
+	if c.QuoteCacheTTL < 0 {
+		return fmt.Errorf("QUOTE_CACHE_TTL must not be negative, got %s", c.QuoteCacheTTL)
+	}
+	if c.FinnhubRate < 0 {
+		return fmt.Errorf("FINNHUB_RATE_LIMIT must not be negative, got %d", c.FinnhubRate)
+	}
+	if c.FinnhubRetries < 0 || c.FinnhubMaxBackoff <= 0 {
+		return fmt.Errorf("FINNHUB_RETRIES must not be negative and FINNHUB_MAX_BACKOFF must be positive, got %d, %s",
+			c.FinnhubRetries, c.FinnhubMaxBackoff)
+	}
+	if c.PingPeriod <= 0 {
+		return fmt.Errorf("WS_PING_PERIOD must be positive, got %s", c.PingPeriod)
+	}
+	if c.Heartbeat < 0 {
+		return fmt.Errorf("WS_HEARTBEAT must not be negative, got %s", c.Heartbeat)
+	}
+	if c.ForceSend <= 0 {
+		return fmt.Errorf("WS_FORCE_SEND must be positive, got %s", c.ForceSend)
+	}
+	if c.MaxConns <= 0 || c.MaxConnsPerIP <= 0 {
+		return fmt.Errorf("connection caps must be positive, got %d total, %d per IP",
+			c.MaxConns, c.MaxConnsPerIP)
+	}
+	if c.MaxSubscriptions <= 0 || c.MaxSymbols <= 0 {
+		return fmt.Errorf("symbol caps must be positive, got %d per connection, %d in total",
+			c.MaxSubscriptions, c.MaxSymbols)
+	}
+	if c.NewsLimit <= 0 {
+		return fmt.Errorf("NEWS_LIMIT must be positive, got %d", c.NewsLimit)
+	}
+	if c.ReadBufferSize <= 0 || c.WriteBufferSize <= 0 || c.MaxMessageSize <= 0 {
+		return fmt.Errorf("WebSocket buffer and message sizes must be positive, got read=%d write=%d max=%d",
+			c.ReadBufferSize, c.WriteBufferSize, c.MaxMessageSize)
+	}
+	if c.HandshakeTimeout <= 0 || c.WriteWait <= 0 {
+		return fmt.Errorf("WebSocket timeouts must be positive, got handshake=%s write=%s",
+			c.HandshakeTimeout, c.WriteWait)
+	}
+	for _, p := range c.AllowedOrigins {
+		if err := validateOriginPattern(p); err != nil {
+			return err
+		}
+	}
+	if c.PollInterval < c.MinPollInterval || c.PollInterval > c.MaxPollInterval {
+		return fmt.Errorf("poll interval %s is outside %s..%s",
+			c.PollInterval, c.MinPollInterval, c.MaxPollInterval)
+	}
+	return nil
+}
+
+// pongWait is how long a WebSocket peer may stay silent before it is
+// considered dead: two ping periods, so one lost pong is tolerated.
+func (c Config) pongWait() time.Duration {
+	return 2 * c.PingPeriod
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits a comma-separated variable, dropping blanks
+func envList(key string) []string {
+	var out []string
+	for v := range strings.SplitSeq(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func envBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", key, err)
+	}
+	return b, nil
+}
+
+func envInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+func envLogLevel(key string, def slog.Level) (slog.Level, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(v)); err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return l, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPingPeriodConfig(t *testing.T) {
+	tests := []struct {
+		env      string
+		want     time.Duration
+		pongWait time.Duration
+		wantErr  bool
+	}{
+		{"", defaultPingPeriod, 2 * defaultPingPeriod, false},
+		{"10s", 10 * time.Second, 20 * time.Second, false},
+		{"0", 0, 0, true},
+		{"-1s", 0, 0, true},
+		{"often", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.env, "unset"), func(t *testing.T) {
+			t.Setenv("WS_PING_PERIOD", tt.env)
+			c, err := loadConfig()
+			if err == nil {
+				c.APIKey = "test"
+				err = c.validate()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c.PingPeriod != tt.want || c.pongWait() != tt.pongWait {
+				t.Errorf("ping period %v, pong wait %v; want %v, %v", c.PingPeriod, c.pongWait(), tt.want, tt.pongWait)
+			}
+		})
+	}
+}
+
+func TestWSTransportConfig(t *testing.T) {
+	tests := []struct {
+		env, value string
+		wantErr    bool
+	}{
+		{"WS_READ_BUFFER", "4096", false},
+		{"WS_READ_BUFFER", "0", true},
+		{"WS_WRITE_BUFFER", "-1", true},
+		{"WS_HANDSHAKE_TIMEOUT", "3s", false},
+		{"WS_HANDSHAKE_TIMEOUT", "0s", true},
+		{"WS_WRITE_WAIT", "250ms", false},
+		{"WS_WRITE_WAIT", "-1s", true},
+		{"WS_MAX_MESSAGE", "512", false},
+		{"WS_MAX_MESSAGE", "0", true},
+		{"WS_MAX_MESSAGE", "big", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			c, err := loadConfig()
+			if err == nil {
+				c.APIKey = "test"
+				err = c.validate()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ReadBufferSize != defaultBufferSize || c.WriteBufferSize != defaultBufferSize ||
+		c.HandshakeTimeout != defaultHandshake || c.WriteWait != defaultWriteWait || c.MaxMessageSize != defaultMaxMessageSize {
+		t.Errorf("defaults %d/%d/%s/%s/%d", c.ReadBufferSize, c.WriteBufferSize, c.HandshakeTimeout, c.WriteWait, c.MaxMessageSize)
+	}
+}
+
+func TestSubscriptionCapsConfig(t *testing.T) {
+	tests := []struct {
+		env, value string
+		wantErr    bool
+	}{
+		{"WS_MAX_SUBSCRIPTIONS", "1", false},
+		{"WS_MAX_SUBSCRIPTIONS", "0", true},
+		{"WS_MAX_SUBSCRIPTIONS", "many", true},
+		{"MAX_SYMBOLS", "500", false},
+		{"MAX_SYMBOLS", "-5", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			c, err := loadConfig()
+			if err == nil {
+				c.APIKey = "test"
+				err = c.validate()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFinnhubRateConfig(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", defaultFinnhubRate, false},
+		{"300", 300, false},
+		{"0", 0, false}, // no limiter
+		{"-1", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.env, "unset"), func(t *testing.T) {
+			t.Setenv("FINNHUB_RATE_LIMIT", tt.env)
+			c, err := loadConfig()
+			if err == nil {
+				c.APIKey = "test"
+				err = c.validate()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && c.FinnhubRate != tt.want {
+				t.Errorf("rate %d, want %d", c.FinnhubRate, tt.want)
+			}
+		})
+	}
+}
+
+func TestFinnhubRetryConfig(t *testing.T) {
+	tests := []struct {
+		retries, backoff string
+		wantErr          bool
+	}{
+		{"0", "1s", false},
+		{"5", "30s", false},
+		{"-1", "1s", true},
+		{"2", "0s", true},
+		{"two", "1s", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.retries+"/"+tt.backoff, func(t *testing.T) {
+			t.Setenv("FINNHUB_RETRIES", tt.retries)
+			t.Setenv("FINNHUB_MAX_BACKOFF", tt.backoff)
+			c, err := loadConfig()
+			if err == nil {
+				c.APIKey = "test"
+				err = c.validate()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && strconv.Itoa(c.FinnhubRetries) != tt.retries {
+				t.Errorf("retries %d, want %s", c.FinnhubRetries, tt.retries)
+			}
+		})
+	}
+}
+
+func TestAllowedOriginsConfig(t *testing.T) {
+	tests := []struct {
+		name, current, legacy string
+		want                  []string
+		wantErr               bool
+	}{
+		{"unset", "", "", nil, false},
+		{"list", " https://a.example.com, http://localhost:3000 ,", "", []string{"https://a.example.com", "http://localhost:3000"}, false},
+		{"wildcards", "*,https://*.example.com", "", []string{"*", "https://*.example.com"}, false},
+		{"legacy name", "", "https://old.example.com", []string{"https://old.example.com"}, false},
+		{"current name wins", "https://new.example.com", "https://old.example.com", []string{"https://new.example.com"}, false},
+		{"no scheme", "example.com", "", nil, true},
+		{"with a path", "https://example.com/app", "", nil, true},
+		{"misplaced wildcard", "https://app.*.com", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOWED_ORIGINS", tt.current)
+			t.Setenv("WS_ALLOWED_ORIGINS", tt.legacy)
+			c, err := loadConfig()
+			if err == nil {
+				c.APIKey = "test"
+				err = c.validate()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(c.AllowedOrigins, tt.want) {
+				t.Errorf("origins %q, want %q", c.AllowedOrigins, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Fields of a quote message a client may pick; "symbol" is always sent
+var quoteFields = []string{"price", "time", "open", "high", "low", "prevClose", "change", "changePercent"}
+
+// parseFields checks names against valid, dropping blanks and repeats.
+// No names at all (nil) means every field.
+func parseFields(names []string, valid []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	out := []string{}
+	for _, f := range names {
+		f = strings.TrimSpace(f)
+		if f == "" || f == "symbol" || slices.Contains(out, f) {
+			continue
+		}
+		if !slices.Contains(valid, f) {
+			return nil, fmt.Errorf("unknown field %q; valid fields are %s", f, strings.Join(valid, ", "))
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// shapeFields trims msg to its symbol, type and seq plus fields, in place.
+// nil fields leaves msg whole.
+func shapeFields(msg map[string]any, fields []string) map[string]any {
+	if fields == nil {
+		return msg
+	}
+	for k := range msg {
+		if k != "symbol" && k != "type" && k != "seq" && !slices.Contains(fields, k) {
+			delete(msg, k)
+		}
+	}
+	return msg
+}
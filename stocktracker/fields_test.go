@@ -0,0 +1,141 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    []string
+		wantErr bool
+	}{
+		{"none means all", nil, nil, false},
+		{"empty list means all", []string{}, nil, false},
+		{"one", []string{"price"}, []string{"price"}, false},
+		{"several in order", []string{"changePercent", "price"}, []string{"changePercent", "price"}, false},
+		{"blanks and repeats", []string{" price", "", "price ", "high"}, []string{"price", "high"}, false},
+		{"symbol is implied", []string{"symbol", "price"}, []string{"price"}, false},
+		{"only symbol", []string{"symbol"}, []string{}, false},
+		{"unknown", []string{"price", "volume"}, nil, true},
+		{"wrong case", []string{"Price"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFields(tt.in, quoteFields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				for _, f := range quoteFields {
+					if !strings.Contains(err.Error(), f) {
+						t.Errorf("error %q doesn't list %s", err, f)
+					}
+				}
+				return
+			}
+			if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("parseFields(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteFrameFields(t *testing.T) {
+	u := quoteUpdate{
+		Symbol: "AAPL",
+		Quote:  &Quote{Current: 110, Open: 100, High: 112, Low: 99, PrevClose: 100},
+		Time:   time.UnixMilli(1717000000000),
+		Seq:    7,
+	}
+	tests := []struct {
+		name   string
+		fields []string
+		want   []string // keys besides symbol and seq
+	}{
+		{"everything", nil, quoteFields},
+		{"mobile", []string{"price"}, []string{"price"}},
+		{"ticker", []string{"price", "changePercent"}, []string{"price", "changePercent"}},
+		{"range", []string{"high", "low", "open", "prevClose"}, []string{"high", "low", "open", "prevClose"}},
+		{"change only", []string{"change", "changePercent"}, []string{"change", "changePercent"}},
+		{"bare", []string{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := quoteFrame(u, tt.fields)
+			want := append([]string{"symbol", "seq"}, tt.want...)
+			if got := slices.Sorted(maps.Keys(msg)); !slices.Equal(got, slices.Sorted(slices.Values(want))) {
+				t.Errorf("keys %v, want %v", got, want)
+			}
+			if msg["symbol"] != "AAPL" || msg["seq"] != uint64(7) {
+				t.Errorf("frame %v lost its symbol or seq", msg)
+			}
+			if v, ok := msg["changePercent"]; ok && v != 10.0 {
+				t.Errorf("changePercent = %v, want 10", v)
+			}
+		})
+	}
+}
+
+func TestWSFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		subscribe map[string]any // sent after connecting, if set
+		wantKeys  []string       // of the first quote
+		wantError bool
+	}{
+		{"default", "", nil, append([]string{"symbol", "seq"}, quoteFields...), false},
+		{"on connect", "?fields=price,changePercent", nil, []string{"symbol", "seq", "price", "changePercent"}, false},
+		{"unknown on connect", "?fields=price,bogus", nil, append([]string{"symbol", "seq"}, quoteFields...), true},
+		{"in subscribe", "?symbols=MSFT", map[string]any{"action": "subscribe", "symbol": "AAPL", "fields": []string{"price"}},
+			[]string{"symbol", "seq", "price"}, false},
+		{"unknown in subscribe", "?symbols=MSFT", map[string]any{"action": "subscribe", "symbol": "AAPL", "fields": []string{"bid"}},
+			nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, url := wsServer(t, (&countingFetch{}).fetch)
+			conn := dialWS(t, url+tt.query)
+			if tt.subscribe != nil {
+				if err := conn.WriteJSON(tt.subscribe); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var sawError bool
+			for {
+				var m map[string]any
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if err := conn.ReadJSON(&m); err != nil {
+					t.Fatal(err)
+				}
+				if m["type"] == "error" {
+					sawError = true
+					if msg, _ := m["message"].(string); !strings.Contains(msg, "changePercent") {
+						t.Errorf("error %q doesn't list the valid fields", msg)
+					}
+					if tt.wantKeys == nil {
+						break
+					}
+					continue
+				}
+				if m["type"] != "quote" || m["symbol"] != "AAPL" || tt.wantKeys == nil {
+					continue
+				}
+				delete(m, "type")
+				if got := slices.Sorted(maps.Keys(m)); !slices.Equal(got, slices.Sorted(slices.Values(tt.wantKeys))) {
+					t.Errorf("quote keys %v, want %v", got, tt.wantKeys)
+				}
+				break
+			}
+			if sawError != tt.wantError {
+				t.Errorf("error frame %t, want %t", sawError, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	finnhubBaseURL = "https://finnhub.io/api/v1"
+
+	// Most bars one Candles call assembles across its chunks
+	maxCandleBars = 100_000
+
+	// First backoff before retrying a transient failure; it doubles with
+	// each attempt up to the provider's maxBackoff
+	finnhubRetryBase = 250 * time.Millisecond
+)
+
+// Longest window one Finnhub candle call covers at each intraday
+// resolution; longer windows are fetched in chunks of this size
+var candleChunks = map[string]time.Duration{
+	"1":  7 * day,
+	"5":  30 * day,
+	"15": 30 * day,
+	"30": 30 * day,
+	"60": 30 * day,
+}
+
+// FinnhubProvider implements Provider on top of Finnhub's REST API.
+type FinnhubProvider struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+	limiter *tokenBucket // optional; paces calls to the plan's quota
+
+	// A call that fails transiently is retried up to retries times,
+	// waiting never more than maxBackoff between attempts
+	retries    int
+	maxBackoff time.Duration
+}
+
+func NewFinnhubProvider(apiKey string) *FinnhubProvider {
+	return &FinnhubProvider{
+		apiKey:  apiKey,
+		baseURL: finnhubBaseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+
+		retries:    defaultFinnhubRetries,
+		maxBackoff: defaultFinnhubBackoff,
+	}
+}
+
+func (p *FinnhubProvider) Quote(ctx context.Context, symbol string) (*Quote, error) {
+	var q Quote
+	if err := p.get(ctx, "/quote", url.Values{"symbol": {symbol}}, &q); err != nil {
+		return nil, fmt.Errorf("quote: %w", err)
+	}
+	return &q, nil
+}
+
+// Candles fetches bars in from..to. An intraday window longer than one
+// call may cover is fetched as consecutive chunks, each paced by the
+// limiter like any call, and stitched together; the whole may hold at most
+// maxCandleBars.
+func (p *FinnhubProvider) Candles(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+	chunk := candleChunks[resolution]
+	if chunk == 0 || to.Sub(from) <= chunk {
+		return p.candles(ctx, symbol, from, to, resolution)
+	}
+	out := &Candles{S: "no_data"}
+	for start := from; start.Before(to); start = start.Add(chunk) {
+		end := start.Add(chunk)
+		if end.After(to) {
+			end = to
+		}
+		c, err := p.candles(ctx, symbol, start, end, resolution)
+		if err != nil {
+			return nil, err
+		}
+		if c.S != "ok" {
+			continue
+		}
+		out.S = "ok"
+		out.appendAfter(c)
+		if out.Len() > maxCandleBars {
+			return nil, fmt.Errorf("candle: %w (over %d)", ErrTooManyBars, maxCandleBars)
+		}
+	}
+	return out, nil
+}
+
+// candles makes a single /stock/candle call
+func (p *FinnhubProvider) candles(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+	params := url.Values{
+		"symbol":     {symbol},
+		"resolution": {resolution},
+		"from":       {fmt.Sprint(from.Unix())},
+		"to":         {fmt.Sprint(to.Unix())},
+	}
+	var c Candles
+	if err := p.get(ctx, "/stock/candle", params, &c); err != nil {
+		return nil, fmt.Errorf("candle: %w", err)
+	}
+	return &c, nil
+}
+
+func (p *FinnhubProvider) Search(ctx context.Context, query string) ([]SymbolMatch, error) {
+	var r struct {
+		Result []SymbolMatch `json:"result"`
+	}
+	if err := p.get(ctx, "/search", url.Values{"q": {query}}, &r); err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	return r.Result, nil
+}
+
+func (p *FinnhubProvider) Profile(ctx context.Context, symbol string) (*Profile, error) {
+	var pr Profile
+	if err := p.get(ctx, "/stock/profile2", url.Values{"symbol": {symbol}}, &pr); err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	return &pr, nil
+}
+
+// News returns articles about symbol published on the days from through
+// to, as Finnhub counts them (UTC dates).
+func (p *FinnhubProvider) News(ctx context.Context, symbol string, from, to time.Time) ([]NewsItem, error) {
+	params := url.Values{
+		"symbol": {symbol},
+		"from":   {from.Format(time.DateOnly)},
+		"to":     {to.Format(time.DateOnly)},
+	}
+	var items []NewsItem
+	if err := p.get(ctx, "/company-news", params, &items); err != nil {
+		return nil, fmt.Errorf("news: %w", err)
+	}
+	return items, nil
+}
+
+func (p *FinnhubProvider) MarketStatus(ctx context.Context, exchange string) (*ExchangeStatus, error) {
+	var st ExchangeStatus
+	if err := p.get(ctx, "/stock/market-status", url.Values{"exchange": {exchange}}, &st); err != nil {
+		return nil, fmt.Errorf("market status: %w", err)
+	}
+	return &st, nil
+}
+
+func (p *FinnhubProvider) Metrics(ctx context.Context, symbol string) (*Metrics, error) {
+	var r struct {
+		Metric Metrics `json:"metric"`
+	}
+	if err := p.get(ctx, "/stock/metric", url.Values{"symbol": {symbol}, "metric": {"all"}}, &r); err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
+	}
+	return &r.Metric, nil
+}
+
+// get issues a GET to path and decodes the JSON body into v, retrying
+// transient failures (see retryable). A retry that couldn't happen before
+// ctx's deadline is skipped.
+func (p *FinnhubProvider) get(ctx context.Context, path string, params url.Values, v any) error {
+	for attempt := 0; ; attempt++ {
+		err := p.call(ctx, path, params, v)
+		if err == nil || attempt >= p.retries || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		wait := p.backoff(attempt, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		slog.Debug("finnhub call failed, retrying", "path", path, "err", err, "wait_ms", wait.Milliseconds())
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// retryable reports whether a failed call is worth repeating: network
+// errors, 5xx and Finnhub's own 429s are; other 4xx, bad bodies and the
+// local limiter's refusals are not.
+func retryable(err error) bool {
+	var rl *RateLimitError
+	if errors.As(err, &rl) {
+		return !rl.Local
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// backoff is the wait before retry attempt+1: Finnhub's Retry-After for a
+// 429, otherwise exponential from finnhubRetryBase with full jitter; never
+// more than maxBackoff.
+func (p *FinnhubProvider) backoff(attempt int, err error) time.Duration {
+	var rl *RateLimitError
+	if errors.As(err, &rl) {
+		if rl.RetryAfter > 0 {
+			return min(rl.RetryAfter, p.maxBackoff)
+		}
+		return p.maxBackoff
+	}
+	ceil := min(finnhubRetryBase<<min(attempt, 16), p.maxBackoff)
+	return time.Duration(rand.Int64N(int64(ceil)) + 1)
+}
+
+// call makes one attempt, recording it in the finnhub_* metrics
+func (p *FinnhubProvider) call(ctx context.Context, path string, params url.Values, v any) error {
+	if p.limiter != nil {
+		if err := p.limiter.Wait(ctx, finnhubMaxWait); err != nil {
+			finnhubCalls.WithLabelValues(path, "throttled").Inc()
+			return err
+		}
+	}
+	start := time.Now()
+	err := p.do(ctx, path, params, v)
+	outcome := "ok"
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		outcome = "rate_limited"
+	case err != nil:
+		outcome = "error"
+	}
+	finnhubCalls.WithLabelValues(path, outcome).Inc()
+	finnhubLatency.WithLabelValues(path).Observe(time.Since(start).Seconds())
+	return err
+}
+
+func (p *FinnhubProvider) do(ctx context.Context, path string, params url.Values, v any) error {
+	params.Set("token", p.apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitError{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Detail:     "status " + resp.Status,
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// statusError is a non-2xx answer from Finnhub other than 429
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return "status " + e.status }
+
+// parseRetryAfter reads a Retry-After header, either delay seconds or an
+// HTTP date, returning 0 if it is missing or malformed.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(n)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"7", 7 * time.Second},
+		{"-3", 0},
+		{"Mon, 03 Jun 2024 14:00:30 GMT", 30 * time.Second},
+		{"Mon, 03 Jun 2024 13:59:00 GMT", 0}, // already past
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// finnhubStub answers with statuses in turn, then 200 with a quote, and
+// counts the calls
+func finnhubStub(t *testing.T, retryAfter string, statuses ...int) (*FinnhubProvider, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n <= len(statuses) {
+			if statuses[n-1] == http.StatusTooManyRequests && retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte(`{"c":190.1,"h":191,"l":187.5,"o":188.2,"pc":188}`))
+	}))
+	t.Cleanup(ts.Close)
+	fh := NewFinnhubProvider("test")
+	fh.baseURL = ts.URL
+	fh.maxBackoff = 50 * time.Millisecond
+	return fh, &calls
+}
+
+func TestFinnhub429Retry(t *testing.T) {
+	tests := []struct {
+		name           string
+		retries        int
+		retryAfter     string
+		statuses       []int
+		wantCode       int
+		wantCalls      int32
+		wantRetryAfter string
+	}{
+		{"429 once then ok", 1, "0", []int{429}, http.StatusOK, 2, ""},
+		{"Retry-After capped by the max backoff", 1, "120", []int{429}, http.StatusOK, 2, ""},
+		{"429 without a hint", 1, "", []int{429}, http.StatusOK, 2, ""},
+		{"still 429 after the retry", 1, "3", []int{429, 429}, http.StatusTooManyRequests, 2, "3"},
+		{"retries off", 0, "3", []int{429}, http.StatusTooManyRequests, 1, "3"},
+		{"two retries", 2, "0", []int{429, 429}, http.StatusOK, 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fh, calls := finnhubStub(t, tt.retryAfter, tt.statuses...)
+			fh.retries = tt.retries
+			s := &server{provider: fh}
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			s.handleQuote(rec, httptest.NewRequest(http.MethodGet, "/api/quote?symbol=AAPL", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("%d calls to Finnhub, want %d", n, tt.wantCalls)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After %q, want %q", got, tt.wantRetryAfter)
+			}
+			// Every wait is bounded by maxBackoff
+			if d := time.Since(start); d > time.Duration(tt.retries)*fh.maxBackoff+time.Second {
+				t.Errorf("took %s", d)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"500", &statusError{code: 500}, true},
+		{"502", &statusError{code: 502}, true},
+		{"503 wrapped", fmt.Errorf("quote: %w", &statusError{code: 503}), true},
+		{"400", &statusError{code: 400}, false},
+		{"401", &statusError{code: 401}, false},
+		{"403", &statusError{code: 403}, false},
+		{"404", &statusError{code: 404}, false},
+		{"Finnhub 429", &RateLimitError{}, true},
+		{"local limit", &RateLimitError{Local: true}, false},
+		{"connection reset", syscall.ECONNRESET, true},
+		{"truncated body", io.ErrUnexpectedEOF, true},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("refused")}, true},
+		{"bad JSON", &json.SyntaxError{}, false},
+		{"cancelled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &FinnhubProvider{maxBackoff: time.Second}
+	tests := []struct {
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{0, &statusError{code: 500}, 1, finnhubRetryBase},
+		{1, &statusError{code: 500}, 1, 2 * finnhubRetryBase},
+		{2, &statusError{code: 500}, 1, 4 * finnhubRetryBase},
+		{10, &statusError{code: 500}, 1, time.Second}, // capped
+		{0, &RateLimitError{RetryAfter: 300 * time.Millisecond}, 300 * time.Millisecond, 300 * time.Millisecond},
+		{0, &RateLimitError{RetryAfter: time.Minute}, time.Second, time.Second},
+		{0, &RateLimitError{}, time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		for range 50 { // jitter
+			if d := p.backoff(tt.attempt, tt.err); d < tt.min || d > tt.max {
+				t.Errorf("backoff(%d, %v) = %s, want %s..%s", tt.attempt, tt.err, d, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestFinnhubRetriesTransient(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantErr   bool
+		wantCalls int32
+	}{
+		{"500 then ok", []int{500}, false, 2},
+		{"502, 503 then ok", []int{502, 503}, false, 3},
+		{"5xx past the retries", []int{500, 500, 500}, true, 3},
+		{"400 is final", []int{400}, true, 1},
+		{"401 is final", []int{401}, true, 1},
+		{"404 is final", []int{404}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fh, calls := finnhubStub(t, "", tt.statuses...)
+			fh.retries = 2
+			_, err := fh.Quote(context.Background(), "AAPL")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("%d calls, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestFinnhubRetryStopsWithContext(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+	}{
+		{"cancelled while waiting", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			return ctx, cancel
+		}},
+		{"deadline before the next try", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 30*time.Millisecond)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Retry-After fixes the wait at a second, well past ctx's end
+			fh, calls := finnhubStub(t, "1", 429, 429, 429)
+			fh.retries, fh.maxBackoff = 2, time.Second
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			start := time.Now()
+			if _, err := fh.Quote(ctx, "AAPL"); !errors.Is(err, ErrRateLimited) {
+				t.Fatalf("Quote = %v, want the first attempt's error", err)
+			}
+			if d := time.Since(start); d > 500*time.Millisecond {
+				t.Errorf("gave up after %s, want as soon as ctx ended", d)
+			}
+			if n := calls.Load(); n != 1 {
+				t.Errorf("%d calls, want 1", n)
+			}
+		})
+	}
+}
+
+func TestFinnhubRetriesNetworkErrors(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// Drop the connection without answering
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(`{"c":190.1,"pc":188}`))
+	}))
+	defer ts.Close()
+	fh := NewFinnhubProvider("test")
+	fh.baseURL = ts.URL
+	fh.maxBackoff = 50 * time.Millisecond
+	q, err := fh.Quote(context.Background(), "AAPL")
+	if err != nil || q.Current != 190.1 {
+		t.Fatalf("Quote = %v, %v", q, err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d calls, want 2", n)
+	}
+}
+
+// candleUpstream is a fake /stock/candle serving a bar every step through
+// each requested window, both ends included, so consecutive chunks share
+// their boundary bar. Windows are recorded in the order asked; a window
+// starting at noData answers no_data and one starting at fail a 500.
+type candleUpstream struct {
+	step         int64
+	noData, fail int64
+	windows      [][2]int64
+}
+
+func (u *candleUpstream) provider(t *testing.T) *FinnhubProvider {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var from, to int64
+		fmt.Sscan(r.URL.Query().Get("from"), &from)
+		fmt.Sscan(r.URL.Query().Get("to"), &to)
+		u.windows = append(u.windows, [2]int64{from, to})
+		switch from {
+		case u.fail:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case u.noData:
+			w.Write([]byte(`{"s":"no_data"}`))
+			return
+		}
+		c := Candles{S: "ok"}
+		for ts := from; ts <= to; ts += u.step {
+			c.Time = append(c.Time, ts)
+			c.Open = append(c.Open, 1)
+			c.High = append(c.High, 2)
+			c.Low = append(c.Low, 0.5)
+			c.Close = append(c.Close, float64(ts))
+			c.Volume = append(c.Volume, 10)
+		}
+		json.NewEncoder(w).Encode(c)
+	}))
+	t.Cleanup(ts.Close)
+	fh := NewFinnhubProvider("test")
+	fh.baseURL = ts.URL
+	fh.retries = 0
+	return fh
+}
+
+func TestFinnhubCandlesChunks(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		resolution string
+		span       time.Duration
+		wantCalls  int
+	}{
+		{"1", time.Hour, 1},
+		{"1", 7 * day, 1},
+		{"1", 7*day + time.Minute, 2},
+		{"1", 20 * day, 3},
+		{"5", 65 * day, 3},
+		{"60", 30 * day, 1},
+		{"60", 90 * day, 3},
+		{"D", 365 * day, 1}, // daily bars are never chunked
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tt.resolution, tt.span), func(t *testing.T) {
+			step := int64(60)
+			if n, ok := map[string]int64{"5": 300, "60": 3600, "D": 86400}[tt.resolution]; ok {
+				step = n
+			}
+			u := &candleUpstream{step: step, noData: -1, fail: -1}
+			to := from.Add(tt.span)
+			c, err := u.provider(t).Candles(context.Background(), "AAPL", from, to, tt.resolution)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(u.windows) != tt.wantCalls {
+				t.Fatalf("%d upstream calls, want %d: %v", len(u.windows), tt.wantCalls, u.windows)
+			}
+			// Sequential, contiguous and within the chunk size
+			want := from.Unix()
+			for _, w := range u.windows {
+				if w[0] != want {
+					t.Errorf("window %v starts at %d, want %d", w, w[0], want)
+				}
+				if chunk := candleChunks[tt.resolution]; chunk > 0 && time.Duration(w[1]-w[0])*time.Second > chunk {
+					t.Errorf("window %v spans more than %s", w, chunk)
+				}
+				want = w[1]
+			}
+			if want != to.Unix() {
+				t.Errorf("windows end at %d, want %d", want, to.Unix())
+			}
+
+			// Every bar once, in order, OHLCV kept together
+			wantBars := int((to.Unix()-from.Unix())/step) + 1
+			if c.S != "ok" || c.Len() != wantBars {
+				t.Fatalf("status %q, %d bars, want %d", c.S, c.Len(), wantBars)
+			}
+			for i, ts := range c.Time {
+				if ts != from.Unix()+int64(i)*step {
+					t.Fatalf("bar %d at %d, want %d", i, ts, from.Unix()+int64(i)*step)
+				}
+				if c.Close[i] != float64(ts) || len(c.Open) != c.Len() || len(c.Volume) != c.Len() {
+					t.Fatalf("bar %d doesn't line up", i)
+				}
+			}
+		})
+	}
+}
+
+func TestFinnhubCandlesChunkOutcomes(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := from.Add(7 * day).Unix()
+	tests := []struct {
+		name     string
+		span     time.Duration
+		noData   int64
+		fail     int64
+		wantErr  error // nil: success
+		wantBars int
+	}{
+		{"empty chunk skipped", 21 * day, second, -1, nil, 2 * (7*24*60 + 1)},
+		{"no data", 7 * day, from.Unix(), -1, nil, 0},
+		{"failed chunk fails all", 21 * day, -1, second, nil, -1},
+		{"over the bar cap", 80 * day, -1, -1, ErrTooManyBars, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &candleUpstream{step: 60, noData: tt.noData, fail: tt.fail}
+			c, err := u.provider(t).Candles(context.Background(), "AAPL", from, from.Add(tt.span), "1")
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantBars < 0:
+				if err == nil {
+					t.Fatalf("got %d bars, want an error", c.Len())
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c.Len() != tt.wantBars {
+					t.Errorf("%d bars, want %d", c.Len(), tt.wantBars)
+				}
+			}
+		})
+	}
+}
+
+func TestFinnhubCandlesChunksPaced(t *testing.T) {
+	u := &candleUpstream{step: 60, noData: -1, fail: -1}
+	fh := u.provider(t)
+	fh.limiter = newTokenBucket(1200, 1) // one call per 50ms
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	start := time.Now()
+	if _, err := fh.Candles(context.Background(), "AAPL", from, from.Add(21*day), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.windows) != 3 {
+		t.Fatalf("%d calls, want 3", len(u.windows))
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("3 chunks took %s, want the limiter's pacing between them", d)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// flightProvider collapses concurrent identical requests into one upstream
+// call whose result is shared by every caller.
+type flightProvider struct {
+	Provider
+	group singleflight.Group
+}
+
+func newFlightProvider(p Provider) *flightProvider {
+	return &flightProvider{Provider: p}
+}
+
+func (p *flightProvider) Quote(ctx context.Context, symbol string) (*Quote, error) {
+	v, err := p.do(ctx, "quote:"+symbol, func(ctx context.Context) (any, error) {
+		return p.Provider.Quote(ctx, symbol)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Quote), nil
+}
+
+func (p *flightProvider) Candles(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+	key := fmt.Sprintf("candles:%s:%s:%d:%d", symbol, resolution, from.Unix(), to.Unix())
+	v, err := p.do(ctx, key, func(ctx context.Context) (any, error) {
+		return p.Provider.Candles(ctx, symbol, from, to, resolution)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Candles), nil
+}
+
+func (p *flightProvider) Search(ctx context.Context, query string) ([]SymbolMatch, error) {
+	v, err := p.do(ctx, "search:"+query, func(ctx context.Context) (any, error) {
+		return p.Provider.Search(ctx, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]SymbolMatch), nil
+}
+
+func (p *flightProvider) Profile(ctx context.Context, symbol string) (*Profile, error) {
+	v, err := p.do(ctx, "profile:"+symbol, func(ctx context.Context) (any, error) {
+		return p.Provider.Profile(ctx, symbol)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Profile), nil
+}
+
+func (p *flightProvider) News(ctx context.Context, symbol string, from, to time.Time) ([]NewsItem, error) {
+	key := fmt.Sprintf("news:%s:%s:%s", symbol, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	v, err := p.do(ctx, key, func(ctx context.Context) (any, error) {
+		return p.Provider.News(ctx, symbol, from, to)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]NewsItem), nil
+}
+
+func (p *flightProvider) MarketStatus(ctx context.Context, exchange string) (*ExchangeStatus, error) {
+	v, err := p.do(ctx, "market-status:"+exchange, func(ctx context.Context) (any, error) {
+		return p.Provider.MarketStatus(ctx, exchange)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ExchangeStatus), nil
+}
+
+func (p *flightProvider) Metrics(ctx context.Context, symbol string) (*Metrics, error) {
+	v, err := p.do(ctx, "metrics:"+symbol, func(ctx context.Context) (any, error) {
+		return p.Provider.Metrics(ctx, symbol)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Metrics), nil
+}
+
+// do runs fn once per key across concurrent callers. Cancelling a caller's
+// context doesn't cancel the shared call, so a caller that gives up doesn't
+// fail the others; it just stops waiting. The call still ends by the first
+// caller's deadline, if it has one, so it can't outlive every timeout.
+func (p *flightProvider) do(ctx context.Context, key string, fn func(context.Context) (any, error)) (any, error) {
+	ch := p.group.DoChan(key, func() (any, error) {
+		callCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithDeadline(callCtx, deadline)
+			defer cancel()
+		}
+		return fn(callCtx)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.Val, r.Err
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubProvider is a Provider for tests. Each method calls the matching
+// func; leaving one nil makes the method panic through the nil embed.
+type stubProvider struct {
+	Provider
+
+	quote   func(ctx context.Context, symbol string) (*Quote, error)
+	candles func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error)
+}
+
+func (p *stubProvider) Quote(ctx context.Context, symbol string) (*Quote, error) {
+	if p.quote == nil {
+		return p.Provider.Quote(ctx, symbol)
+	}
+	return p.quote(ctx, symbol)
+}
+
+func (p *stubProvider) Candles(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+	if p.candles == nil {
+		return p.Provider.Candles(ctx, symbol, from, to, resolution)
+	}
+	return p.candles(ctx, symbol, from, to, resolution)
+}
+
+// joinAll starts n callers of call and returns once they are all (very
+// probably) waiting on the shared call, whose first invocation has begun.
+func joinAll(t *testing.T, n int, started *atomic.Int32, call func(i int)) *sync.WaitGroup {
+	t.Helper()
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			call(i)
+		}()
+	}
+	waitFor(t, "the shared call to start", func() bool { return started.Load() > 0 })
+	time.Sleep(20 * time.Millisecond) // let the rest join it
+	return &wg
+}
+
+func TestFlightSharesOneCall(t *testing.T) {
+	const callers = 50
+	var calls atomic.Int32
+	release := make(chan struct{})
+	want := &Quote{Current: 101}
+	p := newFlightProvider(&stubProvider{quote: func(ctx context.Context, symbol string) (*Quote, error) {
+		calls.Add(1)
+		<-release
+		return want, nil
+	}})
+
+	got := make([]*Quote, callers)
+	wg := joinAll(t, callers, &calls, func(i int) {
+		q, err := p.Quote(context.Background(), "AAPL")
+		if err != nil {
+			t.Error(err)
+		}
+		got[i] = q
+	})
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+	for i, q := range got {
+		if q != want {
+			t.Errorf("caller %d got %v, want the shared quote", i, q)
+		}
+	}
+
+	// Once it has finished, the next call goes upstream again
+	if _, err := p.Quote(context.Background(), "AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream called %d times after a later call, want 2", n)
+	}
+}
+
+func TestFlightCallerGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	p := newFlightProvider(&stubProvider{quote: func(ctx context.Context, symbol string) (*Quote, error) {
+		calls.Add(1)
+		<-release
+		// A caller giving up doesn't cancel the shared call
+		return &Quote{Current: 1}, ctx.Err()
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gaveUp := make(chan error, 1)
+	errs := make([]error, 2)
+	wg := joinAll(t, 2, &calls, func(i int) {
+		if i == 0 {
+			_, err := p.Quote(ctx, "AAPL")
+			gaveUp <- err
+			return
+		}
+		_, errs[i] = p.Quote(context.Background(), "AAPL")
+	})
+	cancel()
+	if err := <-gaveUp; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller got %v, want context.Canceled", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if errs[1] != nil {
+		t.Errorf("remaining caller got %v", errs[1])
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+}
+
+func TestFlightKeepsDeadline(t *testing.T) {
+	deadlines := make(chan time.Time, 1)
+	p := newFlightProvider(&stubProvider{quote: func(ctx context.Context, symbol string) (*Quote, error) {
+		d, _ := ctx.Deadline() // zero without one
+		deadlines <- d
+		return &Quote{Current: 1}, nil
+	}})
+
+	want := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), want)
+	defer cancel()
+	if _, err := p.Quote(ctx, "AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-deadlines; !got.Equal(want) {
+		t.Errorf("shared call's deadline = %v, want the caller's %v", got, want)
+	}
+
+	if _, err := p.Quote(context.Background(), "AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-deadlines; !got.IsZero() {
+		t.Errorf("shared call's deadline = %v, want none", got)
+	}
+}
+
+func TestFlightKeysCandlesByRange(t *testing.T) {
+	var calls atomic.Int32
+	p := newFlightProvider(&stubProvider{candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+		calls.Add(1)
+		return &Candles{S: "ok"}, nil
+	}})
+	to := time.Unix(1717000000, 0)
+	for _, from := range []time.Time{to.Add(-time.Hour), to.Add(-2 * time.Hour)} {
+		if _, err := p.Candles(context.Background(), "AAPL", from, to, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream called %d times for two ranges, want 2", n)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Responses shorter than this go out uncompressed; gzip's framing would
+// eat most of the saving
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	},
+}
+
+// withGzip compresses responses for clients that accept gzip. A response
+// is held back until it reaches gzipMinSize, so small ones are sent as is.
+// WebSocket upgrades, range requests and event streams are never touched.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether r's Accept-Encoding admits gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response to decide whether to
+// compress it, then either streams it through a gzip.Writer or passes it
+// through untouched.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	decided bool
+	buf     []byte       // held until decided
+	gz      *gzip.Writer // nil unless compressing
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if !g.decided {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.decided {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+	if !g.compressible() {
+		g.passThrough()
+		return g.ResponseWriter.Write(p)
+	}
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.compress(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// compressible reports whether the response, as far as its headers go,
+// should be gzipped.
+func (g *gzipResponseWriter) compressible() bool {
+	h := g.Header()
+	ct := h.Get("Content-Type")
+	return h.Get("Content-Encoding") == "" &&
+		!strings.HasPrefix(ct, "text/event-stream") &&
+		g.status != http.StatusNoContent && g.status != http.StatusNotModified &&
+		g.status != http.StatusPartialContent
+}
+
+func (g *gzipResponseWriter) passThrough() {
+	g.decided = true
+	g.ResponseWriter.WriteHeader(g.status)
+	if len(g.buf) > 0 {
+		g.ResponseWriter.Write(g.buf)
+		g.buf = nil
+	}
+}
+
+func (g *gzipResponseWriter) compress() error {
+	g.decided = true
+	h := g.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.status)
+	g.gz = gzipWriters.Get().(*gzip.Writer)
+	g.gz.Reset(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// Flush sends what has been written so far. A response flushed before it
+// reached gzipMinSize is sent uncompressed from then on.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.passThrough()
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// finish sends a response that never reached gzipMinSize, or closes the
+// gzip stream.
+func (g *gzipResponseWriter) finish() {
+	if !g.decided {
+		g.passThrough()
+		return
+	}
+	if g.gz != nil {
+		g.gz.Close()
+		gzipWriters.Put(g.gz)
+		g.gz = nil
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.8, br", true},
+		{"gzip; q=0", false},
+		{"gzip;q=0", false},
+		{"deflate, br", false},
+		{"x-gzip", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		if got := acceptsGzip(r); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWithGzip(t *testing.T) {
+	large := `{"c":[` + strings.Repeat("190.25,", 2000) + `190.25]}`
+	small := `{"c":[190.25]}`
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		status      int
+		reqHeaders  map[string]string
+		wantGzip    bool
+	}{
+		{"large json", large, "application/json", http.StatusOK, map[string]string{"Accept-Encoding": "gzip"}, true},
+		{"large error", large, "application/json", http.StatusBadGateway, map[string]string{"Accept-Encoding": "gzip"}, true},
+		{"small json", small, "application/json", http.StatusOK, map[string]string{"Accept-Encoding": "gzip"}, false},
+		{"not accepted", large, "application/json", http.StatusOK, nil, false},
+		{"refused", large, "application/json", http.StatusOK, map[string]string{"Accept-Encoding": "gzip;q=0"}, false},
+		{"upgrade", large, "application/json", http.StatusOK, map[string]string{"Accept-Encoding": "gzip", "Upgrade": "websocket"}, false},
+		{"range", large, "application/json", http.StatusOK, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-10"}, false},
+		{"event stream", large, "text/event-stream", http.StatusOK, map[string]string{"Accept-Encoding": "gzip"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				// In pieces, so the size threshold is crossed mid-response
+				for b := tt.body; b != ""; {
+					n := min(len(b), 300)
+					io.WriteString(w, b[:n])
+					b = b[n:]
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/candles", nil)
+			for k, v := range tt.reqHeaders {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+			if v := rec.Header().Get("Vary"); v != "Accept-Encoding" {
+				t.Errorf("Vary %q", v)
+			}
+			gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("gzipped = %t, want %t", gzipped, tt.wantGzip)
+			}
+			got := rec.Body.String()
+			if gzipped {
+				if rec.Body.Len() >= len(tt.body) {
+					t.Errorf("compressed to %d bytes from %d", rec.Body.Len(), len(tt.body))
+				}
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = string(b)
+			}
+			if got != tt.body {
+				t.Errorf("body of %d bytes, want the %d written", len(got), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestWithGzipFlushSendsPlain(t *testing.T) {
+	h := withGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, strings.Repeat("x", 2*gzipMinSize))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "" || !rec.Flushed {
+		t.Errorf("flushed response: encoding %q, flushed %t", rec.Header().Get("Content-Encoding"), rec.Flushed)
+	}
+	if !strings.HasPrefix(rec.Body.String(), "data: hello") {
+		t.Errorf("body %q", rec.Body.String()[:20])
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Ticks buffered between the hub and the database writer; beyond this
+	// they are dropped rather than stalling the hub
+	historyBuffer = 1024
+
+	// Most ticks one /api/history response returns
+	maxHistoryTicks = 10000
+
+	// Default /api/history window when since is absent
+	defaultHistoryWindow = 24 * time.Hour
+)
+
+// sqliteDriver is the database/sql driver name registered by
+// modernc.org/sqlite (pure Go, no cgo); see sqlite.go.
+const sqliteDriver = "sqlite"
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS quotes (
+	symbol TEXT    NOT NULL,
+	price  REAL    NOT NULL,
+	ts     INTEGER NOT NULL -- UNIX milliseconds
+);
+CREATE INDEX IF NOT EXISTS quotes_symbol_ts ON quotes (symbol, ts);`
+
+// tick is one stored price
+type tick struct {
+	Price float64 `json:"price"`
+	Time  int64   `json:"time"` // UNIX milliseconds, like quote messages
+}
+
+// quoteStore records every price the hub emits. Writes go through a
+// buffered channel to a single writer so the hub never waits on disk.
+type quoteStore struct {
+	db   *sql.DB
+	in   chan quoteUpdate
+	done chan struct{} // closed when run has flushed and returned
+}
+
+// openQuoteStore opens (creating if needed) the database at dsn, a file
+// path or ":memory:".
+func openQuoteStore(dsn string) (*quoteStore, error) {
+	db, err := sql.Open(sqliteDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer, and each ":memory:" connection would be
+	// a separate database
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &quoteStore{
+		db:   db,
+		in:   make(chan quoteUpdate, historyBuffer),
+		done: make(chan struct{}),
+	}, nil
+}
+
+// record queues u for writing. Failed polls are skipped. Safe to call
+// with the hub lock held: it never blocks.
+func (st *quoteStore) record(u quoteUpdate) {
+	if u.Err != nil || u.Quote == nil || u.Quote.Current == 0 {
+		return
+	}
+	select {
+	case st.in <- u:
+	default:
+		slog.Warn("history buffer full, tick dropped", "symbol", u.Symbol)
+	}
+}
+
+// run writes queued ticks until ctx is done, batching whatever has piled
+// up into one transaction. It flushes the rest and closes the database on
+// the way out.
+func (st *quoteStore) run(ctx context.Context) {
+	defer close(st.done)
+	defer st.db.Close()
+	for {
+		var batch []quoteUpdate
+		select {
+		case <-ctx.Done():
+		case u := <-st.in:
+			batch = append(batch, u)
+		}
+	drain:
+		for len(batch) < historyBuffer {
+			select {
+			case u := <-st.in:
+				batch = append(batch, u)
+			default:
+				break drain
+			}
+		}
+		if len(batch) > 0 {
+			if err := st.insert(batch); err != nil {
+				slog.Error("history write failed", "ticks", len(batch), "err", err)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (st *quoteStore) insert(batch []quoteUpdate) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO quotes (symbol, price, ts) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range batch {
+		if _, err := stmt.Exec(u.Symbol, u.Quote.Current, u.Time.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// history returns up to limit of symbol's ticks at or after since, oldest
+// first.
+func (st *quoteStore) history(ctx context.Context, symbol string, since time.Time, limit int) ([]tick, error) {
+	rows, err := st.db.QueryContext(ctx,
+		`SELECT price, ts FROM quotes WHERE symbol = ? AND ts >= ? ORDER BY ts LIMIT ?`,
+		symbol, since.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ticks := []tick{}
+	for rows.Next() {
+		var t tick
+		if err := rows.Scan(&t.Price, &t.Time); err != nil {
+			return nil, err
+		}
+		ticks = append(ticks, t)
+	}
+	return ticks, rows.Err()
+}
+
+// ---------------- HTTP Handler ----------------
+
+// GET /api/history?symbol=AAPL&since=1717000000
+// Returns the stored ticks for symbol since a UNIX time in seconds (the
+// last 24h by default), oldest first, up to 10000:
+//
+//	{"symbol":"AAPL","ticks":[{"price":190.1,"time":1717000000000},...]}
+//
+// Only symbols someone was watching are recorded. Answers 404 when the
+// server runs without -db.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "history_disabled"})
+		return
+	}
+	symbol, err := querySymbol(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	since := time.Now().Add(-defaultHistoryWindow)
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			badRequest(w, "since must be UNIX seconds")
+			return
+		}
+		since = time.Unix(n, 0)
+	}
+
+	ticks, err := s.history.history(r.Context(), symbol, since, maxHistoryTicks)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"symbol": symbol, "ticks": ticks})
+}
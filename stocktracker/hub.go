@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// quoteUpdate is one quote for a symbol, from a poll or a streamed trade,
+// fanned out to subscribers.
+type quoteUpdate struct {
+	Symbol string
+	Quote  *Quote
+	Time   time.Time
+	Err    error
+	Seq    uint64 // per-symbol quote number; 0 when the poll failed
+
+	// The live 1-minute bar after this tick, and the previous bar if this
+	// tick closed it. Both are nil when the poll failed.
+	Bar    *Bar
+	Closed *Bar
+}
+
+// Hub runs one upstream poller per distinct symbol and fans each result
+// out to every connection subscribed to it. A connection is identified by
+// its update queue. A poller starts with the first subscriber and stops
+// when the last one leaves; it polls as often as its most demanding
+// subscriber asks for, and each connection throttles to its own interval.
+//
+// With a trade stream attached, trades are fanned out as they arrive and
+// a poller skips its REST call whenever trades came in since its last
+// tick, so polling only fills in when the stream is down, doesn't carry
+// the symbol, or is quiet.
+type Hub struct {
+	fetch  func(ctx context.Context, symbol string) (*Quote, error)
+	stream tradeStream       // optional
+	record func(quoteUpdate) // optional; sees every update, must not block
+	limit  int               // most distinct symbols polled at once; 0 is unlimited
+
+	mu       sync.Mutex
+	pollers  map[string]*symbolPoller
+	journals map[string]*journal
+}
+
+// tradeStream is an upstream push feed the hub keeps subscribed to the
+// symbols it is polling.
+type tradeStream interface {
+	Subscribe(symbol string)
+	Unsubscribe(symbol string)
+}
+
+// Fields other than wake, ctx and cancel are guarded by Hub.mu.
+type symbolPoller struct {
+	subs map[*updateQueue]time.Duration // subscriber -> requested interval
+	last *quoteUpdate                   // most recent result, replayed to late subscribers
+
+	quote     *Quote    // latest good quote; streamed trades are applied to it
+	lastTrade time.Time // when the stream last delivered a trade
+
+	// Signalled when the subscriber intervals change
+	wake chan struct{}
+
+	bars *barBuilder
+
+	// Cancelled when the last subscriber leaves; aborts in-flight fetches
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newHub(fetch func(context.Context, string) (*Quote, error)) *Hub {
+	return &Hub{
+		fetch:    fetch,
+		pollers:  make(map[string]*symbolPoller),
+		journals: make(map[string]*journal),
+	}
+}
+
+// ErrSymbolLimit refuses a symbol that would need a new poller once the
+// hub is polling its limit of distinct symbols.
+var ErrSymbolLimit = errors.New("symbol limit reached")
+
+// Subscribe adds sub to symbol's subscribers, asking for a poll at least
+// every interval. Symbols already being polled are always accepted.
+func (h *Hub) Subscribe(symbol string, sub *updateQueue, interval time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.subscribeLocked(symbol, sub, interval)
+}
+
+// subscribeLocked is Subscribe with h.mu held
+func (h *Hub) subscribeLocked(symbol string, sub *updateQueue, interval time.Duration) error {
+	p, ok := h.pollers[symbol]
+	if !ok {
+		if h.limit > 0 && len(h.pollers) >= h.limit {
+			return ErrSymbolLimit
+		}
+		h.pruneJournalsLocked(time.Now())
+		ctx, cancel := context.WithCancel(context.Background())
+		p = &symbolPoller{
+			subs:   make(map[*updateQueue]time.Duration),
+			wake:   make(chan struct{}, 1),
+			bars:   newBarBuilder(time.Minute),
+			ctx:    ctx,
+			cancel: cancel,
+		}
+		h.pollers[symbol] = p
+		go h.run(symbol, p)
+		if h.stream != nil {
+			h.stream.Subscribe(symbol)
+		}
+	}
+	p.subs[sub] = interval
+	p.notify()
+
+	if p.last != nil {
+		sub.push(*p.last)
+	}
+	return nil
+}
+
+// SetInterval changes the interval sub asked for on all of its symbols.
+func (h *Hub) SetInterval(sub *updateQueue, interval time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range h.pollers {
+		if _, ok := p.subs[sub]; ok {
+			p.subs[sub] = interval
+			p.notify()
+		}
+	}
+}
+
+// Unsubscribe removes sub from symbol, stopping the poller if it was the last.
+func (h *Hub) Unsubscribe(symbol string, sub *updateQueue) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(symbol, sub)
+}
+
+// Unregister removes sub from every symbol it is subscribed to.
+func (h *Hub) Unregister(sub *updateQueue) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for symbol := range h.pollers {
+		h.remove(symbol, sub)
+	}
+}
+
+// Subscribers maps every polled symbol to its number of subscribers
+func (h *Hub) Subscribers() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[string]int, len(h.pollers))
+	for symbol, p := range h.pollers {
+		out[symbol] = len(p.subs)
+	}
+	return out
+}
+
+// remove must be called with h.mu held
+func (h *Hub) remove(symbol string, sub *updateQueue) {
+	p, ok := h.pollers[symbol]
+	if !ok {
+		return
+	}
+	delete(p.subs, sub)
+	if len(p.subs) == 0 {
+		p.cancel()
+		delete(h.pollers, symbol)
+		if h.stream != nil {
+			h.stream.Unsubscribe(symbol)
+		}
+		return
+	}
+	p.notify()
+}
+
+// interval is the shortest interval any subscriber asked for.
+// Must be called with h.mu held.
+func (p *symbolPoller) interval() time.Duration {
+	var min time.Duration
+	for _, d := range p.subs {
+		if min == 0 || d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+// wait blocks until the next poll is due, counting from started.
+// It returns false if the poller was stopped meanwhile.
+func (p *symbolPoller) wait(h *Hub, started time.Time) bool {
+	for {
+		h.mu.Lock()
+		timer := time.NewTimer(time.Until(started.Add(p.interval())))
+		h.mu.Unlock()
+
+		select {
+		case <-p.ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+			// With no subscribers left the timer fires at once; don't
+			// let it win the race against the cancellation
+			return p.ctx.Err() == nil
+		case <-p.wake:
+			timer.Stop()
+		}
+	}
+}
+
+func (p *symbolPoller) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run polls symbol until its poller is stopped
+func (h *Hub) run(symbol string, p *symbolPoller) {
+	// First tick immediately
+	for {
+		started := time.Now()
+		if !h.streaming(p) {
+			h.poll(symbol, p)
+		}
+
+		// Wait out the interval, re-arming whenever subscribers change it
+		if !p.wait(h, started) {
+			return
+		}
+	}
+}
+
+// streaming reports whether trades arrived within the current interval
+func (h *Hub) streaming(p *symbolPoller) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !p.lastTrade.IsZero() && time.Since(p.lastTrade) < p.interval()
+}
+
+func (h *Hub) poll(symbol string, p *symbolPoller) {
+	q, err := h.fetch(p.ctx, symbol)
+	if p.ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		slog.Warn("poll quote failed", "symbol", symbol, "err", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p.ctx.Err() != nil {
+		return
+	}
+	if err == nil {
+		p.quote = q
+	}
+	h.publish(p, quoteUpdate{Symbol: symbol, Quote: q, Time: time.Now(), Err: err})
+}
+
+// Trade applies a streamed trade to symbol's latest quote and fans it out.
+func (h *Hub) Trade(symbol string, price float64, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.pollers[symbol]
+	if !ok || price <= 0 {
+		return
+	}
+
+	var q Quote
+	if p.quote != nil {
+		q = *p.quote
+	}
+	q.Current = price
+	q.High = max(q.High, price)
+	if q.Low == 0 || price < q.Low {
+		q.Low = price
+	}
+	p.quote = &q
+	p.lastTrade = at
+
+	h.publish(p, quoteUpdate{Symbol: symbol, Quote: &q, Time: at})
+}
+
+// publish numbers u and fans it out to p's subscribers. Must be called
+// with h.mu held.
+func (h *Hub) publish(p *symbolPoller, u quoteUpdate) {
+	if u.Err == nil && u.Quote.Current != 0 {
+		bar, closed := p.bars.add(u.Quote.Current, u.Time)
+		u.Bar, u.Closed = &bar, closed
+	}
+	if u.Err == nil {
+		j, ok := h.journals[u.Symbol]
+		if !ok {
+			j = &journal{}
+			h.journals[u.Symbol] = j
+		}
+		j.append(&u)
+	}
+	p.last = &u
+	if h.record != nil {
+		h.record(u)
+	}
+	for sub := range p.subs {
+		sub.push(u)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingFetch is a hub fetch that counts calls per symbol
+type countingFetch struct {
+	mu    sync.Mutex
+	calls map[string]int
+	err   error
+}
+
+func (f *countingFetch) fetch(ctx context.Context, symbol string) (*Quote, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[symbol]++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &Quote{Current: 100 + float64(f.calls[symbol]), PrevClose: 100}, nil
+}
+
+func (f *countingFetch) count(symbol string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[symbol]
+}
+
+// recvUpdate waits for sub's next pending update
+func recvUpdate(t *testing.T, sub *updateQueue) quoteUpdate {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		if u, ok := sub.pop(); ok {
+			return u
+		}
+		select {
+		case <-sub.ready:
+		case <-deadline:
+			t.Fatal("timed out waiting for an update")
+		}
+	}
+}
+
+func TestHubSharesOnePollPerSymbol(t *testing.T) {
+	f := &countingFetch{}
+	h := newHub(f.fetch)
+	const conns = 50
+	subs := make([]*updateQueue, conns)
+	for i := range subs {
+		subs[i] = newUpdateQueue()
+		// An hour apart: only the immediate first poll happens
+		if err := h.Subscribe("AAPL", subs[i], time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, sub := range subs {
+		if u := recvUpdate(t, sub); u.Symbol != "AAPL" || u.Quote.Current != 101 || u.Seq != 1 {
+			t.Errorf("connection %d got %+v", i, u)
+		}
+	}
+	if n := f.count("AAPL"); n != 1 {
+		t.Errorf("%d upstream calls for %d connections, want 1", n, conns)
+	}
+	if got := h.Subscribers(); got["AAPL"] != conns {
+		t.Errorf("Subscribers() = %v", got)
+	}
+
+	// Another symbol gets a poller of its own
+	other := newUpdateQueue()
+	h.Subscribe("MSFT", other, time.Hour)
+	recvUpdate(t, other)
+	if n := f.count("MSFT"); n != 1 {
+		t.Errorf("%d MSFT calls, want 1", n)
+	}
+	if n := f.count("AAPL"); n != 1 {
+		t.Errorf("subscribing MSFT polled AAPL again (%d calls)", n)
+	}
+}
+
+func TestHubReplaysLastToLateSubscriber(t *testing.T) {
+	f := &countingFetch{}
+	h := newHub(f.fetch)
+	first := newUpdateQueue()
+	h.Subscribe("AAPL", first, time.Hour)
+	recvUpdate(t, first)
+
+	late := newUpdateQueue()
+	h.Subscribe("AAPL", late, time.Hour)
+	if u := recvUpdate(t, late); u.Quote.Current != 101 {
+		t.Errorf("late subscriber got %+v, want the first poll replayed", u)
+	}
+	if n := f.count("AAPL"); n != 1 {
+		t.Errorf("%d upstream calls, want 1", n)
+	}
+}
+
+func TestHubPollsAtShortestInterval(t *testing.T) {
+	f := &countingFetch{}
+	h := newHub(f.fetch)
+	slow, fast := newUpdateQueue(), newUpdateQueue()
+	h.Subscribe("AAPL", slow, time.Hour)
+	h.Subscribe("AAPL", fast, 20*time.Millisecond)
+	waitFor(t, "polls at the fast interval", func() bool { return f.count("AAPL") >= 4 })
+
+	// With only the slow subscriber left, polling all but stops
+	h.Unsubscribe("AAPL", fast)
+	time.Sleep(30 * time.Millisecond) // let a poll in flight land
+	n := f.count("AAPL")
+	time.Sleep(100 * time.Millisecond)
+	if got := f.count("AAPL"); got > n+1 {
+		t.Errorf("%d polls after the fast subscriber left, want at most 1", got-n)
+	}
+
+	// Raising the remaining subscriber's rate wakes the poller early
+	h.SetInterval(slow, 20*time.Millisecond)
+	waitFor(t, "polls after SetInterval", func() bool { return f.count("AAPL") >= n+3 })
+}
+
+func TestHubStopsWithLastSubscriber(t *testing.T) {
+	f := &countingFetch{}
+	h := newHub(f.fetch)
+	a, b := newUpdateQueue(), newUpdateQueue()
+	h.Subscribe("AAPL", a, 10*time.Millisecond)
+	h.Subscribe("TSLA", a, 10*time.Millisecond)
+	h.Subscribe("AAPL", b, 10*time.Millisecond)
+
+	h.Unregister(a)
+	if got := h.Subscribers(); len(got) != 1 || got["AAPL"] != 1 {
+		t.Errorf("after unregistering a: %v, want only AAPL with 1", got)
+	}
+	h.Unsubscribe("AAPL", b)
+	if got := h.Subscribers(); len(got) != 0 {
+		t.Errorf("after the last unsubscribe: %v, want no pollers", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+	n := f.count("AAPL")
+	time.Sleep(50 * time.Millisecond)
+	if got := f.count("AAPL"); got != n {
+		t.Errorf("poller kept fetching after its last subscriber left (%d more)", got-n)
+	}
+}
+
+func TestHubSymbolLimit(t *testing.T) {
+	h := newHub((&countingFetch{}).fetch)
+	h.limit = 2
+	sub := newUpdateQueue()
+	for _, sym := range []string{"AAPL", "MSFT"} {
+		if err := h.Subscribe(sym, sub, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Subscribe("TSLA", sub, time.Hour); !errors.Is(err, ErrSymbolLimit) {
+		t.Errorf("third symbol: %v, want ErrSymbolLimit", err)
+	}
+	// A symbol already polled is always accepted
+	if err := h.Subscribe("AAPL", newUpdateQueue(), time.Hour); err != nil {
+		t.Errorf("joining AAPL: %v", err)
+	}
+}
+
+func TestHubFailedPoll(t *testing.T) {
+	f := &countingFetch{err: errors.New("upstream down")}
+	h := newHub(f.fetch)
+	sub := newUpdateQueue()
+	h.Subscribe("AAPL", sub, time.Hour)
+	waitFor(t, "the failed poll", func() bool {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		return len(sub.order) > 0
+	})
+	u, _ := sub.pop()
+	if u.Err == nil || u.Seq != 0 || u.Bar != nil {
+		t.Errorf("failed poll delivered %+v, want the error without a seq or bar", u)
+	}
+}
+
+func TestHubSlowSubscriberDoesNotStallFast(t *testing.T) {
+	f := &countingFetch{}
+	h := newHub(f.fetch)
+	fast, slow := newUpdateQueue(), newUpdateQueue()
+	for _, sub := range []*updateQueue{fast, slow} {
+		if err := h.Subscribe("AAPL", sub, 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		defer h.Unregister(sub)
+	}
+
+	// The fast subscriber sees every quote in turn while slow never reads
+	var last uint64
+	for last < 5 {
+		<-fast.ready
+		for u, ok := fast.pop(); ok; u, ok = fast.pop() {
+			if u.Seq <= last {
+				t.Fatalf("fast subscriber got seq %d after %d", u.Seq, last)
+			}
+			last = u.Seq
+		}
+	}
+
+	// and slow holds just the newest one
+	slow.mu.Lock()
+	n := len(slow.order)
+	slow.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("slow subscriber has %d updates pending, want 1", n)
+	}
+	if u, _ := slow.pop(); u.Seq < last {
+		t.Errorf("slow subscriber's pending quote is seq %d, older than %d", u.Seq, last)
+	}
+}
+
+func TestHubTradesSkipPolling(t *testing.T) {
+	f := &countingFetch{}
+	h := newHub(f.fetch)
+	sub := newUpdateQueue()
+	if err := h.Subscribe("AAPL", sub, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Unregister(sub)
+	waitFor(t, "the first poll", func() bool { return f.count("AAPL") == 1 })
+
+	// While trades keep arriving within the interval, the poller stands by
+	stop := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(stop) {
+		h.Trade("AAPL", 190, time.Now())
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := f.count("AAPL"); n > 2 {
+		t.Errorf("polled %d times while trades streamed, want at most 2", n)
+	}
+	// and takes over again once they stop
+	waitFor(t, "polling to resume", func() bool { return f.count("AAPL") > 2 })
+
+	// Trades reach subscribers as quotes
+	h.Trade("AAPL", 191.5, time.Now())
+	var last quoteUpdate
+	for u, ok := sub.pop(); ok; u, ok = sub.pop() {
+		last = u
+	}
+	if last.Quote == nil || last.Quote.Current != 191.5 {
+		t.Errorf("latest update %+v, want the 191.5 trade", last)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"stocker/indicators"
+)
+
+// Longest averaging period the indicator endpoints accept
+const maxIndicatorPeriod = 500
+
+// ---------------- Registry ----------------
+
+// indicatorSpec is an indicator with its parameters applied
+type indicatorSpec struct {
+	params map[string]any // echoed in the response
+	series []string       // keys of the map calc returns
+	first  int            // index of the first candle with a defined value
+	note   string         // a convention worth telling clients, if any
+	calc   func(c *Candles) map[string][]float64
+}
+
+// indicator validates an indicator's query parameters
+type indicator func(q url.Values) (indicatorSpec, error)
+
+var indicatorRegistry = map[string]indicator{
+	"sma":       closesOverPeriod("sma", indicators.SMA, -1),
+	"ema":       closesOverPeriod("ema", indicators.EMA, -1),
+	"rsi":       rsiIndicator,
+	"ma":        maIndicator,
+	"atr":       atrIndicator,
+	"stoch":     stochIndicator,
+	"vwap":      vwapIndicator,
+	"macd":      macdIndicator,
+	"bollinger": bollingerIndicator,
+	"bbands":    bollingerIndicator,
+}
+
+// closesOverPeriod adapts fn, a series over closes with a single
+// ?period=, whose first value lands at index period+offset.
+func closesOverPeriod(name string, fn func([]float64, int) []float64, offset int) indicator {
+	return func(q url.Values) (indicatorSpec, error) {
+		period, err := periodParam(q, "period", 0)
+		if err != nil {
+			return indicatorSpec{}, err
+		}
+		return indicatorSpec{
+			params: map[string]any{"period": period},
+			series: []string{name},
+			first:  period + offset,
+			calc: func(c *Candles) map[string][]float64 {
+				return map[string][]float64{name: fn(c.Close, period)}
+			},
+		}, nil
+	}
+}
+
+// rsiIndicator is closesOverPeriod over RSI, stating what a flat series
+// reads as.
+func rsiIndicator(q url.Values) (indicatorSpec, error) {
+	spec, err := closesOverPeriod("rsi", indicators.RSI, 0)(q)
+	spec.note = "RSI is 50 where the closes are flat over the period and 100 where they only rose"
+	return spec, err
+}
+
+// maIndicator is sma or ema picked by ?type= (default sma), for clients
+// that draw either from one overlay setting. Its series is named "ma".
+func maIndicator(q url.Values) (indicatorSpec, error) {
+	fn := indicators.SMA
+	switch kind := q.Get("type"); kind {
+	case "", "sma":
+	case "ema":
+		fn = indicators.EMA
+	default:
+		return indicatorSpec{}, errors.New("type must be sma or ema")
+	}
+	spec, err := closesOverPeriod("ma", fn, -1)(q)
+	if err != nil {
+		return spec, err
+	}
+	if period := spec.params["period"].(int); period < 2 {
+		return indicatorSpec{}, fmt.Errorf("period must be an integer from 2 to %d", maxIndicatorPeriod)
+	}
+	spec.params["type"] = cmp.Or(q.Get("type"), "sma")
+	return spec, nil
+}
+
+func atrIndicator(q url.Values) (indicatorSpec, error) {
+	period, err := periodParam(q, "period", 14)
+	if err != nil {
+		return indicatorSpec{}, err
+	}
+	return indicatorSpec{
+		params: map[string]any{"period": period},
+		series: []string{"atr"},
+		first:  period,
+		calc: func(c *Candles) map[string][]float64 {
+			return map[string][]float64{"atr": indicators.ATR(c.High, c.Low, c.Close, period)}
+		},
+	}, nil
+}
+
+func stochIndicator(q url.Values) (indicatorSpec, error) {
+	k, err := periodParam(q, "kPeriod", 14)
+	if err != nil {
+		return indicatorSpec{}, err
+	}
+	d, err := periodParam(q, "dPeriod", 3)
+	if err != nil {
+		return indicatorSpec{}, err
+	}
+	smooth, err := periodParam(q, "smooth", 3)
+	if err != nil {
+		return indicatorSpec{}, err
+	}
+	return indicatorSpec{
+		params: map[string]any{"kPeriod": k, "dPeriod": d, "smooth": smooth},
+		series: []string{"k", "d"},
+		first:  k + smooth + d - 3,
+		calc: func(c *Candles) map[string][]float64 {
+			pk, pd := indicators.Stochastic(c.High, c.Low, c.Close, k, smooth, d)
+			return map[string][]float64{"k": pk, "d": pd}
+		},
+	}, nil
+}
+
+// vwapIndicator is the running VWAP from the window's first candle; it
+// takes no parameters.
+func vwapIndicator(url.Values) (indicatorSpec, error) {
+	return indicatorSpec{
+		params: map[string]any{},
+		series: []string{"vwap"},
+		calc: func(c *Candles) map[string][]float64 {
+			return map[string][]float64{"vwap": indicators.VWAP(c.High, c.Low, c.Close, c.Volume)}
+		},
+	}, nil
+}
+
+func macdIndicator(q url.Values) (indicatorSpec, error) {
+	fast, err := periodParam(q, "fast", 12)
+	if err != nil {
+		return indicatorSpec{}, err
+	}
+	slow, err := periodParam(q, "slow", 26)
+	if err != nil {
+		return indicatorSpec{}, err
+	}
+	signal, err := periodParam(q, "signal", 9)
+	if err != nil {
+		return indicatorSpec{}, err
+	}
+	if fast < 2 || signal < 2 {
+		return indicatorSpec{}, errors.New("fast, slow and signal must be at least 2")
+	}
+	if fast >= slow {
+		return indicatorSpec{}, errors.New("fast must be less than slow")
+	}
+	return indicatorSpec{
+		params: map[string]any{"fast": fast, "slow": slow, "signal": signal},
+		series: []string{"macd", "signal", "histogram"},
+		first:  slow + signal - 2,
+		calc: func(c *Candles) map[string][]float64 {
+			line, sig, hist := indicators.MACD(c.Close, fast, slow, signal)
+			return map[string][]float64{"macd": line, "signal": sig, "histogram": hist}
+		},
+	}, nil
+}
+
+func bollingerIndicator(q url.Values) (indicatorSpec, error) {
+	period, err := periodParam(q, "period", 20)
+	if err != nil {
+		return indicatorSpec{}, err
+	}
+	k := 2.0
+	if v := q.Get("stddev"); v != "" {
+		k, err = strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(k) || math.IsInf(k, 0) || k <= 0 || k > 10 {
+			return indicatorSpec{}, errors.New("stddev must be a number above 0 and at most 10")
+		}
+	}
+	return indicatorSpec{
+		params: map[string]any{"period": period, "stddev": k},
+		series: []string{"middle", "upper", "lower", "close"},
+		first:  period - 1,
+		calc: func(c *Candles) map[string][]float64 {
+			middle, upper, lower := indicators.Bollinger(c.Close, period, k)
+			// The closes too, so a chart can draw price and bands together
+			return map[string][]float64{"middle": middle, "upper": upper, "lower": lower, "close": c.Close}
+		},
+	}, nil
+}
+
+// padWarmup aligns v with the last of n candles, leaving null for the
+// warm-up candles before it
+func padWarmup(v []float64, n int) []*float64 {
+	out := make([]*float64, n)
+	for i := range v {
+		out[n-len(v)+i] = &v[i]
+	}
+	return out
+}
+
+// periodParam reads a period from q, using def when it is absent (or
+// requiring it when def is 0).
+func periodParam(q url.Values, key string, def int) (int, error) {
+	v := q.Get(key)
+	if v == "" && def > 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxIndicatorPeriod {
+		return 0, fmt.Errorf("%s must be an integer from 1 to %d", key, maxIndicatorPeriod)
+	}
+	return n, nil
+}
+
+// ---------------- HTTP Handler ----------------
+
+// GET /api/indicators/{name}?symbol=AAPL&minutes=120&period=20
+// GET /api/indicators/macd?symbol=AAPL&fast=12&slow=26&signal=9
+// GET /api/indicators/bollinger?symbol=MSFT&period=20&stddev=2
+// GET /api/indicators/ma?symbol=TSLA&period=20&type=ema&resolution=5&minutes=600
+//
+// name is sma, ema, rsi (each needing ?period=), ma (sma or ema by ?type=,
+// as series "ma"), atr (period 14 by default), stoch (kPeriod 14, smooth 3
+// and dPeriod 3 by default; series k and d), vwap (running from the first
+// candle), macd or bollinger (alias bbands; its bands come with the
+// closes they were computed from). Accepts the same window parameters as
+// /api/candles. Each series is keyed by its name and aligned to the candle
+// times in t, with null for the leading candles where the indicator is
+// still undefined; bars is the number of candles. A window with too few
+// candles for even one value answers 422, saying how many are required.
+// note, when present, states a convention such as RSI's for flat closes.
+//
+//	{"symbol":"TSLA","indicator":"rsi","params":{"period":14},"status":"ok",
+//	 "t":[...],"rsi":[null,...,61.2],"note":"RSI is 50 where ..."}
+//	{"symbol":"AAPL","indicator":"macd","params":{"fast":12,"slow":26,"signal":9},
+//	 "status":"ok","t":[...],"macd":[...],"signal":[...],"histogram":[...]}
+func (s *server) handleIndicator(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ind, ok := indicatorRegistry[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown indicator"})
+		return
+	}
+	q, err := parseCandleQuery(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	spec, err := ind(r.URL.Query())
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	c, _, err := s.candles(r.Context(), q)
+	if err != nil {
+		badGateway(w, r, err)
+		return
+	}
+
+	resp := map[string]any{
+		"symbol":     q.symbol,
+		"indicator":  name,
+		"resolution": q.resolution,
+		"from":       q.from.Unix(),
+		"to":         q.to.Unix(),
+		"status":     c.S,
+		"params":     spec.params,
+		"t":          []int64{},
+	}
+	n := c.Len()
+	resp["bars"] = n
+	if spec.note != "" {
+		resp["note"] = spec.note
+	}
+	switch {
+	case c.S == "ok" && n > spec.first:
+		c = c.head(n)
+		resp["t"] = c.Time
+		for k, v := range spec.calc(c) {
+			resp[k] = padWarmup(v, n)
+		}
+	case c.S == "ok" && n > 0:
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":    fmt.Sprintf("%s needs at least %d candles, the window has %d", name, spec.first+1, n),
+			"status":   "insufficient_data",
+			"bars":     n,
+			"required": spec.first + 1,
+		})
+		return
+	default:
+		// No candles at all: keep the shape, every series present but empty
+		for _, k := range spec.series {
+			resp[k] = []float64{}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,217 @@
+// Package indicators computes technical indicators over price series:
+// moving averages, oscillators, MACD, Bollinger bands, ATR and VWAP. It
+// knows nothing of candles or HTTP; callers pass the series themselves.
+package indicators
+
+import (
+	"math"
+	"slices"
+)
+
+// ---------------- Moving Averages ----------------
+
+// SMA is the simple moving average of values over period. out[i] is the
+// average of values[i : i+period], so out is aligned to values[period-1:]
+// and is empty when there are fewer than period values.
+func SMA(values []float64, period int) []float64 {
+	if period <= 0 || len(values) < period {
+		return []float64{}
+	}
+	out := make([]float64, 0, len(values)-period+1)
+	var sum float64
+	for i, v := range values {
+		sum += v
+		if i >= period {
+			sum -= values[i-period]
+		}
+		if i >= period-1 {
+			out = append(out, sum/float64(period))
+		}
+	}
+	return out
+}
+
+// EMA is the exponential moving average of values over period, seeded
+// with the SMA of the first period values. It is aligned like SMA.
+func EMA(values []float64, period int) []float64 {
+	if period <= 0 || len(values) < period {
+		return []float64{}
+	}
+	k := 2 / float64(period+1)
+	out := make([]float64, 0, len(values)-period+1)
+	var seed float64
+	for _, v := range values[:period] {
+		seed += v
+	}
+	prev := seed / float64(period)
+	out = append(out, prev)
+	for _, v := range values[period:] {
+		prev = v*k + prev*(1-k)
+		out = append(out, prev)
+	}
+	return out
+}
+
+// ---------------- Oscillators ----------------
+
+// RSI is the Relative Strength Index of values using Wilder's smoothing.
+// The first value needs period changes, so out is aligned to
+// values[period:] and is empty when there are period or fewer values.
+func RSI(values []float64, period int) []float64 {
+	if period <= 0 || len(values) <= period {
+		return []float64{}
+	}
+	var avgGain, avgLoss float64
+	for i := 1; i <= period; i++ {
+		if d := values[i] - values[i-1]; d > 0 {
+			avgGain += d
+		} else {
+			avgLoss -= d
+		}
+	}
+	avgGain /= float64(period)
+	avgLoss /= float64(period)
+
+	out := make([]float64, 0, len(values)-period)
+	out = append(out, rsiValue(avgGain, avgLoss))
+	for i := period + 1; i < len(values); i++ {
+		gain, loss := 0.0, 0.0
+		if d := values[i] - values[i-1]; d > 0 {
+			gain = d
+		} else {
+			loss = -d
+		}
+		avgGain = (avgGain*float64(period-1) + gain) / float64(period)
+		avgLoss = (avgLoss*float64(period-1) + loss) / float64(period)
+		out = append(out, rsiValue(avgGain, avgLoss))
+	}
+	return out
+}
+
+func rsiValue(avgGain, avgLoss float64) float64 {
+	switch {
+	case avgLoss == 0 && avgGain == 0:
+		return 50 // flat: no direction either way
+	case avgLoss == 0:
+		return 100
+	}
+	return 100 - 100/(1+avgGain/avgLoss)
+}
+
+// Stochastic is the slow stochastic oscillator of bars given by their
+// highs, lows and closes: %K is where the close sits in the kPeriod
+// high-low range (50 when the range is flat), smoothed by an SMA over
+// smooth, and %D is %K's SMA over dPeriod. Both are aligned to the bars
+// from index kPeriod+smooth+dPeriod-3 on.
+func Stochastic(high, low, close []float64, kPeriod, smooth, dPeriod int) (k, d []float64) {
+	n := min(len(high), len(low), len(close))
+	if kPeriod <= 0 || n < kPeriod {
+		return []float64{}, []float64{}
+	}
+	raw := make([]float64, 0, n-kPeriod+1)
+	for i := kPeriod - 1; i < n; i++ {
+		hi, lo := slices.Max(high[i-kPeriod+1:i+1]), slices.Min(low[i-kPeriod+1:i+1])
+		if hi == lo {
+			raw = append(raw, 50)
+			continue
+		}
+		raw = append(raw, 100*(close[i]-lo)/(hi-lo))
+	}
+	k = SMA(raw, smooth)
+	d = SMA(k, dPeriod)
+	return k[len(k)-len(d):], d
+}
+
+// ---------------- Trend ----------------
+
+// MACD is the MACD line (fast EMA minus slow EMA), its signal-period EMA,
+// and their difference. All three are aligned to values[slow+signal-2:].
+func MACD(values []float64, fast, slow, signal int) (line, sig, hist []float64) {
+	fastEMA, slowEMA := EMA(values, fast), EMA(values, slow)
+	if len(slowEMA) == 0 {
+		return []float64{}, []float64{}, []float64{}
+	}
+	// Both EMAs end at the last value, so line up their tails
+	fastEMA = fastEMA[len(fastEMA)-len(slowEMA):]
+	line = make([]float64, len(slowEMA))
+	for i := range line {
+		line[i] = fastEMA[i] - slowEMA[i]
+	}
+	sig = EMA(line, signal)
+	line = line[len(line)-len(sig):]
+	hist = make([]float64, len(sig))
+	for i := range hist {
+		hist[i] = line[i] - sig[i]
+	}
+	return line, sig, hist
+}
+
+// ---------------- Volatility ----------------
+
+// Bollinger is the period SMA of values with bands k population standard
+// deviations above and below it, over the same rolling window. All three
+// are aligned like SMA.
+func Bollinger(values []float64, period int, k float64) (middle, upper, lower []float64) {
+	middle = SMA(values, period)
+	upper = make([]float64, len(middle))
+	lower = make([]float64, len(middle))
+	for i, mean := range middle {
+		var sq float64
+		for _, v := range values[i : i+period] {
+			sq += (v - mean) * (v - mean)
+		}
+		dev := k * math.Sqrt(sq/float64(period))
+		upper[i] = mean + dev
+		lower[i] = mean - dev
+	}
+	return middle, upper, lower
+}
+
+// ATR is the Average True Range of bars given by their highs, lows and
+// closes, using Wilder's smoothing. A true range needs the previous
+// close, so out is aligned to the bars from index period on and is empty
+// when there are period or fewer bars.
+func ATR(high, low, close []float64, period int) []float64 {
+	n := min(len(high), len(low), len(close))
+	if period <= 0 || n <= period {
+		return []float64{}
+	}
+	tr := func(i int) float64 {
+		prev := close[i-1]
+		return max(high[i]-low[i], math.Abs(high[i]-prev), math.Abs(low[i]-prev))
+	}
+	var avg float64
+	for i := 1; i <= period; i++ {
+		avg += tr(i)
+	}
+	avg /= float64(period)
+	out := make([]float64, 0, n-period)
+	out = append(out, avg)
+	for i := period + 1; i < n; i++ {
+		avg = (avg*float64(period-1) + tr(i)) / float64(period)
+		out = append(out, avg)
+	}
+	return out
+}
+
+// ---------------- Volume ----------------
+
+// VWAP is the running volume-weighted average of each bar's typical
+// price (h+l+c)/3, aligned to the bars. Until some volume has traded it
+// is just the typical price, so it is never NaN.
+func VWAP(high, low, close, volume []float64) []float64 {
+	n := min(len(high), len(low), len(close), len(volume))
+	out := make([]float64, n)
+	var pv, vol float64
+	for i := range n {
+		tp := (high[i] + low[i] + close[i]) / 3
+		pv += tp * volume[i]
+		vol += volume[i]
+		if vol > 0 {
+			out[i] = pv / vol
+		} else {
+			out[i] = tp
+		}
+	}
+	return out
+}
@@ -0,0 +1,292 @@
+package indicators
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// StockCharts' 10-day moving average worksheet (closes of a 30-day span)
+var refCloses = []float64{
+	22.27, 22.19, 22.08, 22.17, 22.18, 22.13, 22.23, 22.43, 22.24, 22.29,
+	22.15, 22.39, 22.38, 22.61, 23.36, 24.05, 23.75, 23.83, 23.95, 23.63,
+	23.82, 23.87, 23.65, 23.19, 23.10, 23.33, 22.68, 23.10, 22.40, 22.17,
+}
+
+// bars is a series of bars by field
+type bars struct {
+	high, low, close []float64
+}
+
+// flatBars makes bars whose high and low are their close
+func flatBars(closes ...float64) bars {
+	return bars{high: closes, low: closes, close: closes}
+}
+
+// assertSeries compares got with want to within tol
+func assertSeries(t *testing.T, name string, got, want []float64, tol float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d values, want %d: %v", name, len(got), len(want), got)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > tol {
+			t.Errorf("%s[%d] = %.4f, want %.4f", name, i, got[i], want[i])
+		}
+	}
+}
+
+// StockCharts' RSI worksheet: 14-period Wilder RSI over 33 closes. The
+// worksheet rounds its running averages, so it drifts from exact values
+// by a few hundredths.
+var (
+	rsiCloses = []float64{
+		44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08, 45.89,
+		46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64, 46.21, 46.25,
+		45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57, 43.42, 42.66, 43.13,
+	}
+	rsiReference = []float64{
+		70.53, 66.32, 66.55, 69.41, 66.36, 57.97, 62.93, 63.26, 56.06, 62.38,
+		54.71, 50.42, 39.99, 41.46, 41.87, 45.46, 37.30, 33.08, 37.77,
+	}
+)
+
+func TestMovingAverages(t *testing.T) {
+	tests := []struct {
+		name   string
+		fn     func([]float64, int) []float64
+		values []float64
+		period int
+		want   []float64
+		tol    float64
+	}{
+		{"sma short", SMA, []float64{1, 2, 3, 4, 5}, 3, []float64{2, 3, 4}, 1e-9},
+		{"sma period 1", SMA, []float64{4, 5}, 1, []float64{4, 5}, 1e-9},
+		{"sma too few", SMA, []float64{1, 2}, 3, []float64{}, 0},
+		{"sma zero period", SMA, []float64{1, 2}, 0, []float64{}, 0},
+		{"ema short", EMA, []float64{1, 2, 3, 6}, 3, []float64{2, 4}, 1e-9},
+		{"ema too few", EMA, []float64{1}, 2, []float64{}, 0},
+		{"sma reference", SMA, refCloses, 10, []float64{
+			22.22, 22.21, 22.23, 22.26, 22.30, 22.42, 22.61, 22.77, 22.91, 23.08, 23.21,
+			23.38, 23.52, 23.65, 23.71, 23.68, 23.61, 23.51, 23.43, 23.28, 23.13,
+		}, 0.006},
+		{"ema reference", EMA, refCloses, 10, []float64{
+			22.22, 22.21, 22.24, 22.27, 22.33, 22.52, 22.80, 22.97, 23.13, 23.28, 23.34,
+			23.43, 23.51, 23.53, 23.47, 23.40, 23.39, 23.26, 23.23, 23.08, 22.92,
+		}, 0.006},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, tt.name, tt.fn(tt.values, tt.period), tt.want, tt.tol)
+		})
+	}
+}
+
+func TestRSI(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		period int
+		want   []float64
+		tol    float64
+	}{
+		{"reference", rsiCloses, 14, rsiReference, 0.1},
+		{"flat", []float64{5, 5, 5, 5}, 2, []float64{50, 50}, 0},
+		{"only gains", []float64{1, 2, 3, 4}, 2, []float64{100, 100}, 0},
+		{"only losses", []float64{4, 3, 2, 1}, 2, []float64{0, 0}, 0},
+		// Wilder smoothing: averages of 0.5 each from +1 and -1, then +3
+		// makes avgGain (0.5+3)/2 = 1.75 and avgLoss 0.5/2 = 0.25
+		{"smoothing", []float64{10, 11, 10, 13}, 2, []float64{50, 87.5}, 1e-9},
+		{"too few", []float64{1, 2}, 2, []float64{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, "rsi", RSI(tt.values, tt.period), tt.want, tt.tol)
+		})
+	}
+}
+
+func TestStochastic(t *testing.T) {
+	// Raw %K over 3 bars is 75, 100/3, 100 and 0; smoothed over 2 that is
+	// 325/6, 200/3 and 50, and %D over 2 of those 725/12 and 175/3
+	b := bars{
+		high:  []float64{10, 11, 12, 12, 13, 11},
+		low:   []float64{8, 9, 10, 9, 11, 8},
+		close: []float64{9, 10, 11, 10, 13, 8},
+	}
+	tests := []struct {
+		name         string
+		b            bars
+		k, smooth, d int
+		wantK, wantD []float64
+	}{
+		{"hand computed", b, 3, 2, 2, []float64{200.0 / 3, 50}, []float64{725.0 / 12, 175.0 / 3}},
+		{"fast", b, 3, 1, 1, []float64{75, 100.0 / 3, 100, 0}, []float64{75, 100.0 / 3, 100, 0}},
+		{"flat window is 50", flatBars(5, 5, 5, 5, 5, 5), 3, 2, 2, []float64{50, 50}, []float64{50, 50}},
+		{"too few bars", flatBars(1, 2), 3, 1, 1, []float64{}, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, d := Stochastic(tt.b.high, tt.b.low, tt.b.close, tt.k, tt.smooth, tt.d)
+			assertSeries(t, "k", k, tt.wantK, 1e-9)
+			assertSeries(t, "d", d, tt.wantD, 1e-9)
+		})
+	}
+}
+
+func TestMACD(t *testing.T) {
+	closes := []float64{10, 11, 12, 11, 13, 14, 12}
+	tests := []struct {
+		name               string
+		values             []float64
+		fast, slow, signal int
+		line, sig, hist    []float64
+	}{
+		{
+			// Worked by hand in fractions: EMA(2) and EMA(3) seeded with
+			// their SMAs, then the signal EMA(2) of the line
+			"hand computed", closes, 2, 3, 2,
+			[]float64{1.0 / 6, 7.0 / 18, 25.0 / 54, -1.0 / 81},
+			[]float64{1.0 / 3, 10.0 / 27, 35.0 / 81, 11.0 / 81},
+			[]float64{-1.0 / 6, 1.0 / 54, 5.0 / 162, -4.0 / 27},
+		},
+		{
+			"flat", []float64{5, 5, 5, 5, 5, 5}, 2, 3, 2,
+			[]float64{0, 0, 0}, []float64{0, 0, 0}, []float64{0, 0, 0},
+		},
+		{"exactly enough", closes[:4], 2, 3, 2, []float64{1.0 / 6}, []float64{1.0 / 3}, []float64{-1.0 / 6}},
+		{"shorter than slow+signal-1", closes[:3], 2, 3, 2, []float64{}, []float64{}, []float64{}},
+		{"shorter than slow", closes[:2], 2, 3, 2, []float64{}, []float64{}, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, sig, hist := MACD(tt.values, tt.fast, tt.slow, tt.signal)
+			assertSeries(t, "macd", line, tt.line, 1e-12)
+			assertSeries(t, "signal", sig, tt.sig, 1e-12)
+			assertSeries(t, "histogram", hist, tt.hist, 1e-12)
+		})
+	}
+}
+
+// atrBars has true ranges 2, 2, 1, 3.5 (a gap up past the high) and 4 (a
+// gap down past the low), so Wilder's 3-period ATR is (2+2+1)/3 = 5/3,
+// then (5/3*2+3.5)/3 = 41/18 and (41/18*2+4)/3 = 77/27.
+var atrBars = bars{
+	high:  []float64{10, 11, 12, 11, 14, 12},
+	low:   []float64{8, 9, 10, 10, 11, 9},
+	close: []float64{9, 10, 11, 10.5, 13, 9.5},
+}
+
+func TestATR(t *testing.T) {
+	tests := []struct {
+		name   string
+		b      bars
+		period int
+		want   []float64
+	}{
+		{"hand computed", atrBars, 3, []float64{5.0 / 3, 41.0 / 18, 77.0 / 27}},
+		{"period 1 is the true range", atrBars, 1, []float64{2, 2, 1, 3.5, 4}},
+		{"exactly enough", atrBars, 5, []float64{(2 + 2 + 1 + 3.5 + 4) / 5.0}},
+		{"period bars are too few", atrBars, 6, []float64{}},
+		{"flat", flatBars(5, 5, 5, 5), 2, []float64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, "atr", ATR(tt.b.high, tt.b.low, tt.b.close, tt.period), tt.want, 1e-12)
+		})
+	}
+}
+
+func TestVWAP(t *testing.T) {
+	// bar builds a bar from its high, low, close and volume
+	bar := func(h, l, c, v float64) [4]float64 { return [4]float64{h, l, c, v} }
+	tests := []struct {
+		name string
+		bars [][4]float64
+		want []float64
+	}{
+		{
+			// Typical prices 10, 12, 14, 12 on volumes 100, 300, 0, 100:
+			// 1000/100, 4600/400, 4600/400, 5800/500
+			"hand computed",
+			[][4]float64{bar(12, 9, 9, 100), bar(13, 10, 13, 300), bar(15, 13, 14, 0), bar(14, 10, 12, 100)},
+			[]float64{10, 11.5, 11.5, 11.6},
+		},
+		{"single bar", [][4]float64{bar(3, 1, 2, 50)}, []float64{2}},
+		{
+			"no volume yet is the typical price",
+			[][4]float64{bar(12, 9, 9, 0), bar(13, 10, 13, 0), bar(14, 10, 12, 10)},
+			[]float64{10, 12, 12},
+		},
+		{"empty", nil, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var high, low, close, volume []float64
+			for _, b := range tt.bars {
+				high = append(high, b[0])
+				low = append(low, b[1])
+				close = append(close, b[2])
+				volume = append(volume, b[3])
+			}
+			assertSeries(t, "vwap", VWAP(high, low, close, volume), tt.want, 1e-9)
+		})
+	}
+}
+
+func TestBollinger(t *testing.T) {
+	dev := math.Sqrt(2.0 / 3) // population stddev of three consecutive integers
+	tests := []struct {
+		name                 string
+		values               []float64
+		period               int
+		k                    float64
+		middle, upper, lower []float64
+	}{
+		{"rising", []float64{1, 2, 3, 4, 5}, 3, 2,
+			[]float64{2, 3, 4}, []float64{2 + 2*dev, 3 + 2*dev, 4 + 2*dev}, []float64{2 - 2*dev, 3 - 2*dev, 4 - 2*dev}},
+		{"fractional k", []float64{1, 2, 3}, 3, 1.5,
+			[]float64{2}, []float64{2 + 1.5*dev}, []float64{2 - 1.5*dev}},
+		{"flat", []float64{7, 7, 7, 7}, 2, 2,
+			[]float64{7, 7, 7}, []float64{7, 7, 7}, []float64{7, 7, 7}},
+		// Population stddev of 2, 4, 4, 4, 5, 5, 7, 9 is exactly 2
+		{"textbook", []float64{2, 4, 4, 4, 5, 5, 7, 9}, 8, 1,
+			[]float64{5}, []float64{7}, []float64{3}},
+		{"too few", []float64{1, 2}, 3, 2, []float64{}, []float64{}, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middle, upper, lower := Bollinger(tt.values, tt.period, tt.k)
+			assertSeries(t, "middle", middle, tt.middle, 1e-9)
+			assertSeries(t, "upper", upper, tt.upper, 1e-9)
+			assertSeries(t, "lower", lower, tt.lower, 1e-9)
+		})
+	}
+}
+
+func TestBollingerSharesSMAWindow(t *testing.T) {
+	tests := []struct {
+		period int
+		k      float64
+	}{
+		{10, 2},
+		{20, 2},
+		{5, 1},
+		{30, 2.5},
+	}
+	for _, tt := range tests {
+		middle, upper, lower := Bollinger(refCloses, tt.period, tt.k)
+		assertSeries(t, fmt.Sprintf("middle(%d)", tt.period), middle, SMA(refCloses, tt.period), 1e-9)
+		for i, mean := range middle {
+			// The band width is the stddev of exactly the window the mean covers
+			var sq float64
+			for _, v := range refCloses[i : i+tt.period] {
+				sq += (v - mean) * (v - mean)
+			}
+			dev := tt.k * math.Sqrt(sq/float64(tt.period))
+			if math.Abs(upper[i]-mean-dev) > 1e-9 || math.Abs(mean-lower[i]-dev) > 1e-9 {
+				t.Errorf("period %d [%d]: bands %.4f/%.4f around %.4f, want ±%.4f", tt.period, i, lower[i], upper[i], mean, dev)
+			}
+		}
+	}
+}
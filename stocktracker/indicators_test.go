@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// StockCharts' 10-day moving average worksheet (closes of a 30-day span)
+var refCloses = []float64{
+	22.27, 22.19, 22.08, 22.17, 22.18, 22.13, 22.23, 22.43, 22.24, 22.29,
+	22.15, 22.39, 22.38, 22.61, 23.36, 24.05, 23.75, 23.83, 23.95, 23.63,
+	23.82, 23.87, 23.65, 23.19, 23.10, 23.33, 22.68, 23.10, 22.40, 22.17,
+}
+
+// candlesOf makes one-minute candles closing at closes, each with the
+// close as open, high and low and a volume of 1
+func candlesOf(closes ...float64) *Candles {
+	c := &Candles{S: "ok"}
+	for i, v := range closes {
+		c.Time = append(c.Time, 1717000000+int64(i)*60)
+		c.Open = append(c.Open, v)
+		c.High = append(c.High, v)
+		c.Low = append(c.Low, v)
+		c.Close = append(c.Close, v)
+		c.Volume = append(c.Volume, 1)
+	}
+	return c
+}
+
+func TestMAIndicatorParams(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"period=20", false},
+		{"period=20&type=sma", false},
+		{"period=20&type=ema", false},
+		{"period=2", false},
+		{"period=1", true},
+		{"period=0", true},
+		{"period=501", true},
+		{"", true},
+		{"period=20&type=wma", true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		if _, err := maIndicator(q); (err != nil) != tt.wantErr {
+			t.Errorf("maIndicator(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+// indicatorServer serves c to every candle fetch
+func indicatorServer(c *Candles) *server {
+	return &server{provider: &stubProvider{
+		candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+			return c, nil
+		},
+	}}
+}
+
+// getIndicator calls handleIndicator and decodes its JSON answer
+func getIndicator(t *testing.T, s *server, name, query string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/indicators/"+name+"?symbol=TSLA&"+query, nil)
+	req.SetPathValue("name", name)
+	rec := httptest.NewRecorder()
+	s.handleIndicator(rec, req)
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s?%s: %v: %s", name, query, err, rec.Body)
+	}
+	return rec.Code, body
+}
+
+func TestSMAAndEMAEndpoints(t *testing.T) {
+	s := indicatorServer(candlesOf(1, 2, 3, 6))
+	tests := []struct {
+		name, query string
+		want        []any
+	}{
+		{"sma", "period=3", []any{nil, nil, 2.0, 11.0 / 3}},
+		{"ema", "period=3", []any{nil, nil, 2.0, 4.0}},
+		{"sma", "period=4", []any{nil, nil, nil, 3.0}},
+		{"ema", "period=1", []any{1.0, 2.0, 3.0, 6.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"?"+tt.query, func(t *testing.T) {
+			code, body := getIndicator(t, s, tt.name, tt.query)
+			if code != http.StatusOK {
+				t.Fatalf("status %d: %v", code, body)
+			}
+			ts, series := body["t"].([]any), body[tt.name].([]any)
+			if len(ts) != 4 || ts[0] != 1717000000.0 || ts[3] != 1717000180.0 {
+				t.Errorf("t = %v, want the 4 candle times", ts)
+			}
+			if len(series) != len(tt.want) {
+				t.Fatalf("%s = %v, want %v", tt.name, series, tt.want)
+			}
+			for i := range tt.want {
+				if tt.want[i] == nil {
+					if series[i] != nil {
+						t.Errorf("%s[%d] = %v, want null", tt.name, i, series[i])
+					}
+				} else if series[i] == nil || math.Abs(series[i].(float64)-tt.want[i].(float64)) > 1e-9 {
+					t.Errorf("%s[%d] = %v, want %v", tt.name, i, series[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	// Too few candles for the period
+	code, body := getIndicator(t, s, "sma", "period=5")
+	if code != http.StatusUnprocessableEntity || body["status"] != "insufficient_data" {
+		t.Errorf("period=5 over 4 candles: status %d, body %v; want 422 insufficient_data", code, body)
+	}
+}
+
+func TestIndicatorUpstreamFailure(t *testing.T) {
+	s := &server{provider: &stubProvider{
+		candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+			return nil, errors.New("upstream down")
+		},
+	}}
+	req := httptest.NewRequest(http.MethodGet, "/api/indicators/sma?symbol=TSLA&period=3", nil)
+	req.SetPathValue("name", "sma")
+	rec := httptest.NewRecorder()
+	s.handleIndicator(rec, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502", rec.Code)
+	}
+}
+
+func TestIndicatorBadRequests(t *testing.T) {
+	s := indicatorServer(candlesOf(1, 2, 3))
+	tests := []struct {
+		name, query string
+		want        int
+	}{
+		{"wma", "period=3", http.StatusNotFound},
+		{"sma", "", http.StatusBadRequest},
+		{"sma", "period=0", http.StatusBadRequest},
+		{"ema", "period=x", http.StatusBadRequest},
+		{"sma", "period=" + strconv.Itoa(maxIndicatorPeriod+1), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, body := getIndicator(t, s, tt.name, tt.query); code != tt.want {
+			t.Errorf("%s?%s: status %d (%v), want %d", tt.name, tt.query, code, body, tt.want)
+		}
+	}
+}
+
+func TestIndicatorWarmupIsNull(t *testing.T) {
+	s := indicatorServer(candlesOf(1, 2, 3, 4, 5))
+	code, body := getIndicator(t, s, "ma", "period=3")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	ts, ma := body["t"].([]any), body["ma"].([]any)
+	if len(ts) != 5 || len(ma) != 5 {
+		t.Fatalf("t has %d values and ma %d, want one per candle", len(ts), len(ma))
+	}
+	want := []any{nil, nil, 2.0, 3.0, 4.0}
+	for i := range want {
+		if ma[i] != want[i] {
+			t.Errorf("ma[%d] = %v, want %v", i, ma[i], want[i])
+		}
+	}
+}
+
+func TestIndicatorInsufficientData(t *testing.T) {
+	s := indicatorServer(candlesOf(1, 2))
+	tests := []struct {
+		name, query string
+		required    float64
+	}{
+		{"ma", "period=3", 3},
+		{"rsi", "period=14", 15},
+		{"macd", "", 34},
+	}
+	for _, tt := range tests {
+		code, body := getIndicator(t, s, tt.name, tt.query)
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: status %d, want 422", tt.name, code)
+		}
+		if body["required"] != tt.required || body["bars"] != 2.0 || body["error"] == nil {
+			t.Errorf("%s: body %v, want required %v of 2 bars with an error", tt.name, body, tt.required)
+		}
+	}
+}
+
+func TestIndicatorNoData(t *testing.T) {
+	s := indicatorServer(&Candles{S: "no_data"})
+	code, body := getIndicator(t, s, "ma", "period=3")
+	if code != http.StatusOK || body["status"] != "no_data" {
+		t.Errorf("status %d, body %v; want 200 no_data", code, body)
+	}
+	if ma, ok := body["ma"].([]any); !ok || len(ma) != 0 {
+		t.Errorf("ma = %v, want an empty series", body["ma"])
+	}
+}
+
+// StockCharts' RSI worksheet: 14-period Wilder RSI over 33 closes. The
+// worksheet rounds its running averages, so it drifts from exact values
+// by a few hundredths.
+var (
+	rsiCloses = []float64{
+		44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08, 45.89,
+		46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64, 46.21, 46.25,
+		45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57, 43.42, 42.66, 43.13,
+	}
+	rsiReference = []float64{
+		70.53, 66.32, 66.55, 69.41, 66.36, 57.97, 62.93, 63.26, 56.06, 62.38,
+		54.71, 50.42, 39.99, 41.46, 41.87, 45.46, 37.30, 33.08, 37.77,
+	}
+)
+
+func TestRSIEndpoint(t *testing.T) {
+	s := indicatorServer(candlesOf(rsiCloses...))
+	code, body := getIndicator(t, s, "rsi", "period=14")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	series := body["rsi"].([]any)
+	if len(series) != len(rsiCloses) {
+		t.Fatalf("%d values, want one per candle", len(series))
+	}
+	for i, v := range series {
+		if (i < 14) != (v == nil) {
+			t.Errorf("rsi[%d] = %v; want null only for the first 14 candles", i, v)
+		}
+	}
+	if got := series[14].(float64); math.Abs(got-rsiReference[0]) > 0.1 {
+		t.Errorf("first value %.2f, want %.2f", got, rsiReference[0])
+	}
+	if body["note"] == nil {
+		t.Error("no note stating the flat-series convention")
+	}
+}
+
+func TestPeriodParam(t *testing.T) {
+	tests := []struct {
+		query   string
+		def     int
+		want    int
+		wantErr bool
+	}{
+		{"period=14", 0, 14, false},
+		{"period=1", 0, 1, false},
+		{"period=500", 0, 500, false},
+		{"", 0, 0, true}, // required
+		{"", 14, 14, false},
+		{"period=0", 14, 0, true},
+		{"period=501", 0, 0, true},
+		{"period=-3", 0, 0, true},
+		{"period=2.5", 0, 0, true},
+		{"period=ten", 14, 0, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := periodParam(q, "period", tt.def)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("periodParam(%q, %d) = %d, %v; want %d, error %v", tt.query, tt.def, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStochEndpoint(t *testing.T) {
+	c := candlesOf(9, 10, 11, 10, 13, 8)
+	c.High = []float64{10, 11, 12, 12, 13, 11}
+	c.Low = []float64{8, 9, 10, 9, 11, 8}
+	s := indicatorServer(c)
+	code, body := getIndicator(t, s, "stoch", "kPeriod=3&smooth=2&dPeriod=2")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	want := map[string][]any{
+		"k": {nil, nil, nil, nil, 200.0 / 3, 50.0},
+		"d": {nil, nil, nil, nil, 725.0 / 12, 175.0 / 3},
+	}
+	for name, w := range want {
+		got, _ := body[name].([]any)
+		if len(got) != len(w) {
+			t.Fatalf("%s has %d values, want %d", name, len(got), len(w))
+		}
+		for i := range w {
+			if (got[i] == nil) != (w[i] == nil) || got[i] != nil && math.Abs(got[i].(float64)-w[i].(float64)) > 1e-9 {
+				t.Errorf("%s[%d] = %v, want %v", name, i, got[i], w[i])
+			}
+		}
+	}
+	// The same shape as every other indicator
+	for _, key := range []string{"symbol", "indicator", "resolution", "from", "to", "status", "params", "t", "bars"} {
+		if _, ok := body[key]; !ok {
+			t.Errorf("response lacks %q", key)
+		}
+	}
+
+	tests := []struct {
+		query    string
+		wantCode int
+	}{
+		{"kPeriod=3&smooth=2&dPeriod=3", http.StatusOK},
+		{"kPeriod=3&smooth=2&dPeriod=4", http.StatusUnprocessableEntity},
+		{"", http.StatusUnprocessableEntity}, // 14, 3, 3 need 18 bars
+		{"kPeriod=0", http.StatusBadRequest},
+		{"dPeriod=501", http.StatusBadRequest},
+		{"smooth=x", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, body := getIndicator(t, s, "stoch", tt.query); code != tt.wantCode {
+			t.Errorf("%q: status %d, want %d: %v", tt.query, code, tt.wantCode, body)
+		}
+	}
+}
+
+func TestMACDIndicatorParams(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false}, // 12, 26, 9
+		{"fast=2&slow=3&signal=2", false},
+		{"fast=5&slow=35&signal=5", false},
+		{"fast=26&slow=12", true},
+		{"fast=12&slow=12", true},
+		{"fast=1&slow=3&signal=2", true},
+		{"fast=2&slow=3&signal=1", true},
+		{"fast=0", true},
+		{"slow=501", true},
+		{"signal=nine", true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		if _, err := macdIndicator(q); (err != nil) != tt.wantErr {
+			t.Errorf("macdIndicator(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMACDEndpoint(t *testing.T) {
+	s := indicatorServer(candlesOf(10, 11, 12, 11, 13, 14, 12))
+	code, body := getIndicator(t, s, "macd", "fast=2&slow=3&signal=2")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	if len(body["t"].([]any)) != 7 {
+		t.Fatalf("t has %d values, want one per candle", len(body["t"].([]any)))
+	}
+	// The first slow+signal-2 bars have no value yet
+	want := map[string][]any{
+		"macd":      {nil, nil, nil, 1.0 / 6, 7.0 / 18, 25.0 / 54, -1.0 / 81},
+		"signal":    {nil, nil, nil, 1.0 / 3, 10.0 / 27, 35.0 / 81, 11.0 / 81},
+		"histogram": {nil, nil, nil, -1.0 / 6, 1.0 / 54, 5.0 / 162, -4.0 / 27},
+	}
+	for name, w := range want {
+		got, _ := body[name].([]any)
+		if len(got) != len(w) {
+			t.Fatalf("%s has %d values, want %d", name, len(got), len(w))
+		}
+		for i := range w {
+			if (got[i] == nil) != (w[i] == nil) || got[i] != nil && math.Abs(got[i].(float64)-w[i].(float64)) > 1e-9 {
+				t.Errorf("%s[%d] = %v, want %v", name, i, got[i], w[i])
+			}
+		}
+	}
+
+	// One bar short of the first signal value
+	s = indicatorServer(candlesOf(10, 11, 12))
+	code, body = getIndicator(t, s, "macd", "fast=2&slow=3&signal=2")
+	if code != http.StatusUnprocessableEntity || body["required"] != 4.0 {
+		t.Errorf("3 bars: status %d, body %v; want 422 requiring 4", code, body)
+	}
+
+	for _, query := range []string{"fast=26&slow=12", "fast=1&slow=3"} {
+		if code, body := getIndicator(t, s, "macd", query); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, body %v; want 400", query, code, body)
+		}
+	}
+}
+
+// atrBars has true ranges 2, 2, 1, 3.5 (a gap up past the high) and 4 (a
+// gap down past the low), so Wilder's 3-period ATR is (2+2+1)/3 = 5/3,
+// then (5/3*2+3.5)/3 = 41/18 and (41/18*2+4)/3 = 77/27.
+func atrBars() *Candles {
+	c := candlesOf(9, 10, 11, 10.5, 13, 9.5)
+	c.High = []float64{10, 11, 12, 11, 14, 12}
+	c.Low = []float64{8, 9, 10, 10, 11, 9}
+	return c
+}
+
+func TestATREndpoint(t *testing.T) {
+	s := indicatorServer(atrBars())
+	code, body := getIndicator(t, s, "atr", "period=3&resolution=D")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	if body["bars"] != 6.0 || body["resolution"] != "D" {
+		t.Errorf("bars %v at %v, want 6 at D", body["bars"], body["resolution"])
+	}
+	// Undefined for the first bar and until period true ranges are in
+	want := []any{nil, nil, nil, 5.0 / 3, 41.0 / 18, 77.0 / 27}
+	got, _ := body["atr"].([]any)
+	if len(got) != len(want) {
+		t.Fatalf("atr has %d values, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if (got[i] == nil) != (want[i] == nil) || got[i] != nil && math.Abs(got[i].(float64)-want[i].(float64)) > 1e-9 {
+			t.Errorf("atr[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	tests := []struct {
+		query    string
+		wantCode int
+	}{
+		{"", http.StatusUnprocessableEntity}, // period 14 by default
+		{"period=5", http.StatusOK},
+		{"period=6", http.StatusUnprocessableEntity},
+		{"period=0", http.StatusBadRequest},
+		{"period=x", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		code, body := getIndicator(t, s, "atr", tt.query)
+		if code != tt.wantCode {
+			t.Errorf("%q: status %d, want %d: %v", tt.query, code, tt.wantCode, body)
+		}
+		if code == http.StatusUnprocessableEntity && body["bars"] != 6.0 {
+			t.Errorf("%q: bars %v, want the 6 fetched", tt.query, body["bars"])
+		}
+	}
+}
+
+func TestVWAPEndpoint(t *testing.T) {
+	// Typical prices 10, 12, 14, 12 on volumes 100, 300, 0, 100; the
+	// zero-volume bar leaves the running VWAP where it was
+	c := candlesOf(9, 13, 14, 12)
+	c.High = []float64{12, 13, 15, 14}
+	c.Low = []float64{9, 10, 13, 10}
+	c.Volume = []float64{100, 300, 0, 100}
+	s := indicatorServer(c)
+	code, body := getIndicator(t, s, "vwap", "minutes=120")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	ts, _ := body["t"].([]any)
+	got, _ := body["vwap"].([]any)
+	if len(ts) != 4 || len(got) != 4 {
+		t.Fatalf("t has %d values and vwap %d, want one per candle", len(ts), len(got))
+	}
+	for i, want := range []float64{10, 11.5, 11.5, 11.6} {
+		v, ok := got[i].(float64)
+		if !ok || math.Abs(v-want) > 1e-9 {
+			t.Errorf("vwap[%d] = %v, want %v", i, got[i], want)
+		}
+	}
+
+	// No volume at all: the typical price, never NaN
+	c = candlesOf(5, 6)
+	c.Volume = []float64{0, 0}
+	if code, body := getIndicator(t, indicatorServer(c), "vwap", ""); code != http.StatusOK || fmt.Sprint(body["vwap"]) != "[5 6]" {
+		t.Errorf("no volume: status %d, vwap %v; want [5 6]", code, body["vwap"])
+	}
+}
+
+func TestBollingerParams(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false},
+		{"period=10&stddev=1.5", false},
+		{"stddev=10", false},
+		{"stddev=0", true},
+		{"stddev=-1", true},
+		{"stddev=10.5", true},
+		{"stddev=NaN", true},
+		{"stddev=Inf", true},
+		{"stddev=two", true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		if _, err := bollingerIndicator(q); (err != nil) != tt.wantErr {
+			t.Errorf("bollingerIndicator(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBBandsEndpoint(t *testing.T) {
+	s := indicatorServer(candlesOf(1, 2, 3, 4, 5))
+	code, body := getIndicator(t, s, "bbands", "period=3&stddev=1.5")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	for _, k := range []string{"middle", "upper", "lower"} {
+		series := body[k].([]any)
+		if len(series) != 5 || series[0] != nil || series[1] != nil || series[2] == nil {
+			t.Errorf("%s = %v, want null for the 2 warm-up candles of 5", k, series)
+		}
+	}
+	if closes := body["close"].([]any); len(closes) != 5 || closes[0] != 1.0 {
+		t.Errorf("close = %v, want every close", closes)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/indicators/bbands?symbol=TSLA&stddev=NaN", nil)
+	req.SetPathValue("name", "bbands")
+	rec := httptest.NewRecorder()
+	s.handleIndicator(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("stddev=NaN: status %d, want 400", rec.Code)
+	}
+}
+
+func TestBollingerEndpointWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		closes   []float64
+		query    string
+		wantCode int
+	}{
+		{"default period", refCloses, "", http.StatusOK},
+		{"period equals candles", refCloses, "period=30", http.StatusOK},
+		{"period exceeds candles", refCloses, "period=31", http.StatusUnprocessableEntity},
+		{"short history", []float64{1, 2, 3}, "", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := getIndicator(t, indicatorServer(candlesOf(tt.closes...)), "bollinger", tt.query)
+			if code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %v", code, tt.wantCode, body)
+			}
+			if code != http.StatusOK {
+				if body["error"] == nil || body["bars"] != float64(len(tt.closes)) {
+					t.Errorf("body %v, want an error naming %d bars", body, len(tt.closes))
+				}
+				return
+			}
+			if len(body["middle"].([]any)) != len(tt.closes) {
+				t.Errorf("middle = %v, want one entry per candle", body["middle"])
+			}
+		})
+	}
+}
+
+func TestRSIWarmup(t *testing.T) {
+	// RSI needs period changes, so period+1 closes, before its first value
+	tests := []struct {
+		period, closes int
+		code           int
+		nulls          int
+	}{
+		{2, 3, http.StatusOK, 2},
+		{2, 2, http.StatusUnprocessableEntity, 0},
+		{5, 8, http.StatusOK, 5},
+		{14, 14, http.StatusUnprocessableEntity, 0},
+	}
+	for _, tt := range tests {
+		closes := make([]float64, tt.closes)
+		for i := range closes {
+			closes[i] = float64(100 + i%3)
+		}
+		code, body := getIndicator(t, indicatorServer(candlesOf(closes...)), "rsi", "period="+strconv.Itoa(tt.period))
+		if code != tt.code {
+			t.Errorf("period %d over %d closes: status %d, want %d", tt.period, tt.closes, code, tt.code)
+			continue
+		}
+		if code != http.StatusOK {
+			continue
+		}
+		series := body["rsi"].([]any)
+		nulls := 0
+		for _, v := range series {
+			if v == nil {
+				nulls++
+			}
+		}
+		if len(series) != tt.closes || nulls != tt.nulls || series[tt.nulls] == nil {
+			t.Errorf("period %d over %d closes: rsi = %v, want the first %d null", tt.period, tt.closes, series, tt.nulls)
+		}
+	}
+}
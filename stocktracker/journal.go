@@ -0,0 +1,82 @@
+package main
+
+import "time"
+
+const (
+	// Recent quotes kept per symbol for /ws clients resuming after a drop
+	journalSize = 256
+
+	// How long the journal of a symbol nobody polls any more is kept
+	journalTTL = 10 * time.Minute
+)
+
+// journal numbers a symbol's quotes and keeps the latest few. It outlives
+// the symbol's poller, so a client whose connection was the only
+// subscriber can still resume within journalTTL. Guarded by Hub.mu.
+type journal struct {
+	seq  uint64        // of the newest quote
+	ring []quoteUpdate // oldest first, at most journalSize
+	last time.Time     // when the newest quote was added
+}
+
+// append stamps u with the next sequence number and keeps it
+func (j *journal) append(u *quoteUpdate) {
+	j.seq++
+	u.Seq = j.seq
+	kept := *u
+	kept.Bar, kept.Closed = nil, nil // resumes replay quotes only
+	if len(j.ring) == journalSize {
+		j.ring = j.ring[1:]
+	}
+	j.ring = append(j.ring, kept)
+	j.last = time.Now()
+}
+
+// after returns the kept quotes numbered above seq, oldest first. gap is
+// true when some of them are no longer kept, or when seq is ahead of the
+// journal (numbering restarted with the server).
+func (j *journal) after(seq uint64) (missed []quoteUpdate, gap bool) {
+	if j == nil || seq > j.seq {
+		return nil, true
+	}
+	if seq == j.seq {
+		return nil, false
+	}
+	if seq+1 < j.ring[0].Seq {
+		return nil, true
+	}
+	for _, u := range j.ring {
+		if u.Seq > seq {
+			missed = append(missed, u)
+		}
+	}
+	return missed, false
+}
+
+// Resume subscribes sub to symbol like Subscribe and returns, in the same
+// step, the quotes published after seq, so none fall between the two.
+// latest is the symbol's newest sequence number, 0 if it has none.
+func (h *Hub) Resume(symbol string, sub *updateQueue, interval time.Duration, seq uint64) (missed []quoteUpdate, gap bool, latest uint64, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.subscribeLocked(symbol, sub, interval); err != nil {
+		return nil, false, 0, err
+	}
+	j := h.journals[symbol]
+	if j != nil {
+		latest = j.seq
+	}
+	missed, gap = j.after(seq)
+	return missed, gap, latest, nil
+}
+
+// pruneJournalsLocked drops the journals of symbols that are no longer
+// polled and have had no quote for journalTTL. Must be called with h.mu
+// held.
+func (h *Hub) pruneJournalsLocked(now time.Time) {
+	for symbol, j := range h.journals {
+		if _, polled := h.pollers[symbol]; !polled && now.Sub(j.last) > journalTTL {
+			delete(h.journals, symbol)
+		}
+	}
+}
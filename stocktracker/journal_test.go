@@ -0,0 +1,160 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+// journalOf is a journal that has numbered n quotes
+func journalOf(n int) *journal {
+	j := &journal{}
+	for i := range n {
+		j.append(&quoteUpdate{Symbol: "AAPL", Quote: &Quote{Current: float64(100 + i)}})
+	}
+	return j
+}
+
+func TestJournalAfter(t *testing.T) {
+	full := journalSize + 10 // the first 10 have aged out
+	tests := []struct {
+		name      string
+		j         *journal
+		seq       uint64
+		wantFirst uint64 // seq of the first missed quote; 0 for none
+		wantN     int
+		wantGap   bool
+	}{
+		{"up to date", journalOf(5), 5, 0, 0, false},
+		{"behind", journalOf(5), 2, 3, 3, false},
+		{"from the start", journalOf(5), 0, 1, 5, false},
+		{"ahead after a restart", journalOf(5), 9, 0, 0, true},
+		{"never polled", nil, 3, 0, 0, true},
+		{"oldest kept is next", journalOf(full), 10, 11, journalSize, false},
+		{"aged out", journalOf(full), 9, 0, 0, true},
+		{"from the start, aged out", journalOf(full), 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missed, gap := tt.j.after(tt.seq)
+			if gap != tt.wantGap || len(missed) != tt.wantN {
+				t.Fatalf("after(%d) = %d missed, gap %t; want %d, gap %t", tt.seq, len(missed), gap, tt.wantN, tt.wantGap)
+			}
+			for i, u := range missed {
+				if u.Seq != tt.wantFirst+uint64(i) {
+					t.Fatalf("missed[%d] is seq %d, want %d", i, u.Seq, tt.wantFirst+uint64(i))
+				}
+			}
+		})
+	}
+}
+
+func TestJournalKeepsQuotesOnly(t *testing.T) {
+	j := &journal{}
+	u := quoteUpdate{Symbol: "AAPL", Quote: &Quote{Current: 1}, Bar: &Bar{}, Closed: &Bar{}}
+	j.append(&u)
+	if u.Seq != 1 || u.Bar == nil {
+		t.Errorf("append left %+v, want it numbered and otherwise untouched", u)
+	}
+	if k := j.ring[0]; k.Seq != 1 || k.Bar != nil || k.Closed != nil {
+		t.Errorf("kept %+v, want the quote without its bars", k)
+	}
+}
+
+func TestParseResume(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]uint64
+		wantErr bool
+	}{
+		{"", map[string]uint64{}, false},
+		{"AAPL:41", map[string]uint64{"AAPL": 41}, false},
+		{"aapl:41,TSLA:7", map[string]uint64{"AAPL": 41, "TSLA": 7}, false},
+		{"BINANCE:BTCUSDT:3", map[string]uint64{"BINANCE:BTCUSDT": 3}, false},
+		{"AAPL: 0", map[string]uint64{"AAPL": 0}, false},
+		{"AAPL", nil, true},
+		{"AAPL:-1", nil, true},
+		{"AAPL:x", nil, true},
+		{"$$$:1", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseResume(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseResume(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !maps.Equal(got, tt.want) {
+			t.Errorf("parseResume(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	h := newHub((&countingFetch{}).fetch)
+	keep := newUpdateQueue()
+	h.Subscribe("AAPL", keep, time.Hour)
+	defer h.Unregister(keep)
+	waitFor(t, "the first poll", func() bool { return h.journalSeq("AAPL") == 1 })
+	for i := range 4 {
+		h.Trade("AAPL", float64(200+i), time.Now())
+	}
+
+	tests := []struct {
+		name       string
+		symbol     string
+		seq        uint64
+		wantMissed int
+		wantGap    bool
+		wantLatest uint64
+	}{
+		{"clean", "AAPL", 2, 3, false, 5},
+		{"up to date", "AAPL", 5, 0, false, 5},
+		{"ahead", "AAPL", 50, 0, true, 5},
+		{"never polled", "MSFT", 3, 0, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := newUpdateQueue()
+			defer h.Unregister(sub)
+			missed, gap, latest, err := h.Resume(tt.symbol, sub, time.Hour, tt.seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(missed) != tt.wantMissed || gap != tt.wantGap || latest != tt.wantLatest {
+				t.Errorf("Resume = %d missed, gap %t, latest %d; want %d, %t, %d",
+					len(missed), gap, latest, tt.wantMissed, tt.wantGap, tt.wantLatest)
+			}
+			if h.Subscribers()[tt.symbol] == 0 {
+				t.Errorf("Resume didn't subscribe to %s", tt.symbol)
+			}
+		})
+	}
+}
+
+func TestJournalOutlivesPoller(t *testing.T) {
+	h := newHub((&countingFetch{}).fetch)
+	sub := newUpdateQueue()
+	h.Subscribe("AAPL", sub, time.Hour)
+	waitFor(t, "the first poll", func() bool { return h.journalSeq("AAPL") == 1 })
+	h.Unregister(sub)
+
+	h.mu.Lock()
+	h.pruneJournalsLocked(time.Now())
+	kept := h.journals["AAPL"] != nil
+	h.pruneJournalsLocked(time.Now().Add(journalTTL + time.Second))
+	pruned := h.journals["AAPL"] == nil
+	h.mu.Unlock()
+	if !kept || !pruned {
+		t.Errorf("journal kept %t within the TTL and pruned %t after it; want both", kept, pruned)
+	}
+}
+
+// journalSeq is symbol's newest sequence number
+func (h *Hub) journalSeq(symbol string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if j := h.journals[symbol]; j != nil {
+		return j.seq
+	}
+	return 0
+}
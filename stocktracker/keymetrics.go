@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// How long a company's key metrics are reused; they move slowly
+const keyMetricsCacheTTL = 4 * time.Hour
+
+// keyMetricsResp is /api/metrics's answer. Figures Finnhub doesn't have
+// are null.
+type keyMetricsResp struct {
+	Symbol          string   `json:"symbol"`
+	High52Week      *float64 `json:"high52Week"`
+	High52WeekDate  *string  `json:"high52WeekDate"` // YYYY-MM-DD
+	Low52Week       *float64 `json:"low52Week"`
+	Low52WeekDate   *string  `json:"low52WeekDate"`
+	Beta            *float64 `json:"beta"`
+	AvgVolume10Day  *float64 `json:"avgVolume10Day"` // millions of shares
+	AvgVolume3Month *float64 `json:"avgVolume3Month"`
+	PE              *float64 `json:"peTTM"`
+	DividendYield   *float64 `json:"dividendYield"` // percent, indicated annual
+}
+
+// GET /api/metrics?symbol=MSFT
+// A company's key figures from Finnhub's basic financials:
+//
+//	{"symbol":"MSFT","high52Week":468.35,"high52WeekDate":"2024-07-05",
+//	 "low52Week":309.45,"low52WeekDate":"2023-10-26","beta":0.89,
+//	 "avgVolume10Day":17.6,"avgVolume3Month":19.2,"peTTM":36.2,
+//	 "dividendYield":0.71}
+//
+// Cached for four hours per symbol. A symbol with no figures at all
+// answers 404. (Prometheus metrics are at /metrics.)
+func (s *server) handleKeyMetrics(w http.ResponseWriter, r *http.Request) {
+	symbol, err := querySymbol(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	m, ok := s.metrics.get(symbol)
+	if !ok {
+		if m, err = s.provider.Metrics(r.Context(), symbol); err != nil {
+			badGateway(w, r, err)
+			return
+		}
+		if *m == (Metrics{}) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown_symbol"})
+			return
+		}
+		s.metrics.put(symbol, m)
+	}
+	writeJSON(w, http.StatusOK, keyMetricsResp{
+		Symbol:          symbol,
+		High52Week:      m.High52Week,
+		High52WeekDate:  m.High52WeekDate,
+		Low52Week:       m.Low52Week,
+		Low52WeekDate:   m.Low52WeekDate,
+		Beta:            m.Beta,
+		AvgVolume10Day:  m.AvgVolume10Day,
+		AvgVolume3Month: m.AvgVolume3Month,
+		PE:              m.PE,
+		DividendYield:   m.DividendYield,
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		wantCode  int
+		wantPanic string // logged panic value; "" when nothing is logged
+	}{
+		{
+			name:     "no panic",
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) },
+			wantCode: http.StatusTeapot,
+		},
+		{
+			name: "nil pointer",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var q *Quote
+				_ = q.Current
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantPanic: "nil pointer dereference",
+		},
+		{
+			name: "bad type assertion",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var v any = "AAPL"
+				_ = v.(int)
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantPanic: "interface conversion",
+		},
+		{
+			name:      "error value",
+			handler:   func(w http.ResponseWriter, r *http.Request) { panic(errors.New("boom")) },
+			wantCode:  http.StatusInternalServerError,
+			wantPanic: "boom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+			rec := httptest.NewRecorder()
+			recoverMiddleware(tt.handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/quote", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantPanic == "" {
+				if logs.Len() != 0 {
+					t.Errorf("logged %s, want nothing", logs.String())
+				}
+				return
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] != "internal_error" {
+				t.Errorf("body %q, want an internal_error JSON answer", rec.Body.String())
+			}
+			var entry map[string]any
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatalf("log %q: %v", logs.String(), err)
+			}
+			if p, _ := entry["panic"].(string); !strings.Contains(p, tt.wantPanic) {
+				t.Errorf("logged panic %q, want it to mention %q", p, tt.wantPanic)
+			}
+			if stack, _ := entry["stack"].(string); !strings.Contains(stack, "TestRecoverMiddleware") {
+				t.Errorf("logged stack doesn't reach the handler:\n%s", stack)
+			}
+			if entry["path"] != "/api/quote" {
+				t.Errorf("logged path %v", entry["path"])
+			}
+		})
+	}
+}
+
+func TestRecoverMiddlewareKeepsAbortHandler(t *testing.T) {
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", p)
+		}
+	}()
+	h := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestRecoverMiddlewareServesOn(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) { panic("bad request") })
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	ts := httptest.NewServer(recoverMiddleware(mux))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/panic", http.StatusInternalServerError},
+		{"/ok", http.StatusOK},
+		{"/panic", http.StatusInternalServerError},
+		{"/ok", http.StatusOK},
+	} {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
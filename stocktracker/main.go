@@ -1,220 +1,764 @@
-package main
-
-import (
-	"encoding/json"
-	"fmt"
-	"log"
-	"net/http"
-	"strconv"
-	"time"
-
-	"github.com/gorilla/websocket"
-)
-
-const (
-	// Finnhub API key (replace if needed)
-	apiKey = "d2eb0h1r01qr1ro92pd0d2eb0h1r01qr1ro92pdg"
-
-	// Rate: be mindful of Finnhub free-tier limits
-	livePollInterval = 5 * time.Second
-	serverAddr       = ":8080"
-)
-
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true }, // same-origin in practice
-}
-
-// Finnhub REST responses
-type quoteResp struct {
-	Current   float64 `json:"c"`
-	High      float64 `json:"h"`
-	Low       float64 `json:"l"`
-	Open      float64 `json:"o"`
-	PrevClose float64 `json:"pc"`
-}
-
-type candleResp struct {
-	Close  []float64 `json:"c"`
-	High   []float64 `json:"h"`
-	Low    []float64 `json:"l"`
-	Open   []float64 `json:"o"`
-	Time   []int64   `json:"t"` // UNIX seconds
-	Volume []float64 `json:"v"`
-	S      string    `json:"s"` // "ok" or "no_data"
-}
-
-// ---------------- HTTP Helpers ----------------
-
-func writeJSON(w http.ResponseWriter, status int, v any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(v)
-}
-
-func badRequest(w http.ResponseWriter, msg string) {
-	writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
-}
-
-func serverError(w http.ResponseWriter, err error) {
-	log.Println("server error:", err)
-	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal_error"})
-}
-
-// ---------------- Finnhub Calls ----------------
-
-var httpClient = &http.Client{Timeout: 10 * time.Second}
-
-func fetchQuote(symbol string) (*quoteResp, error) {
-	url := fmt.Sprintf("https://finnhub.io/api/v1/quote?symbol=%s&token=%s", symbol, apiKey)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("quote status %s", resp.Status)
-	}
-
-	var q quoteResp
-	if err := json.NewDecoder(resp.Body).Decode(&q); err != nil {
-		return nil, err
-	}
-	return &q, nil
-}
-
-func fetchCandles(symbol string, minutes int) (*candleResp, error) {
-	if minutes <= 0 {
-		minutes = 60
-	}
-	to := time.Now().Unix()
-	from := time.Now().Add(-time.Duration(minutes) * time.Minute).Unix()
-	url := fmt.Sprintf("https://finnhub.io/api/v1/stock/candle?symbol=%s&resolution=1&from=%d&to=%d&token=%s",
-		symbol, from, to, apiKey)
-
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("candle status %s", resp.Status)
-	}
-
-	var c candleResp
-	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
-		return nil, err
-	}
-	return &c, nil
-}
-
-// ---------------- HTTP Handlers ----------------
-
-// Serves the static frontend
-func handleStatic(w http.ResponseWriter, r *http.Request) {
-	// default route -> index.html
-	if r.URL.Path == "/" {
-		http.ServeFile(w, r, "static/index.html")
-		return
-	}
-	http.FileServer(http.Dir("./static")).ServeHTTP(w, r)
-}
-
-// GET /api/candles?symbol=TSLA&minutes=60
-func handleCandles(w http.ResponseWriter, r *http.Request) {
-	symbol := r.URL.Query().Get("symbol")
-	if symbol == "" {
-		badRequest(w, "symbol is required")
-		return
-	}
-
-	minStr := r.URL.Query().Get("minutes")
-	minutes := 60
-	if minStr != "" {
-		if v, err := strconv.Atoi(minStr); err == nil && v > 0 && v <= 5000 {
-			minutes = v
-		}
-	}
-
-	c, err := fetchCandles(symbol, minutes)
-	if err != nil {
-		serverError(w, err)
-		return
-	}
-	if c.S != "ok" || len(c.Time) == 0 {
-		writeJSON(w, http.StatusOK, map[string]any{
-			"symbol":  symbol,
-			"status":  c.S,
-			"candles": []any{},
-		})
-		return
-	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"symbol": symbol,
-		"status": c.S,
-		"t":      c.Time,
-		"o":      c.Open,
-		"h":      c.High,
-		"l":      c.Low,
-		"c":      c.Close,
-		"v":      c.Volume,
-	})
-}
-
-// WS /ws?symbol=TSLA
-// Streams the latest quote periodically (JSON with time + price)
-func handleWS(w http.ResponseWriter, r *http.Request) {
-	symbol := r.URL.Query().Get("symbol")
-	if symbol == "" {
-		symbol = "AAPL"
-	}
-
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("ws upgrade:", err)
-		return
-	}
-	defer conn.Close()
-
-	ticker := time.NewTicker(livePollInterval)
-	defer ticker.Stop()
-
-	// First tick immediately
-	sendQuote := func() error {
-		q, err := fetchQuote(symbol)
-		if err != nil {
-			return err
-		}
-		payload := map[string]any{
-			"symbol": symbol,
-			"price":  q.Current,
-			"time":   time.Now().UnixMilli(),
-		}
-		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-		return conn.WriteJSON(payload)
-	}
-
-	if err := sendQuote(); err != nil {
-		log.Println("ws first send:", err)
-		return
-	}
-
-	for range ticker.C {
-		if err := sendQuote(); err != nil {
-			log.Println("ws send:", err)
-			return
-		}
-	}
-}
-
-func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handleStatic)
-	mux.HandleFunc("/api/candles", handleCandles)
-	mux.HandleFunc("/ws", handleWS)
-
-	log.Printf("Server running at http://localhost%s\n", serverAddr)
-	log.Fatal(http.ListenAndServe(serverAddr, mux))
-}
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// shutdownTimeout bounds how long in-flight requests get to finish
+	shutdownTimeout = 10 * time.Second
+
+	// How often the market session is re-checked for /ws pushes
+	marketCheckPeriod = 30 * time.Second
+
+	// Limits for GET /api/quotes
+	maxBatchSymbols  = 50
+	batchConcurrency = 5
+	batchTimeout     = 10 * time.Second
+
+	// /readyz fetches this quote, giving up after readyTimeout
+	readySymbol  = "AAPL"
+	readyTimeout = 3 * time.Second
+)
+
+// resolutionSpec bounds the candle windows served at one resolution
+type resolutionSpec struct {
+	lookback time.Duration // served when neither minutes nor from/to is given
+	maxSpan  time.Duration // longest from/to window accepted
+}
+
+const day = 24 * time.Hour
+
+// Candle resolutions Finnhub accepts: minutes, then day/week/month. The
+// default windows come to roughly 60 to 200 bars; longer intraday windows
+// are fetched from Finnhub in chunks (see candleChunks).
+var resolutions = map[string]resolutionSpec{
+	"1":  {time.Hour, 90 * day},
+	"5":  {8 * time.Hour, 180 * day},
+	"15": {2 * day, 365 * day},
+	"30": {5 * day, 365 * day},
+	"60": {10 * day, 2 * 365 * day},
+	"D":  {182 * day, 5 * 365 * day},
+	"W":  {2 * 365 * day, 20 * 365 * day},
+	"M":  {5 * 365 * day, 30 * 365 * day},
+}
+
+// server holds the dependencies shared by the HTTP handlers
+type server struct {
+	provider Provider
+	hub      *Hub
+	stream   *finnhubStream // nil when streaming is disabled
+	conns    wsConns        // open /ws connections
+	market   *marketWatcher
+	alerts   *alertEngine
+	inbox    *alertInbox // routes fired alerts to /ws connections
+	history  *quoteStore // nil unless -db is set
+	polls    pollWaiters // /api/poll requests waiting per symbol
+
+	watchlist *watchlist // nil unless -watchlist is set
+
+	// Lookups that change slowly enough to cache for minutes or hours
+	searches *ttlCache[[]SymbolMatch]   // by lowercased query
+	profiles *ttlCache[*Profile]        // by symbol
+	news     *ttlCache[[]NewsItem]      // by symbol, from and to
+	statuses *ttlCache[*ExchangeStatus] // by exchange
+	metrics  *ttlCache[*Metrics]        // by symbol
+
+	// Closed when shutdown starts, ending long-lived /events streams
+	done chan struct{}
+}
+
+// ---------------- HTTP Helpers ----------------
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func badRequest(w http.ResponseWriter, msg string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+}
+
+// badGateway reports a failed upstream (Finnhub) call: 429 when it was
+// rate limited, here or by Finnhub, and 502 otherwise
+func badGateway(w http.ResponseWriter, r *http.Request, err error) {
+	logFrom(r.Context()).Warn("upstream error", "path", r.URL.Path, "err", err)
+	if errors.Is(err, ErrRateLimited) {
+		var rl *RateLimitError
+		if errors.As(err, &rl) && rl.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rl.RetryAfter.Seconds()))))
+		}
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate_limited"})
+		return
+	}
+	if errors.Is(err, ErrTooManyBars) {
+		badRequest(w, fmt.Sprintf("the range holds over %d candles; narrow it or use a coarser resolution", maxCandleBars))
+		return
+	}
+	writeJSON(w, http.StatusBadGateway, map[string]string{"error": "upstream_unavailable"})
+}
+
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	logFrom(r.Context()).Error("server error", "path", r.URL.Path, "err", err)
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal_error"})
+}
+
+// parseRange parses an explicit from/to window, each given in UNIX
+// seconds or RFC 3339. A to in the future is clamped to now; a from in the
+// future, or a window longer than maxSpan, is an error.
+func parseRange(fromStr, toStr string, maxSpan time.Duration, now time.Time) (from, to time.Time, err error) {
+	if fromStr == "" || toStr == "" {
+		return from, to, errors.New("from and to must be given together")
+	}
+	from, err1 := parseTimeParam(fromStr)
+	to, err2 := parseTimeParam(toStr)
+	if err1 != nil || err2 != nil {
+		return from, to, errors.New("from and to must be UNIX seconds or RFC 3339 times")
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+	if !from.Before(now) {
+		return from, to, errors.New("from must not be in the future")
+	}
+	if to.After(now) {
+		to = now
+	}
+	if to.Sub(from) > maxSpan {
+		return from, to, fmt.Errorf("range must not exceed %d days at this resolution", int(maxSpan/day))
+	}
+	return from, to, nil
+}
+
+// parseTimeParam reads a time given as UNIX seconds or RFC 3339
+func parseTimeParam(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// quoteMsg is the quote payload shared by /api/quote and /ws. change and
+// changePercent are left out when there is no previous close.
+func quoteMsg(symbol string, q *Quote, at time.Time) map[string]any {
+	m := map[string]any{
+		"symbol":    symbol,
+		"price":     q.Current,
+		"time":      at.UnixMilli(),
+		"open":      q.Open,
+		"high":      q.High,
+		"low":       q.Low,
+		"prevClose": q.PrevClose,
+	}
+	addChange(m, q.PrevClose, q.Current)
+	return m
+}
+
+// ---------------- HTTP Handlers ----------------
+
+// Serves the static frontend. Paths that match no file and have no
+// extension are client-side routes (e.g. /chart/AAPL) and get index.html;
+// a missing asset such as /app.js, or an unknown /api or /ws path, is
+// still a 404.
+func handleStatic(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+	if p == "/api" || strings.HasPrefix(p, "/api/") || p == "/ws" || strings.HasPrefix(p, "/ws/") {
+		http.NotFound(w, r)
+		return
+	}
+	// default route -> index.html
+	if p == "/" {
+		http.ServeFile(w, r, filepath.Join(cfg.StaticDir, "index.html"))
+		return
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StaticDir, filepath.FromSlash(p))); errors.Is(err, fs.ErrNotExist) && path.Ext(p) == "" {
+		http.ServeFile(w, r, filepath.Join(cfg.StaticDir, "index.html"))
+		return
+	}
+	http.FileServer(http.Dir(cfg.StaticDir)).ServeHTTP(w, r)
+}
+
+// GET /healthz
+// Liveness: answers as long as the process is serving. It never calls
+// Finnhub, so upstream trouble or rate limits can't fail it. With -stream
+// it also shows the trade socket's state; quotes are polled while it is
+// down.
+//
+//	{"status":"ok","stream":{"state":"disconnected","since":"...",
+//	 "reconnectAttempts":3,"error":"..."}}
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{"status": "ok"}
+	if s.stream != nil {
+		resp["stream"] = s.stream.State()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GET /readyz
+// Readiness: fetches one quote through the usual provider stack (so a
+// recently cached quote counts) and answers 503 if Finnhub can't serve it.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	if _, err := s.provider.Quote(ctx, readySymbol); err != nil {
+		logFrom(r.Context()).Warn("readiness check failed", "err", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": "upstream_unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET /api/quote?symbol=AAPL
+// One-shot lookup returning the /ws quote message; time is when the server
+// fetched it. A symbol Finnhub doesn't know answers 404.
+func (s *server) handleQuote(w http.ResponseWriter, r *http.Request) {
+	symbol, err := querySymbol(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	q, err := s.provider.Quote(r.Context(), symbol)
+	if err != nil {
+		badGateway(w, r, err)
+		return
+	}
+	if q.Empty() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown_symbol"})
+		return
+	}
+	writeJSON(w, http.StatusOK, quoteMsg(symbol, q, time.Now()))
+}
+
+// GET /api/candles.csv?symbol=AAPL&minutes=120
+// GET /api/candles?symbol=AAPL&minutes=120&format=csv
+// Same parameters as /api/candles; one time,open,high,low,close,volume row
+// per bar, written as it goes, with ISO-8601 UTC times or, with
+// ?times=unix, UNIX seconds. The file is named like AAPL_1m_20240607.csv
+// after the symbol, resolution and end date. No data yields just the
+// header row and an X-Status: no_data header.
+func (s *server) handleCandlesCSV(w http.ResponseWriter, r *http.Request) {
+	q, err := parseCandleQuery(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	unixTimes := false
+	switch r.URL.Query().Get("times") {
+	case "", "rfc3339":
+	case "unix":
+		unixTimes = true
+	default:
+		badRequest(w, "times must be rfc3339 or unix")
+		return
+	}
+	c, _, err := s.candles(r.Context(), q)
+	if err != nil {
+		badGateway(w, r, err)
+		return
+	}
+
+	label := resolutionLabel(q.resolution)
+	if q.aggregate != "" {
+		label = q.aggregate
+	}
+	name := fmt.Sprintf("%s_%s_%s.csv", strings.ReplaceAll(q.symbol, ":", "_"),
+		label, q.to.UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("X-Status", c.S)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "open", "high", "low", "close", "volume"})
+	if c.S == "ok" {
+		for i := range c.Len() {
+			ts := time.Unix(c.Time[i], 0).UTC().Format(time.RFC3339)
+			if unixTimes {
+				ts = strconv.FormatInt(c.Time[i], 10)
+			}
+			cw.Write([]string{
+				ts,
+				formatFloat(c.Open[i]),
+				formatFloat(c.High[i]),
+				formatFloat(c.Low[i]),
+				formatFloat(c.Close[i]),
+				formatFloat(c.Volume[i]),
+			})
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logFrom(r.Context()).Warn("candles csv write", "err", err)
+	}
+}
+
+// resolutionLabel names a resolution for file names: 1m, 60m, 1d, 1w, 1mo
+func resolutionLabel(res string) string {
+	switch res {
+	case "D":
+		return "1d"
+	case "W":
+		return "1w"
+	case "M":
+		return "1mo"
+	}
+	return res + "m"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// GET /api/quotes?symbols=AAPL,TSLA,MSFT (up to 50 symbols)
+// Maps each symbol to its quote, or to {"error": ...} if that one failed.
+func (s *server) handleQuotes(w http.ResponseWriter, r *http.Request) {
+	symbols, err := parseSymbols(r.URL.Query().Get("symbols"))
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	slices.Sort(symbols)
+	symbols = slices.Compact(symbols)
+	if len(symbols) == 0 {
+		badRequest(w, "symbols is required")
+		return
+	}
+	if len(symbols) > maxBatchSymbols {
+		badRequest(w, fmt.Sprintf("at most %d symbols per request", maxBatchSymbols))
+		return
+	}
+
+	quotes := s.fetchQuotes(r.Context(), symbols)
+	if r.Context().Err() != nil {
+		return // nobody left to answer
+	}
+
+	now := time.Now()
+	out := make(map[string]any, len(symbols))
+	for i, sym := range symbols {
+		if quotes[i] == nil {
+			out[sym] = map[string]string{"error": "quote_unavailable"}
+			continue
+		}
+		out[sym] = quoteMsg(sym, quotes[i], now)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// fetchQuotes fetches the quotes for symbols concurrently, giving up after
+// batchTimeout; a quote that couldn't be fetched is nil.
+func (s *server) fetchQuotes(ctx context.Context, symbols []string) []*Quote {
+	log := logFrom(ctx)
+	ctx, cancel := context.WithTimeout(ctx, batchTimeout)
+	defer cancel()
+
+	quotes := make([]*Quote, len(symbols))
+	var g errgroup.Group
+	g.SetLimit(batchConcurrency)
+	for i, sym := range symbols {
+		g.Go(func() error {
+			// The provider shares calls across callers and only stops them
+			// at the first caller's deadline, so don't start any once the
+			// client has gone
+			if ctx.Err() != nil {
+				return nil
+			}
+			q, err := s.provider.Quote(ctx, sym)
+			if err != nil {
+				log.Warn("quote failed", "symbol", sym, "err", err)
+				return nil
+			}
+			quotes[i] = q
+			return nil
+		})
+	}
+	g.Wait()
+	return quotes
+}
+
+// candleQuery is the candle window shared by /api/candles and the
+// indicator endpoints: ?symbol=, then either ?minutes= back from now or an
+// explicit ?from=&to= (UNIX seconds or RFC 3339), plus an optional
+// ?resolution= (default 1) or ?aggregate=. With neither, the window scales
+// with the resolution: 60 minutes of 1-minute bars, six months of daily
+// ones.
+type candleQuery struct {
+	symbol     string
+	resolution string
+	from, to   time.Time
+
+	// From ?aggregate=2m or 4h: the bars of resolution are resampled into
+	// buckets this long
+	aggregate string
+	period    time.Duration
+
+	fill  bool   // ?fill=true: synthesize bars for intraday gaps
+	style string // ?style=: styleCandles or styleHeikinAshi
+}
+
+func parseCandleQuery(r *http.Request) (candleQuery, error) {
+	var q candleQuery
+	var err error
+	if q.symbol, err = querySymbol(r); err != nil {
+		return q, err
+	}
+
+	q.resolution = r.URL.Query().Get("resolution")
+	if q.aggregate = r.URL.Query().Get("aggregate"); q.aggregate != "" {
+		if q.resolution != "" {
+			return q, errors.New("give either resolution or aggregate, not both")
+		}
+		if q.period, q.resolution, err = parseAggregate(q.aggregate); err != nil {
+			return q, err
+		}
+	}
+	if q.resolution == "" {
+		q.resolution = "1"
+	}
+	spec, ok := resolutions[q.resolution]
+	if !ok {
+		return q, errors.New("resolution must be one of 1, 5, 15, 30, 60, D, W, M")
+	}
+
+	if v := r.URL.Query().Get("fill"); v != "" {
+		if q.fill, err = strconv.ParseBool(v); err != nil {
+			return q, errors.New("fill must be true or false")
+		}
+		if q.fill && q.period == 0 && !intraday(q.resolution) {
+			return q, errors.New("fill needs a resolution in minutes")
+		}
+	}
+
+	if q.style, err = parseStyle(r.URL.Query().Get("style")); err != nil {
+		return q, err
+	}
+
+	now := time.Now()
+	minStr := r.URL.Query().Get("minutes")
+	if fromStr, toStr := r.URL.Query().Get("from"), r.URL.Query().Get("to"); fromStr != "" || toStr != "" {
+		if minStr != "" {
+			return q, errors.New("give either minutes or from and to, not both")
+		}
+		q.from, q.to, err = parseRange(fromStr, toStr, spec.maxSpan, now)
+		return q, err
+	}
+
+	window := spec.lookback
+	if minStr != "" {
+		v, err := strconv.Atoi(minStr)
+		if err != nil || v < 1 || v > 5000 {
+			return q, errors.New("minutes must be an integer between 1 and 5000")
+		}
+		window = time.Duration(v) * time.Minute
+	}
+	q.to = now
+	q.from = q.to.Add(-window)
+	return q, nil
+}
+
+var aggregatePattern = regexp.MustCompile(`^([1-9][0-9]{0,3})([mh])$`)
+
+// parseAggregate reads an ?aggregate= period like 2m or 4h, which must
+// divide a day evenly so buckets line up with the clock. resolution is
+// the coarsest Finnhub intraday resolution that divides it; resampling
+// from it gives the same buckets as from 1-minute bars, for fewer bars.
+func parseAggregate(s string) (period time.Duration, resolution string, err error) {
+	m := aggregatePattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, "", errors.New("aggregate must be minutes or hours, like 2m or 4h")
+	}
+	n, _ := strconv.Atoi(m[1])
+	period = time.Duration(n) * time.Minute
+	if m[2] == "h" {
+		period = time.Duration(n) * time.Hour
+	}
+	if period > day || day%period != 0 {
+		return 0, "", errors.New("aggregate must divide a day evenly, like 2m, 10m, 4h or 24h")
+	}
+	mins := int(period / time.Minute)
+	for _, res := range []int{60, 30, 15, 5, 1} {
+		if mins%res == 0 {
+			return period, strconv.Itoa(res), nil
+		}
+	}
+	return period, "1", nil
+}
+
+// intraday reports whether res is a resolution in minutes
+func intraday(res string) bool {
+	_, err := strconv.Atoi(res)
+	return err == nil
+}
+
+// candles fetches q's window from the provider, resampled into q's
+// aggregate buckets when it has one, gap-filled and restyled when it asks.
+// partial reports that the last bucket is still open.
+func (s *server) candles(ctx context.Context, q candleQuery) (c *Candles, partial bool, err error) {
+	c, err = s.provider.Candles(ctx, q.symbol, q.from, q.to, q.resolution)
+	if err != nil || c.S != "ok" {
+		return c, false, err
+	}
+	step := q.period
+	if step > 0 {
+		c, partial = resampleCandles(c, q.period, q.to)
+	} else if q.fill {
+		n, _ := strconv.Atoi(q.resolution)
+		step = time.Duration(n) * time.Minute
+	}
+	if q.fill {
+		if c, err = fillGaps(c, step); err != nil {
+			return nil, false, err
+		}
+	}
+	if q.style == styleHeikinAshi {
+		c = heikinAshi(c)
+	}
+	return c, partial, nil
+}
+
+// GET /api/candles?symbol=TSLA&minutes=60&resolution=5
+// GET /api/candles?symbol=TSLA&from=1717000000&to=1717086400 (UNIX seconds)
+// GET /api/candles?symbol=TSLA&from=2024-05-28T13:30:00Z&to=2024-05-28T20:00:00Z
+// GET /api/candles?symbol=TSLA&minutes=600&aggregate=2h
+// from and to in the response are the window actually served, in UNIX
+// seconds, so a to clamped to now shows. aggregate resamples the bars into
+// buckets Finnhub has no resolution for, aligned to UTC wall-clock
+// boundaries; the response then carries aggregate and partial, true when
+// the last bucket ends after to and so is still forming. fill=true evens
+// out intraday series by adding a bar for each step without trades (the
+// previous close throughout, zero volume). style=heikin-ashi sends
+// Heikin-Ashi bars instead of plain candles; style in the response says
+// which.
+func (s *server) handleCandles(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("format") == "csv" {
+		s.handleCandlesCSV(w, r)
+		return
+	}
+	q, err := parseCandleQuery(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	c, partial, err := s.candles(r.Context(), q)
+	if err != nil {
+		badGateway(w, r, err)
+		return
+	}
+	resp := map[string]any{
+		"symbol":     q.symbol,
+		"resolution": q.resolution,
+		"from":       q.from.Unix(),
+		"to":         q.to.Unix(),
+		"status":     c.S,
+		"style":      q.style,
+	}
+	if q.aggregate != "" {
+		resp["aggregate"] = q.aggregate
+		resp["partial"] = partial
+	}
+	if c.S != "ok" || len(c.Time) == 0 {
+		resp["candles"] = []any{}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	resp["t"] = c.Time
+	resp["o"] = c.Open
+	resp["h"] = c.High
+	resp["l"] = c.Low
+	resp["c"] = c.Close
+	resp["v"] = c.Volume
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func main() {
+	slog.SetDefault(newLogger(slog.LevelInfo))
+	c, err := loadConfig()
+	if err != nil {
+		fatal("config", "err", err)
+	}
+
+	// Flags override the environment
+	flag.StringVar(&c.ServerAddr, "addr", c.ServerAddr, "listen address")
+	flag.DurationVar(&c.PollInterval, "poll", c.PollInterval, "live quote poll interval")
+	flag.StringVar(&c.StaticDir, "static", c.StaticDir, "directory of frontend assets")
+	flag.DurationVar(&c.MinPollInterval, "poll-min", c.MinPollInterval, "shortest per-connection poll interval")
+	flag.DurationVar(&c.MaxPollInterval, "poll-max", c.MaxPollInterval, "longest per-connection poll interval")
+	flag.IntVar(&c.FinnhubRate, "finnhub-rate", c.FinnhubRate, "most Finnhub REST calls per minute (0 for no limit)")
+	flag.IntVar(&c.FinnhubRetries, "finnhub-retries", c.FinnhubRetries, "retries of a Finnhub call after a network error, 5xx or 429")
+	flag.BoolVar(&c.Stream, "stream", c.Stream, "use Finnhub's trade WebSocket, polling only as a fallback")
+	flag.IntVar(&c.ReadBufferSize, "ws-read-buffer", c.ReadBufferSize, "WebSocket read buffer size in bytes")
+	flag.IntVar(&c.WriteBufferSize, "ws-write-buffer", c.WriteBufferSize, "WebSocket write buffer size in bytes")
+	flag.DurationVar(&c.HandshakeTimeout, "ws-handshake-timeout", c.HandshakeTimeout, "WebSocket upgrade timeout")
+	flag.DurationVar(&c.WriteWait, "ws-write-wait", c.WriteWait, "deadline for each WebSocket write")
+	flag.StringVar(&c.DBPath, "db", c.DBPath, "SQLite file to record quote history in (off if empty)")
+	flag.StringVar(&c.WatchlistPath, "watchlist", c.WatchlistPath, "JSON file to keep the watchlist in (off if empty)")
+	flag.Int64Var(&c.MaxMessageSize, "ws-max-message", c.MaxMessageSize, "largest inbound WebSocket message in bytes")
+	flag.TextVar(&c.LogLevel, "log-level", c.LogLevel, "least severe level logged: debug, info, warn or error")
+	flag.Parse()
+
+	if err := c.validate(); err != nil {
+		fatal("config", "err", err)
+	}
+	cfg = c
+	slog.SetDefault(newLogger(cfg.LogLevel))
+	upgrader.EnableCompression = cfg.Compression
+	upgrader.ReadBufferSize = cfg.ReadBufferSize
+	upgrader.WriteBufferSize = cfg.WriteBufferSize
+	upgrader.HandshakeTimeout = cfg.HandshakeTimeout
+	slog.Info("config",
+		"addr", cfg.ServerAddr,
+		"poll", cfg.PollInterval.String(),
+		"poll_min", cfg.MinPollInterval.String(),
+		"poll_max", cfg.MaxPollInterval.String(),
+		"stream", cfg.Stream,
+		"static", cfg.StaticDir,
+		"auth", len(cfg.AuthTokens) > 0,
+		"log_level", cfg.LogLevel.String())
+
+	finnhub := NewFinnhubProvider(cfg.APIKey)
+	if cfg.FinnhubRate > 0 {
+		finnhub.limiter = newTokenBucket(cfg.FinnhubRate, finnhubBurst)
+	}
+	finnhub.retries, finnhub.maxBackoff = cfg.FinnhubRetries, cfg.FinnhubMaxBackoff
+	var provider Provider = newFlightProvider(finnhub)
+	if cfg.QuoteCacheTTL > 0 {
+		provider = newCachedProvider(provider, cfg.QuoteCacheTTL)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := &server{
+		provider: provider,
+		hub:      newHub(provider.Quote),
+		searches: newTTLCache[[]SymbolMatch](searchCacheTTL, lookupCacheSize),
+		profiles: newTTLCache[*Profile](profileCacheTTL, lookupCacheSize),
+		news:     newTTLCache[[]NewsItem](newsCacheTTL, lookupCacheSize),
+		statuses: newTTLCache[*ExchangeStatus](marketStatusCacheTTL, lookupCacheSize),
+		metrics:  newTTLCache[*Metrics](keyMetricsCacheTTL, lookupCacheSize),
+		done:     make(chan struct{}),
+	}
+	s.hub.limit = cfg.MaxSymbols
+	s.market = newMarketWatcher(time.Now, func(st marketStatus) {
+		slog.Info("market session changed", "exchange", st.Exchange, "session", st.Session)
+		s.conns.broadcast(st.msg())
+	})
+	go s.market.run(ctx, marketCheckPeriod)
+	if cfg.DBPath != "" {
+		if s.history, err = openQuoteStore(cfg.DBPath); err != nil {
+			fatal("history", "err", err)
+		}
+		s.hub.record = s.history.record
+		go s.history.run(ctx)
+	}
+	if cfg.WatchlistPath != "" {
+		if s.watchlist, err = openWatchlist(cfg.WatchlistPath); err != nil {
+			fatal("watchlist", "err", err)
+		}
+	}
+	s.inbox = newAlertInbox()
+	s.alerts = newAlertEngine(s.hub, func(ev alertEvent) {
+		s.inbox.deliver(ev)
+		if ev.alert.WebhookURL != "" {
+			go postWebhook(ev)
+		}
+	})
+	go s.alerts.run(ctx)
+	if cfg.Stream {
+		s.stream = newFinnhubStream(cfg.APIKey, s.hub.Trade)
+		s.hub.stream = s.stream
+		go s.stream.run(ctx)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleStatic)
+	// Probes stay open even with AUTH_TOKENS set
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("/api/quote", requireToken(s.handleQuote))
+	mux.HandleFunc("/api/quotes", requireToken(s.handleQuotes))
+	mux.HandleFunc("GET /api/change", requireToken(s.handleChange))
+	mux.HandleFunc("POST /api/portfolio", requireToken(s.handlePortfolio))
+	mux.HandleFunc("GET /api/search", requireToken(s.handleSearch))
+	mux.HandleFunc("GET /api/profile", requireToken(s.handleProfile))
+	mux.HandleFunc("GET /api/metrics", requireToken(s.handleKeyMetrics))
+	mux.HandleFunc("GET /api/news", requireToken(s.handleNews))
+	mux.HandleFunc("GET /api/market-status", requireToken(s.handleMarketStatus))
+	mux.HandleFunc("GET /api/vwap", requireToken(s.handleVWAP))
+	mux.HandleFunc("/api/candles", requireToken(s.handleCandles))
+	mux.HandleFunc("/api/candles.csv", requireToken(s.handleCandlesCSV))
+	mux.HandleFunc("GET /api/indicators/{name}", requireToken(s.handleIndicator))
+	mux.HandleFunc("GET /api/patterns", requireToken(s.handlePatterns))
+	mux.HandleFunc("POST /api/alerts", requireToken(s.handleCreateAlert))
+	mux.HandleFunc("GET /api/alerts", requireToken(s.handleListAlerts))
+	mux.HandleFunc("DELETE /api/alerts/{id}", requireToken(s.handleDeleteAlert))
+	mux.HandleFunc("GET /api/history", requireToken(s.handleHistory))
+	mux.HandleFunc("GET /api/watchlist", requireToken(s.handleWatchlist))
+	mux.HandleFunc("POST /api/watchlist", requireToken(s.handleWatchlist))
+	mux.HandleFunc("DELETE /api/watchlist", requireToken(s.handleWatchlist))
+	mux.HandleFunc("/ws", requireToken(s.handleWS))
+	mux.HandleFunc("GET /events", requireToken(s.handleEvents))
+	mux.HandleFunc("GET /sse", requireToken(s.handleEvents))
+	mux.HandleFunc("GET /api/poll", requireToken(s.handlePoll))
+	mux.HandleFunc("GET /api/ws/stats", requireToken(s.handleWSStats))
+
+	// Live counters, e.g. the open WebSocket count, as JSON
+	expvar.Publish("ws_connections", expvar.Func(func() any { return s.conns.count() }))
+	mux.HandleFunc("/debug/vars", requireToken(expvar.Handler().ServeHTTP))
+	mux.HandleFunc("GET /metrics", requireToken(promhttp.Handler().ServeHTTP))
+
+	srv := &http.Server{Addr: cfg.ServerAddr, Handler: withRequestID(instrumentHTTP(mux, withCORS(withGzip(recoverMiddleware(mux)))))}
+	// Shutdown waits for handlers to return, and /events streams never do
+	// on their own
+	srv.RegisterOnShutdown(func() { close(s.done) })
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("server running", "url", "http://localhost"+cfg.ServerAddr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		fatal("listen", "err", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process
+	slog.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("shutdown", "err", err)
+	}
+	// Hijacked WebSocket connections are not covered by Shutdown
+	s.conns.closeAll(errShuttingDown, websocket.CloseGoingAway)
+	if s.history != nil {
+		<-s.history.done // flush buffered ticks
+	}
+	slog.Info("server stopped")
+}
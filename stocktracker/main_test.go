@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMain runs the tests under the default configuration, as main would
+// with no environment or flags set, except for a short WebSocket ping
+// period so dead peers are reaped within a test. cfg is set once here
+// because connection goroutines of earlier tests may still be reading it.
+func TestMain(m *testing.M) {
+	c, err := loadConfig()
+	if err != nil {
+		panic(err)
+	}
+	c.PingPeriod = 100 * time.Millisecond
+	cfg = c
+	os.Exit(m.Run())
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fakeFinnhub is a Finnhub provider whose REST API answers every call
+// with status and body
+func fakeFinnhub(t *testing.T, status int, body string) *FinnhubProvider {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(ts.Close)
+	fh := NewFinnhubProvider("test")
+	fh.baseURL = ts.URL
+	fh.retries = 0
+	return fh
+}
+
+func TestHandleQuote(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		body     string
+		wantCode int
+		want     map[string]any
+	}{
+		{"quote", "?symbol=tsla", http.StatusOK, `{"c":110,"h":112,"l":99,"o":100,"pc":100}`, http.StatusOK,
+			map[string]any{"symbol": "TSLA", "price": 110.0, "high": 112.0, "low": 99.0, "open": 100.0, "prevClose": 100.0,
+				"change": 10.0, "changePercent": 10.0}},
+		{"no previous close", "?symbol=TSLA", http.StatusOK, `{"c":110}`, http.StatusOK,
+			map[string]any{"price": 110.0, "change": nil, "changePercent": nil}}, // nil: left out
+		{"missing symbol", "", http.StatusOK, `{}`, http.StatusBadRequest, nil},
+		{"bad symbol", "?symbol=$$$", http.StatusOK, `{}`, http.StatusBadRequest, nil},
+		{"unknown symbol", "?symbol=NOPE", http.StatusOK, `{"c":0,"h":0,"l":0,"o":0,"pc":0}`, http.StatusNotFound,
+			map[string]any{"error": "unknown_symbol"}},
+		{"upstream error", "?symbol=TSLA", http.StatusInternalServerError, ``, http.StatusBadGateway,
+			map[string]any{"error": "upstream_unavailable"}},
+		{"upstream rejects the key", "?symbol=TSLA", http.StatusUnauthorized, ``, http.StatusBadGateway, nil},
+		{"bad upstream body", "?symbol=TSLA", http.StatusOK, `{"c":`, http.StatusBadGateway, nil},
+		{"rate limited", "?symbol=TSLA", http.StatusTooManyRequests, ``, http.StatusTooManyRequests,
+			map[string]any{"error": "rate_limited"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{provider: fakeFinnhub(t, tt.status, tt.body)}
+			rec := httptest.NewRecorder()
+			before := time.Now().UnixMilli()
+			s.handleQuote(rec, httptest.NewRequest(http.MethodGet, "/api/quote"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body %q: %v", rec.Body, err)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v", k, got[k], v)
+				}
+			}
+			if rec.Code == http.StatusOK {
+				if ts, _ := got["time"].(float64); int64(ts) < before {
+					t.Errorf("time %v, want the server's current time", got["time"])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleStatic(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"index.html":   "<!doctype html>index",
+		"app.js":       "console.log(1)",
+		"css/site.css": "body{}",
+	} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(d string) { cfg.StaticDir = d }(cfg.StaticDir)
+	cfg.StaticDir = dir
+
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string // prefix; "" skips the check
+	}{
+		{"/", http.StatusOK, "<!doctype html>index"},
+		{"/app.js", http.StatusOK, "console.log(1)"},
+		{"/css/site.css", http.StatusOK, "body{}"},
+		{"/chart/AAPL", http.StatusOK, "<!doctype html>index"}, // deep client-side route
+		{"/watchlist", http.StatusOK, "<!doctype html>index"},
+		{"/missing.js", http.StatusNotFound, ""},
+		{"/css/missing.css", http.StatusNotFound, ""},
+		{"/api/nope", http.StatusNotFound, ""},
+		{"/api", http.StatusNotFound, ""},
+		{"/ws/nope", http.StatusNotFound, ""},
+		{"/../main.go", http.StatusNotFound, ""}, // stays inside the static dir
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleStatic(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d", tt.path, rec.Code, tt.wantCode)
+			continue
+		}
+		if !strings.HasPrefix(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: body %q, want %q", tt.path, rec.Body, tt.wantBody)
+		}
+	}
+}
+
+func TestHandleQuotes(t *testing.T) {
+	many := make([]string, 51)
+	for i := range many {
+		many[i] = fmt.Sprintf("S%d", i)
+	}
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		want     map[string]bool // symbol -> quoted (true) or error (false)
+	}{
+		{"all quoted", "?symbols=AAPL,MSFT", http.StatusOK, map[string]bool{"AAPL": true, "MSFT": true}},
+		{"partial failure", "?symbols=AAPL,FAIL,MSFT", http.StatusOK, map[string]bool{"AAPL": true, "FAIL": false, "MSFT": true}},
+		{"duplicates", "?symbols=aapl,AAPL,%20aapl", http.StatusOK, map[string]bool{"AAPL": true}},
+		{"at the cap", "?symbols=" + strings.Join(many[:50], ","), http.StatusOK, nil},
+		{"over the cap", "?symbols=" + strings.Join(many, ","), http.StatusBadRequest, nil},
+		{"duplicates count once", "?symbols=" + strings.Join(many[:50], ",") + ",S0", http.StatusOK, nil},
+		{"missing", "", http.StatusBadRequest, nil},
+		{"blank", "?symbols=,,", http.StatusBadRequest, nil},
+		{"bad symbol", "?symbols=AAPL,../x", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			s := &server{provider: &stubProvider{quote: func(ctx context.Context, symbol string) (*Quote, error) {
+				calls.Add(1)
+				if symbol == "FAIL" {
+					return nil, errors.New("upstream down")
+				}
+				return &Quote{Current: 100, PrevClose: 100}, nil
+			}}}
+			rec := httptest.NewRecorder()
+			s.handleQuotes(rec, httptest.NewRequest(http.MethodGet, "/api/quotes"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				if n := calls.Load(); n != 0 {
+					t.Errorf("%d upstream calls for a refused request", n)
+				}
+				return
+			}
+			var got map[string]map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if int(calls.Load()) != len(got) {
+				t.Errorf("%d upstream calls for %d symbols", calls.Load(), len(got))
+			}
+			if tt.want == nil {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got %d symbols, want %d: %v", len(got), len(tt.want), got)
+			}
+			for sym, quoted := range tt.want {
+				v, ok := got[sym]
+				switch {
+				case !ok:
+					t.Errorf("%s missing", sym)
+				case quoted && v["price"] != 100.0:
+					t.Errorf("%s = %v, want a quote", sym, v)
+				case !quoted && v["error"] != "quote_unavailable":
+					t.Errorf("%s = %v, want an error", sym, v)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleQuotesConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	s := &server{provider: &stubProvider{quote: func(ctx context.Context, symbol string) (*Quote, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return &Quote{Current: 1}, nil
+	}}}
+	syms := make([]string, 3*batchConcurrency)
+	for i := range syms {
+		syms[i] = fmt.Sprintf("S%d", i)
+	}
+	rec := httptest.NewRecorder()
+	s.handleQuotes(rec, httptest.NewRequest(http.MethodGet, "/api/quotes?symbols="+strings.Join(syms, ","), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if p := peak.Load(); p != batchConcurrency {
+		t.Errorf("peak of %d fetches in flight, want %d", p, batchConcurrency)
+	}
+}
+
+func TestHandleQuotesCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	s := &server{provider: &stubProvider{quote: func(qctx context.Context, symbol string) (*Quote, error) {
+		if calls.Add(1) == 1 {
+			cancel() // the client goes away during the first fetch
+		}
+		<-qctx.Done()
+		return nil, qctx.Err()
+	}}}
+	syms := make([]string, 3*batchConcurrency)
+	for i := range syms {
+		syms[i] = fmt.Sprintf("S%d", i)
+	}
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/quotes?symbols="+strings.Join(syms, ","), nil)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.handleQuotes(rec, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still fetching after the client left")
+	}
+	if n := calls.Load(); n > batchConcurrency {
+		t.Errorf("%d fetches started after cancellation, want at most the %d already in flight", n, batchConcurrency)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("answered an abandoned request: %s", rec.Body)
+	}
+}
+
+func TestCandleQueryMinutes(t *testing.T) {
+	tests := []struct {
+		minutes string // "-" leaves the param out
+		want    time.Duration
+		wantErr bool
+	}{
+		{"-", 60 * time.Minute, false},
+		{"1", time.Minute, false},
+		{"120", 120 * time.Minute, false},
+		{"5000", 5000 * time.Minute, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"5001", 0, true},
+		{"99999999999999999999", 0, true},
+		{"abc", 0, true},
+		{"1.5", 0, true},
+		{"60m", 0, true},
+	}
+	for _, tt := range tests {
+		target := "/api/candles?symbol=AAPL"
+		if tt.minutes != "-" {
+			target += "&minutes=" + tt.minutes
+		}
+		q, err := parseCandleQuery(httptest.NewRequest(http.MethodGet, target, nil))
+		if tt.wantErr {
+			if err == nil || err.Error() != "minutes must be an integer between 1 and 5000" {
+				t.Errorf("minutes=%s: error %v, want the range error", tt.minutes, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("minutes=%s: %v", tt.minutes, err)
+			continue
+		}
+		if got := q.to.Sub(q.from); got != tt.want {
+			t.Errorf("minutes=%s: window %v, want %v", tt.minutes, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCandlesBadMinutes(t *testing.T) {
+	var calls atomic.Int32
+	s := &server{provider: &stubProvider{candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+		calls.Add(1)
+		return &Candles{S: "no_data"}, nil
+	}}}
+	for _, minutes := range []string{"0", "-1", "5001", "abc"} {
+		rec := httptest.NewRecorder()
+		s.handleCandles(rec, httptest.NewRequest(http.MethodGet, "/api/candles?symbol=AAPL&minutes="+minutes, nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "between 1 and 5000") {
+			t.Errorf("minutes=%s: %d %s, want 400", minutes, rec.Code, rec.Body)
+		}
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("%d upstream calls for refused requests", n)
+	}
+}
+
+func TestHandleCandlesResolution(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantCode   int
+		resolution string        // passed upstream and echoed back
+		window     time.Duration // from..to asked of the provider
+	}{
+		{"", http.StatusOK, "1", time.Hour},
+		{"&resolution=1", http.StatusOK, "1", time.Hour},
+		{"&resolution=5", http.StatusOK, "5", 8 * time.Hour},
+		{"&resolution=15", http.StatusOK, "15", 2 * day},
+		{"&resolution=30", http.StatusOK, "30", 5 * day},
+		{"&resolution=60", http.StatusOK, "60", 10 * day},
+		{"&resolution=D", http.StatusOK, "D", 182 * day},
+		{"&resolution=W", http.StatusOK, "W", 2 * 365 * day},
+		{"&resolution=M", http.StatusOK, "M", 5 * 365 * day},
+		{"&resolution=D&minutes=120", http.StatusOK, "D", 120 * time.Minute},
+		{"&resolution=d", http.StatusBadRequest, "", 0},
+		{"&resolution=2", http.StatusBadRequest, "", 0},
+		{"&resolution=1D", http.StatusBadRequest, "", 0},
+		{"&resolution=", http.StatusOK, "1", time.Hour},
+	}
+	for _, tt := range tests {
+		var gotRes string
+		var gotWindow time.Duration
+		s := &server{provider: &stubProvider{candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+			gotRes, gotWindow = resolution, to.Sub(from)
+			return &Candles{S: "no_data"}, nil
+		}}}
+		rec := httptest.NewRecorder()
+		s.handleCandles(rec, httptest.NewRequest(http.MethodGet, "/api/candles?symbol=AAPL"+tt.query, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%q: status %d, want %d: %s", tt.query, rec.Code, tt.wantCode, rec.Body)
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			if gotRes != "" {
+				t.Errorf("%q: fetched candles for a refused request", tt.query)
+			}
+			continue
+		}
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if gotRes != tt.resolution || body["resolution"] != tt.resolution {
+			t.Errorf("%q: fetched resolution %q, answered %v, want %q", tt.query, gotRes, body["resolution"], tt.resolution)
+		}
+		if gotWindow != tt.window {
+			t.Errorf("%q: window %v, want %v", tt.query, gotWindow, tt.window)
+		}
+	}
+}
+
+func TestParseAggregate(t *testing.T) {
+	tests := []struct {
+		in         string
+		period     time.Duration
+		resolution string
+		wantErr    bool
+	}{
+		{"2m", 2 * time.Minute, "1", false},
+		{"10m", 10 * time.Minute, "5", false},
+		{"45m", 45 * time.Minute, "15", false},
+		{"90m", 90 * time.Minute, "30", false},
+		{"4h", 4 * time.Hour, "60", false},
+		{"24h", 24 * time.Hour, "60", false},
+		{"7m", 0, "", true}, // doesn't divide a day
+		{"5h", 0, "", true},
+		{"48h", 0, "", true},
+		{"0m", 0, "", true},
+		{"2", 0, "", true},
+		{"2d", 0, "", true},
+		{"2M", 0, "", true},
+		{"-2m", 0, "", true},
+	}
+	for _, tt := range tests {
+		period, res, err := parseAggregate(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAggregate(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if period != tt.period || res != tt.resolution {
+			t.Errorf("parseAggregate(%q) = %v, %q; want %v, %q", tt.in, period, res, tt.period, tt.resolution)
+		}
+	}
+}
+
+func TestHandleCandlesAggregate(t *testing.T) {
+	tests := []struct {
+		query       string
+		wantCode    int
+		resolution  string // fetched
+		period      int64  // seconds
+		wantBuckets int
+	}{
+		{"&aggregate=4h&from=2024-05-28T00:00:00Z&to=2024-05-29T00:00:00Z", http.StatusOK, "60", 4 * 3600, 6},
+		{"&aggregate=10m&from=2024-05-28T13:00:00Z&to=2024-05-28T14:00:00Z", http.StatusOK, "5", 600, 6},
+		{"&aggregate=2m&from=2024-05-28T13:00:00Z&to=2024-05-28T13:10:00Z", http.StatusOK, "1", 120, 5},
+		{"&aggregate=7m", http.StatusBadRequest, "", 0, 0},
+		{"&aggregate=2m&resolution=1", http.StatusBadRequest, "", 0, 0},
+	}
+	for _, tt := range tests {
+		var fetched string
+		s := &server{provider: &stubProvider{candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+			fetched = resolution
+			n, _ := strconv.Atoi(resolution)
+			c := &Candles{S: "ok"}
+			for ts := from; ts.Before(to); ts = ts.Add(time.Duration(n) * time.Minute) {
+				c.Time = append(c.Time, ts.Unix())
+				c.Open = append(c.Open, 1)
+				c.High = append(c.High, 1)
+				c.Low = append(c.Low, 1)
+				c.Close = append(c.Close, 1)
+				c.Volume = append(c.Volume, 1)
+			}
+			return c, nil
+		}}}
+		rec := httptest.NewRecorder()
+		s.handleCandles(rec, httptest.NewRequest(http.MethodGet, "/api/candles?symbol=AAPL"+tt.query, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.query, rec.Code, tt.wantCode, rec.Body)
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Resolution string  `json:"resolution"`
+			Aggregate  string  `json:"aggregate"`
+			Partial    *bool   `json:"partial"`
+			T          []int64 `json:"t"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if fetched != tt.resolution || body.Resolution != tt.resolution {
+			t.Errorf("%s: fetched %q, answered %q, want %q", tt.query, fetched, body.Resolution, tt.resolution)
+		}
+		if body.Aggregate == "" || body.Partial == nil {
+			t.Errorf("%s: aggregate %q, partial %v; want both set", tt.query, body.Aggregate, body.Partial)
+		}
+		if len(body.T) != tt.wantBuckets {
+			t.Errorf("%s: %d buckets, want %d", tt.query, len(body.T), tt.wantBuckets)
+		}
+		for _, ts := range body.T {
+			if ts%tt.period != 0 {
+				t.Errorf("%s: bucket at %d isn't on a boundary", tt.query, ts)
+			}
+		}
+	}
+}
+
+func TestHandleCandlesFill(t *testing.T) {
+	const t0 = 1717000200
+	tests := []struct {
+		query    string
+		wantCode int
+		wantT    []int64
+	}{
+		{"&resolution=5&minutes=60", http.StatusOK, []int64{t0, t0 + 900}},
+		{"&resolution=5&minutes=60&fill=false", http.StatusOK, []int64{t0, t0 + 900}},
+		{"&resolution=5&minutes=60&fill=true", http.StatusOK, []int64{t0, t0 + 300, t0 + 600, t0 + 900}},
+		{"&aggregate=10m&minutes=60&fill=true", http.StatusOK, []int64{t0, t0 + 600}},
+		{"&resolution=D&fill=true", http.StatusBadRequest, nil},
+		{"&resolution=5&fill=yes", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		s := &server{provider: &stubProvider{candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+			return &Candles{
+				S: "ok", Time: []int64{t0, t0 + 900},
+				Open: []float64{1, 2}, High: []float64{1, 2}, Low: []float64{1, 2}, Close: []float64{1, 2}, Volume: []float64{5, 5},
+			}, nil
+		}}}
+		rec := httptest.NewRecorder()
+		s.handleCandles(rec, httptest.NewRequest(http.MethodGet, "/api/candles?symbol=AAPL"+tt.query, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.query, rec.Code, tt.wantCode, rec.Body)
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		var body struct {
+			T []int64 `json:"t"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if !slices.Equal(body.T, tt.wantT) {
+			t.Errorf("%s: t = %v, want %v", tt.query, body.T, tt.wantT)
+		}
+	}
+}
+
+func TestHandleCandlesStyle(t *testing.T) {
+	s := &server{provider: &stubProvider{candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+		return &Candles{
+			S: "ok", Time: []int64{1717000200, 1717000260},
+			Open: []float64{10, 11}, High: []float64{12, 13}, Low: []float64{9, 10}, Close: []float64{11, 12}, Volume: []float64{100, 200},
+		}, nil
+	}}}
+	tests := []struct {
+		query    string
+		wantCode int
+		style    string
+		open     []float64
+		close    []float64
+	}{
+		{"", http.StatusOK, styleCandles, []float64{10, 11}, []float64{11, 12}},
+		{"&style=candles", http.StatusOK, styleCandles, []float64{10, 11}, []float64{11, 12}},
+		{"&style=heikin-ashi", http.StatusOK, styleHeikinAshi, []float64{10.5, 10.5}, []float64{10.5, 11.5}},
+		{"&style=renko", http.StatusBadRequest, "", nil, nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handleCandles(rec, httptest.NewRequest(http.MethodGet, "/api/candles?symbol=AAPL"+tt.query, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%q: status %d, want %d", tt.query, rec.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Style string    `json:"style"`
+			O     []float64 `json:"o"`
+			C     []float64 `json:"c"`
+			V     []float64 `json:"v"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if body.Style != tt.style || !slices.Equal(body.O, tt.open) || !slices.Equal(body.C, tt.close) || !slices.Equal(body.V, []float64{100, 200}) {
+			t.Errorf("%q: style %q, o %v, c %v, v %v; want %q, %v, %v and volume kept", tt.query, body.Style, body.O, body.C, body.V, tt.style, tt.open, tt.close)
+		}
+	}
+}
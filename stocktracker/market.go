@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // the exchange clock must work without system zoneinfo
+)
+
+// US equities trade 9:30–16:00 New York time on weekdays, with extended
+// sessions from 4:00 and until 20:00.
+var exchangeTZ, _ = time.LoadLocation("America/New_York")
+
+const (
+	preMarketOpensAt  = 4 * time.Hour
+	marketOpensAt     = 9*time.Hour + 30*time.Minute
+	marketClosesAt    = 16 * time.Hour
+	afterHoursCloseAt = 20 * time.Hour
+)
+
+// Sessions reported by marketSession
+const (
+	sessionPreMarket  = "pre-market"
+	sessionRegular    = "regular"
+	sessionAfterHours = "after-hours"
+	sessionClosed     = "closed"
+)
+
+// marketSession names the trading session t falls in. Exchange holidays
+// and half days are not accounted for.
+func marketSession(t time.Time) string {
+	t = t.In(exchangeTZ)
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return sessionClosed
+	}
+	y, m, d := t.Date()
+	sinceMidnight := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, exchangeTZ))
+	switch {
+	case sinceMidnight < preMarketOpensAt:
+		return sessionClosed
+	case sinceMidnight < marketOpensAt:
+		return sessionPreMarket
+	case sinceMidnight < marketClosesAt:
+		return sessionRegular
+	case sinceMidnight < afterHoursCloseAt:
+		return sessionAfterHours
+	}
+	return sessionClosed
+}
+
+// marketOpen reports whether t falls within regular trading hours.
+func marketOpen(t time.Time) bool {
+	return marketSession(t) == sessionRegular
+}
+
+// ---------------- Status Watcher ----------------
+
+type marketStatus struct {
+	Exchange string
+	Open     bool
+	Session  string
+}
+
+func marketStatusAt(t time.Time) marketStatus {
+	session := marketSession(t)
+	return marketStatus{Exchange: "US", Open: session == sessionRegular, Session: session}
+}
+
+func (st marketStatus) msg() map[string]any {
+	return map[string]any{
+		"type":     "market_status",
+		"exchange": st.Exchange,
+		"open":     st.Open,
+		"session":  st.Session,
+	}
+}
+
+// marketWatcher re-checks the market session on its own slow ticker and
+// calls onChange once for every transition. The clock is injectable.
+type marketWatcher struct {
+	now      func() time.Time
+	onChange func(marketStatus)
+
+	mu  sync.Mutex
+	cur marketStatus
+}
+
+func newMarketWatcher(now func() time.Time, onChange func(marketStatus)) *marketWatcher {
+	return &marketWatcher{now: now, onChange: onChange, cur: marketStatusAt(now())}
+}
+
+// status is the session as of the last check
+func (m *marketWatcher) status() marketStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cur
+}
+
+// check updates the status, reporting a change to onChange
+func (m *marketWatcher) check() {
+	st := marketStatusAt(m.now())
+	m.mu.Lock()
+	changed := st != m.cur
+	m.cur = st
+	m.mu.Unlock()
+	if changed {
+		m.onChange(st)
+	}
+}
+
+func (m *marketWatcher) run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+// ---------------- HTTP Handler ----------------
+
+// How long an exchange's status from Finnhub is reused
+const marketStatusCacheTTL = time.Minute
+
+var exchangePattern = regexp.MustCompile(`^[A-Z]{1,4}$`)
+
+// GET /api/market-status?exchange=US
+// Whether the exchange (default US) is trading, as Finnhub reports it, so
+// charts can mark prices stale outside trading hours. Unlike the /ws
+// market_status push, this knows about holidays. t is UNIX seconds:
+//
+//	{"exchange":"US","isOpen":false,"session":"pre-market","holiday":"",
+//	 "timezone":"America/New_York","t":1717059600}
+//
+// Cached for a minute per exchange.
+func (s *server) handleMarketStatus(w http.ResponseWriter, r *http.Request) {
+	exchange := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("exchange")))
+	if exchange == "" {
+		exchange = "US"
+	}
+	if !exchangePattern.MatchString(exchange) {
+		badRequest(w, "exchange must be a code like US or L")
+		return
+	}
+	st, ok := s.statuses.get(exchange)
+	if !ok {
+		var err error
+		if st, err = s.provider.MarketStatus(r.Context(), exchange); err != nil {
+			badGateway(w, r, err)
+			return
+		}
+		s.statuses.put(exchange, st)
+	}
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// ny parses a New York wall-clock time
+func ny(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, exchangeTZ)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestMarketOpen(t *testing.T) {
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{ny("2024-06-03 09:29"), false}, // Monday, before the bell
+		{ny("2024-06-03 09:30"), true},
+		{ny("2024-06-03 15:59"), true},
+		{ny("2024-06-03 16:00"), false},
+		{ny("2024-06-08 12:00"), false},                       // Saturday
+		{ny("2024-06-09 12:00"), false},                       // Sunday
+		{ny("2024-01-08 10:00"), true},                        // winter time
+		{time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC), true},  // 10:00 in New York
+		{time.Date(2024, 6, 3, 21, 0, 0, 0, time.UTC), false}, // 17:00 in New York
+	}
+	for _, tt := range tests {
+		if got := marketOpen(tt.at); got != tt.want {
+			t.Errorf("marketOpen(%v) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestMarketSession(t *testing.T) {
+	tests := []struct {
+		at   string
+		want string
+	}{
+		{"2024-06-03 03:59", sessionClosed},
+		{"2024-06-03 04:00", sessionPreMarket},
+		{"2024-06-03 09:29", sessionPreMarket},
+		{"2024-06-03 09:30", sessionRegular},
+		{"2024-06-03 16:00", sessionAfterHours},
+		{"2024-06-03 19:59", sessionAfterHours},
+		{"2024-06-03 20:00", sessionClosed},
+		{"2024-06-08 10:00", sessionClosed}, // Saturday
+	}
+	for _, tt := range tests {
+		if got := marketSession(ny(tt.at)); got != tt.want {
+			t.Errorf("marketSession(%s) = %s, want %s", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestMarketWatcherTransitions(t *testing.T) {
+	now := ny("2024-06-03 09:00")
+	var changes []marketStatus
+	m := newMarketWatcher(func() time.Time { return now }, func(st marketStatus) {
+		changes = append(changes, st)
+	})
+	if st := m.status(); st.Session != sessionPreMarket || st.Open || st.Exchange != "US" {
+		t.Fatalf("initial status %+v", st)
+	}
+
+	// Checks every 10 minutes across the open, the close and the evening
+	for now.Before(ny("2024-06-03 21:00")) {
+		now = now.Add(10 * time.Minute)
+		m.check()
+	}
+	want := []marketStatus{
+		{Exchange: "US", Open: true, Session: sessionRegular},
+		{Exchange: "US", Open: false, Session: sessionAfterHours},
+		{Exchange: "US", Open: false, Session: sessionClosed},
+	}
+	if !slices.Equal(changes, want) {
+		t.Errorf("transitions %+v, want %+v", changes, want)
+	}
+	if st := m.status(); st != want[2] {
+		t.Errorf("status %+v after the evening, want %+v", st, want[2])
+	}
+}
+
+func TestMarketStatusMsg(t *testing.T) {
+	got := marketStatusAt(ny("2024-06-03 17:00")).msg()
+	want := map[string]any{"type": "market_status", "exchange": "US", "open": false, "session": sessionAfterHours}
+	if !maps.Equal(got, want) {
+		t.Errorf("msg = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics exported at /metrics, alongside the Go runtime and process
+// collectors client_golang registers by default.
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route and status.",
+	}, []string{"path", "status"})
+	httpLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP handler latency by route, excluding WebSocket connections.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path"})
+	finnhubCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finnhub_requests_total",
+		Help: "Finnhub REST calls by endpoint and outcome (ok, rate_limited, error, or throttled locally).",
+	}, []string{"endpoint", "outcome"})
+	finnhubLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "finnhub_request_duration_seconds",
+		Help:    "Finnhub REST call latency by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	wsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_connections_active",
+		Help: "Open WebSocket connections.",
+	})
+)
+
+// instrumentHTTP counts and times requests by the mux route they match,
+// so path parameters don't explode the number of series.
+func instrumentHTTP(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		httpRequests.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+		if rec.status != http.StatusSwitchingProtocols {
+			httpLatency.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		}
+	})
+}
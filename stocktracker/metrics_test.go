@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestScrapeMetrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux.Handle("GET /metrics", promhttp.Handler())
+	srv := httptest.NewServer(instrumentHTTP(mux, mux))
+	defer srv.Close()
+
+	for _, id := range []string{"1", "2"} {
+		resp, err := http.Get(srv.URL + "/api/things/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := fakeFinnhub(t, http.StatusOK, `{"c":1}`).Quote(context.Background(), "AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	wsActive.Inc()
+	defer wsActive.Dec()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	out := string(body)
+	for _, line := range []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{path="GET /api/things/{id}",status="418"} 2`,
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_bucket{path="GET /api/things/{id}",le="+Inf"} 2`,
+		`http_request_duration_seconds_count{path="GET /api/things/{id}"} 2`,
+		`http_request_duration_seconds_sum{path="GET /api/things/{id}"} `,
+		"# TYPE finnhub_requests_total counter",
+		`finnhub_requests_total{endpoint="/quote",outcome="ok"} `,
+		"# TYPE finnhub_request_duration_seconds histogram",
+		`finnhub_request_duration_seconds_count{endpoint="/quote"} `,
+		"# TYPE ws_connections_active gauge",
+		"ws_connections_active 1",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("scrape lacks %q:\n%s", line, out)
+		}
+	}
+}
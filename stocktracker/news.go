@@ -0,0 +1,108 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// How long a news lookup is reused
+	newsCacheTTL = 5 * time.Minute
+
+	// Days of news /api/news covers when from is omitted
+	defaultNewsDays = 7
+
+	// Longest span one /api/news request may cover
+	maxNewsSpan = 366 * 24 * time.Hour
+)
+
+// newsMsg is a NewsItem as /api/news sends it, with its time in ISO-8601
+type newsMsg struct {
+	NewsItem
+	Time string `json:"time"`
+}
+
+// GET /api/news?symbol=TSLA&from=2024-05-01&to=2024-05-07
+// GET /api/news?symbol=TSLA&days=7
+// Company news from Finnhub, newest first, at most NEWS_LIMIT articles;
+// datetime is UNIX seconds and time the same instant in RFC 3339 UTC:
+//
+//	[{"datetime":1714990000,"headline":"...","source":"Reuters",
+//	  "summary":"...","url":"https://...","image":"https://...",
+//	  "time":"2024-05-06T10:06:40Z"}]
+//
+// from and to are UTC dates, both inclusive. to defaults to today and from
+// to 7 days before to; the span may not exceed a year. days (1-366) is
+// shorthand for the days before to and can't be combined with from.
+// Results are cached for five minutes per symbol and range.
+func (s *server) handleNews(w http.ResponseWriter, r *http.Request) {
+	symbol, err := querySymbol(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	from, to, err := parseNewsRange(q.Get("from"), q.Get("to"), q.Get("days"), time.Now())
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	key := symbol + "|" + from.Format(time.DateOnly) + "|" + to.Format(time.DateOnly)
+	items, ok := s.news.get(key)
+	if !ok {
+		if items, err = s.provider.News(r.Context(), symbol, from, to); err != nil {
+			badGateway(w, r, err)
+			return
+		}
+		// Sorted once, before caching, so cached slices are never written
+		items = slices.Clone(items)
+		slices.SortStableFunc(items, func(a, b NewsItem) int { return cmp.Compare(b.Datetime, a.Datetime) })
+		s.news.put(key, items)
+	}
+	items = items[:min(len(items), cfg.NewsLimit)]
+	out := make([]newsMsg, len(items))
+	for i, it := range items {
+		out[i] = newsMsg{NewsItem: it, Time: time.Unix(it.Datetime, 0).UTC().Format(time.RFC3339)}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// parseNewsRange reads /api/news's from, to and days, defaulting to the
+// defaultNewsDays before today.
+func parseNewsRange(fromStr, toStr, daysStr string, now time.Time) (from, to time.Time, err error) {
+	to = now.UTC().Truncate(24 * time.Hour)
+	if toStr != "" {
+		if to, err = time.Parse(time.DateOnly, toStr); err != nil {
+			return from, to, errors.New("to must be a date like 2024-05-07")
+		}
+	}
+	days := defaultNewsDays
+	if daysStr != "" {
+		if fromStr != "" {
+			return from, to, errors.New("days can't be combined with from")
+		}
+		maxDays := int(maxNewsSpan.Hours() / 24)
+		if days, err = strconv.Atoi(daysStr); err != nil || days < 1 || days > maxDays {
+			return from, to, fmt.Errorf("days must be an integer between 1 and %d", maxDays)
+		}
+	}
+	from = to.AddDate(0, 0, -days)
+	if fromStr != "" {
+		if from, err = time.Parse(time.DateOnly, fromStr); err != nil {
+			return from, to, errors.New("from must be a date like 2024-05-01")
+		}
+	}
+	if from.After(to) {
+		return from, to, errors.New("from must not be after to")
+	}
+	if to.Sub(from) > maxNewsSpan {
+		return from, to, fmt.Errorf("from and to may be at most %d days apart", int(maxNewsSpan.Hours()/24))
+	}
+	return from, to, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseNewsRange(t *testing.T) {
+	now := time.Date(2024, 5, 7, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		from, to, days   string
+		wantFrom, wantTo string
+		wantErr          bool
+	}{
+		{"defaults", "", "", "", "2024-04-30", "2024-05-07", false},
+		{"explicit", "2024-05-01", "2024-05-03", "", "2024-05-01", "2024-05-03", false},
+		{"one day", "2024-05-03", "2024-05-03", "", "2024-05-03", "2024-05-03", false},
+		{"only to", "", "2024-05-03", "", "2024-04-26", "2024-05-03", false},
+		{"only from", "2024-05-01", "", "", "2024-05-01", "2024-05-07", false},
+		{"days", "", "", "30", "2024-04-07", "2024-05-07", false},
+		{"days before to", "", "2024-05-03", "1", "2024-05-02", "2024-05-03", false},
+		{"a year", "2023-05-07", "2024-05-07", "", "2023-05-07", "2024-05-07", false},
+		{"from after to", "2024-05-08", "2024-05-07", "", "", "", true},
+		{"over a year", "2023-05-01", "2024-05-07", "", "", "", true},
+		{"days and from", "2024-05-01", "", "3", "", "", true},
+		{"zero days", "", "", "0", "", "", true},
+		{"too many days", "", "", "367", "", "", true},
+		{"bad from", "05/01/2024", "", "", "", "", true},
+		{"bad to", "", "yesterday", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := parseNewsRange(tt.from, tt.to, tt.days, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if f, e := from.Format(time.DateOnly), to.Format(time.DateOnly); f != tt.wantFrom || e != tt.wantTo {
+				t.Errorf("range %s..%s, want %s..%s", f, e, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+// newsStub is a Finnhub /company-news answering with items out of order
+// and recording the query of each call
+type newsStub struct {
+	mu      sync.Mutex
+	queries []url.Values
+	status  int
+}
+
+func (n *newsStub) server(t *testing.T) *server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		n.queries = append(n.queries, r.URL.Query())
+		n.mu.Unlock()
+		if n.status != 0 {
+			w.WriteHeader(n.status)
+			return
+		}
+		w.Write([]byte(`[
+			{"datetime":1714900000,"headline":"middle","source":"A","summary":"s","url":"https://a","image":"","category":"company"},
+			{"datetime":1714990000,"headline":"newest","source":"B","summary":"s","url":"https://b","image":""},
+			{"datetime":1714800000,"headline":"oldest","source":"C","summary":"s","url":"https://c","image":""}
+		]`))
+	}))
+	t.Cleanup(ts.Close)
+	fh := NewFinnhubProvider("test")
+	fh.baseURL = ts.URL
+	fh.retries = 0
+	return &server{provider: fh, news: newTTLCache[[]NewsItem](newsCacheTTL, lookupCacheSize)}
+}
+
+func (n *newsStub) calls() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.queries)
+}
+
+func TestHandleNews(t *testing.T) {
+	defer func(n int) { cfg.NewsLimit = n }(cfg.NewsLimit)
+	tests := []struct {
+		name      string
+		query     string
+		limit     int
+		status    int // of Finnhub's answer
+		wantCode  int
+		wantHeads []string
+	}{
+		{"newest first", "symbol=TSLA&from=2024-05-01&to=2024-05-07", 10, 0, http.StatusOK, []string{"newest", "middle", "oldest"}},
+		{"truncated", "symbol=TSLA&from=2024-05-01&to=2024-05-07", 2, 0, http.StatusOK, []string{"newest", "middle"}},
+		{"default range", "symbol=TSLA", 10, 0, http.StatusOK, []string{"newest", "middle", "oldest"}},
+		{"missing symbol", "from=2024-05-01", 10, 0, http.StatusBadRequest, nil},
+		{"bad range", "symbol=TSLA&from=2024-05-08&to=2024-05-01", 10, 0, http.StatusBadRequest, nil},
+		{"upstream down", "symbol=TSLA", 10, http.StatusBadGateway, http.StatusBadGateway, nil},
+		{"rate limited", "symbol=TSLA", 10, http.StatusTooManyRequests, http.StatusTooManyRequests, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.NewsLimit = tt.limit
+			stub := &newsStub{status: tt.status}
+			s := stub.server(t)
+			rec := httptest.NewRecorder()
+			s.handleNews(rec, httptest.NewRequest(http.MethodGet, "/api/news?"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got []map[string]any
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			if len(got) != len(tt.wantHeads) {
+				t.Fatalf("%d articles, want %d", len(got), len(tt.wantHeads))
+			}
+			for i, h := range tt.wantHeads {
+				if got[i]["headline"] != h {
+					t.Errorf("article %d is %v, want %s", i, got[i]["headline"], h)
+				}
+			}
+			if got[0]["time"] != "2024-05-06T10:06:40Z" || got[0]["category"] != nil {
+				t.Errorf("newest article %v, want its RFC 3339 time and no extra fields", got[0])
+			}
+		})
+	}
+}
+
+func TestHandleNewsCache(t *testing.T) {
+	stub := &newsStub{}
+	s := stub.server(t)
+	get := func(query string) {
+		s.handleNews(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/news?"+query, nil))
+	}
+	tests := []struct {
+		query     string
+		wantCalls int
+	}{
+		{"symbol=TSLA&from=2024-05-01&to=2024-05-07", 1},
+		{"symbol=tsla&from=2024-05-01&to=2024-05-07", 1}, // same symbol
+		{"symbol=TSLA&days=6&to=2024-05-07", 1},          // same range
+		{"symbol=TSLA&from=2024-05-02&to=2024-05-07", 2},
+		{"symbol=AAPL&from=2024-05-01&to=2024-05-07", 3},
+	}
+	for _, tt := range tests {
+		get(tt.query)
+		if n := stub.calls(); n != tt.wantCalls {
+			t.Errorf("after %s: %d upstream calls, want %d", tt.query, n, tt.wantCalls)
+		}
+	}
+	if q := stub.queries[0]; q.Get("symbol") != "TSLA" || q.Get("from") != "2024-05-01" || q.Get("to") != "2024-05-07" {
+		t.Errorf("upstream query %v", q)
+	}
+}
+
+func TestHandleNewsDays(t *testing.T) {
+	tests := []struct {
+		query    string
+		wantCode int
+		wantFrom string // asked of Finnhub
+	}{
+		{"&to=2024-05-07", http.StatusOK, "2024-04-30"}, // 7 by default
+		{"&to=2024-05-07&days=1", http.StatusOK, "2024-05-06"},
+		{"&to=2024-05-07&days=30", http.StatusOK, "2024-04-07"},
+		{"&to=2024-05-07&days=366", http.StatusOK, "2023-05-07"},
+		{"&to=2024-05-07&days=0", http.StatusBadRequest, ""},
+		{"&to=2024-05-07&days=-7", http.StatusBadRequest, ""},
+		{"&to=2024-05-07&days=367", http.StatusBadRequest, ""},
+		{"&to=2024-05-07&days=week", http.StatusBadRequest, ""},
+		{"&to=2024-05-07&days=7&from=2024-05-01", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		stub := &newsStub{}
+		s := stub.server(t)
+		rec := httptest.NewRecorder()
+		s.handleNews(rec, httptest.NewRequest(http.MethodGet, "/api/news?symbol=TSLA"+tt.query, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.query, rec.Code, tt.wantCode, rec.Body)
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			if stub.calls() != 0 {
+				t.Errorf("%s: called Finnhub for a refused request", tt.query)
+			}
+			continue
+		}
+		if q := stub.queries[0]; q.Get("from") != tt.wantFrom || q.Get("to") != "2024-05-07" {
+			t.Errorf("%s: asked for %s..%s, want %s..2024-05-07", tt.query, q.Get("from"), q.Get("to"), tt.wantFrom)
+		}
+	}
+}
+
+func TestHandleNewsTimes(t *testing.T) {
+	stub := &newsStub{}
+	rec := httptest.NewRecorder()
+	stub.server(t).handleNews(rec, httptest.NewRequest(http.MethodGet, "/api/news?symbol=TSLA", nil))
+	var got []struct {
+		Datetime int64  `json:"datetime"`
+		Time     string `json:"time"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{
+		1714990000: "2024-05-06T10:06:40Z",
+		1714900000: "2024-05-05T09:06:40Z",
+		1714800000: "2024-05-04T05:20:00Z",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d articles, want %d", len(got), len(want))
+	}
+	for _, a := range got {
+		if a.Time != want[a.Datetime] {
+			t.Errorf("datetime %d sent as %q, want %q", a.Datetime, a.Time, want[a.Datetime])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checkOrigin is the /ws origin policy: same-origin requests and origins
+// in ALLOWED_ORIGINS pass; requests without an Origin header (non-browser
+// clients) pass only if WS_ALLOW_NO_ORIGIN is set. Gorilla answers a
+// refusal with 403.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		if !cfg.AllowNoOrigin {
+			logFrom(r.Context()).Warn("ws refused: no Origin", "remote", r.RemoteAddr)
+		}
+		return cfg.AllowNoOrigin
+	}
+	if originAllowed(origin, r.Host, cfg.AllowedOrigins) {
+		return true
+	}
+	logFrom(r.Context()).Warn("ws refused: origin not allowed", "origin", origin, "remote", r.RemoteAddr)
+	return false
+}
+
+// originAllowed reports whether origin is host itself or matches one of
+// patterns: an exact "scheme://host[:port]", "scheme://*.domain" for any
+// subdomain of domain (but not domain itself), or "*" for anything.
+func originAllowed(origin, host string, patterns []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, host) {
+		return true
+	}
+	for _, p := range patterns {
+		if p == "*" {
+			return true
+		}
+		scheme, pHost, _ := strings.Cut(p, "://")
+		if !strings.EqualFold(scheme, u.Scheme) {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(pHost, "*"); ok {
+			if len(u.Host) > len(suffix) && strings.HasSuffix(strings.ToLower(u.Host), strings.ToLower(suffix)) {
+				return true
+			}
+		} else if strings.EqualFold(pHost, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateOriginPattern checks the shape of an ALLOWED_ORIGINS entry
+func validateOriginPattern(p string) error {
+	if p == "*" {
+		return nil
+	}
+	scheme, host, ok := strings.Cut(p, "://")
+	if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+		return fmt.Errorf("allowed origin %q must look like https://host[:port]", p)
+	}
+	if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+		return fmt.Errorf("allowed origin %q: a wildcard is only allowed as the first label, as in https://*.example.com", p)
+	}
+	return nil
+}
+
+// ---------------- CORS ----------------
+
+const (
+	corsMethods = "GET, POST, DELETE, OPTIONS"
+	corsHeaders = "Authorization, Content-Type, X-Request-ID"
+	corsExpose  = "X-Request-ID, Retry-After, X-Status, Content-Disposition"
+
+	// How long browsers may cache a preflight answer, in seconds
+	corsMaxAge = "600"
+)
+
+// withCORS lets pages on the origins checkOrigin admits call the API from
+// the browser. Allowed origins are echoed back rather than answered with
+// "*", so bearer tokens keep working. Preflight requests are answered
+// here, before routing and token checks; one from an origin that isn't
+// allowed gets 403.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := originAllowed(origin, r.Host, cfg.AllowedOrigins)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			if !allowed {
+				logFrom(r.Context()).Warn("cors preflight refused", "origin", origin)
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "origin_not_allowed"})
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Methods", corsMethods)
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			h.Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if allowed {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Expose-Headers", corsExpose)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	patterns := []string{"https://app.example.org", "https://*.example.com", "http://localhost:3000"}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"http://stocks.test:8080", true}, // same origin as the Host header
+		{"HTTP://STOCKS.TEST:8080", true},
+		{"https://app.example.org", true},
+		{"https://APP.example.org", true},
+		{"http://app.example.org", false}, // scheme must match
+		{"https://app.example.org:8443", false},
+		{"https://a.example.com", true},
+		{"https://a.b.example.com", true},
+		{"https://example.com", false}, // the wildcard needs a subdomain
+		{"https://evilexample.com", false},
+		{"https://example.com.evil.net", false},
+		{"http://a.example.com", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"https://evil.net", false},
+		{"null", false},
+		{"::not a url", false},
+	}
+	for _, tt := range tests {
+		if got := originAllowed(tt.origin, "stocks.test:8080", patterns); got != tt.want {
+			t.Errorf("originAllowed(%q) = %t, want %t", tt.origin, got, tt.want)
+		}
+	}
+	if !originAllowed("https://anything.net", "stocks.test", []string{"*"}) {
+		t.Error(`"*" should allow any origin`)
+	}
+}
+
+func TestValidateOriginPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{"*", false},
+		{"https://example.com", false},
+		{"http://localhost:3000", false},
+		{"https://*.example.com", false},
+		{"example.com", true},
+		{"https://", true},
+		{"https://example.com/app", true},
+		{"https://app.*.com", true},
+		{"https://*.*.example.com", true},
+		{"https://*example.com", true},
+	}
+	for _, tt := range tests {
+		if err := validateOriginPattern(tt.pattern); (err != nil) != tt.wantErr {
+			t.Errorf("validateOriginPattern(%q) = %v, want error %t", tt.pattern, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWSOriginCheck(t *testing.T) {
+	defer func(allowed []string, noOrigin bool) {
+		cfg.AllowedOrigins, cfg.AllowNoOrigin = allowed, noOrigin
+	}(cfg.AllowedOrigins, cfg.AllowNoOrigin)
+	cfg.AllowedOrigins = []string{"https://*.example.com"}
+
+	_, url := wsServer(t, (&countingFetch{}).fetch)
+	tests := []struct {
+		name     string
+		origin   string // "" sends no Origin header; "self" the server's own
+		noOrigin bool
+		wantCode int
+	}{
+		{"same origin", "self", false, http.StatusSwitchingProtocols},
+		{"wildcard match", "https://app.example.com", false, http.StatusSwitchingProtocols},
+		{"mismatch", "https://evil.net", true, http.StatusForbidden},
+		{"missing header allowed", "", true, http.StatusSwitchingProtocols},
+		{"missing header refused", "", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.AllowNoOrigin = tt.noOrigin
+			h := http.Header{}
+			switch tt.origin {
+			case "":
+			case "self":
+				h.Set("Origin", "http"+url[len("ws"):len(url)-len("/ws")])
+			default:
+				h.Set("Origin", tt.origin)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial(url, h)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("no response: %v", err)
+			}
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	defer func(allowed []string) { cfg.AllowedOrigins = allowed }(cfg.AllowedOrigins)
+	cfg.AllowedOrigins = []string{"https://*.example.com"}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	tests := []struct {
+		name, method, origin string
+		wantCode             int
+		wantAllow            string
+	}{
+		{"allowed preflight", http.MethodOptions, "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+		{"refused preflight", http.MethodOptions, "https://evil.net", http.StatusForbidden, ""},
+		{"allowed request", http.MethodGet, "https://app.example.com", http.StatusOK, "https://app.example.com"},
+		{"foreign request", http.MethodGet, "https://evil.net", http.StatusOK, ""},
+		{"no origin", http.MethodGet, "", http.StatusOK, ""},
+		{"same origin", http.MethodGet, "http://example.com", http.StatusOK, "http://example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/quote?symbol=AAPL", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			rec := httptest.NewRecorder()
+			withCORS(next).ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Errorf("Access-Control-Allow-Origin %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestCORSHeaders(t *testing.T) {
+	defer func(allowed []string) { cfg.AllowedOrigins = allowed }(cfg.AllowedOrigins)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	tests := []struct {
+		name    string
+		allowed []string
+		method  string
+		want    map[string]string
+	}{
+		{"preflight", []string{"https://app.example.com"}, http.MethodOptions, map[string]string{
+			"Access-Control-Allow-Origin":  "https://app.example.com",
+			"Access-Control-Allow-Methods": corsMethods,
+			"Access-Control-Allow-Headers": corsHeaders,
+			"Access-Control-Max-Age":       corsMaxAge,
+			"Vary":                         "Origin",
+		}},
+		{"request", []string{"https://app.example.com"}, http.MethodGet, map[string]string{
+			"Access-Control-Allow-Origin":   "https://app.example.com",
+			"Access-Control-Expose-Headers": corsExpose,
+			"Access-Control-Allow-Methods":  "",
+			"Vary":                          "Origin",
+		}},
+		// "*" admits any origin, still echoed so credentials keep working
+		{"wildcard", []string{"*"}, http.MethodGet, map[string]string{
+			"Access-Control-Allow-Origin": "https://app.example.com",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.AllowedOrigins = tt.allowed
+			req := httptest.NewRequest(tt.method, "/api/candles?symbol=AAPL", nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			rec := httptest.NewRecorder()
+			withCORS(next).ServeHTTP(rec, req)
+			for k, v := range tt.want {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestWSOriginAllowlistDefaults(t *testing.T) {
+	defer func(allowed []string) { cfg.AllowedOrigins = allowed }(cfg.AllowedOrigins)
+	_, url := wsServer(t, (&countingFetch{}).fetch)
+	self := "http" + strings.TrimSuffix(strings.TrimPrefix(url, "ws"), "/ws")
+	tests := []struct {
+		name     string
+		allowed  []string
+		origin   string
+		wantCode int
+	}{
+		{"unset: same host", nil, self, http.StatusSwitchingProtocols},
+		{"unset: off-list", nil, "https://evil.net", http.StatusForbidden},
+		{"unset: same host, other port", nil, "http://127.0.0.1:1", http.StatusForbidden},
+		{"list: off-list", []string{"https://app.example.com"}, "https://evil.net", http.StatusForbidden},
+		{"list: same host still allowed", []string{"https://app.example.com"}, self, http.StatusSwitchingProtocols},
+		{"wildcard for development", []string{"*"}, "https://evil.net", http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.AllowedOrigins = tt.allowed
+			conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {tt.origin}})
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("no response: %v", err)
+			}
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
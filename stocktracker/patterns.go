@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// patternTolerances are the body and wick proportions the candlestick
+// detectors test against.
+type patternTolerances struct {
+	// A doji's body is at most this fraction of its high-low range
+	DojiBody float64 `json:"dojiBody"`
+	// A hammer's lower wick, or a shooting star's upper wick, is at least
+	// this many times its body
+	WickToBody float64 `json:"wickToBody"`
+	// ... while its other wick is at most this fraction of the range
+	OppositeWick float64 `json:"oppositeWick"`
+}
+
+var defaultPatternTolerances = patternTolerances{DojiBody: 0.1, WickToBody: 2, OppositeWick: 0.1}
+
+// A pattern detector reports whether the bar at i completes its pattern,
+// and whether that reads "bullish", "bearish" or "neutral".
+type patternDetector func(c *Candles, i int, tol patternTolerances) (direction string, ok bool)
+
+var patternDetectors = map[string]patternDetector{
+	"doji":              doji,
+	"hammer":            hammer,
+	"shooting_star":     shootingStar,
+	"bullish_engulfing": bullishEngulfing,
+	"bearish_engulfing": bearishEngulfing,
+}
+
+// barShape is a bar's body and wicks, all non-negative
+func barShape(c *Candles, i int) (body, upper, lower, span float64) {
+	o, h, l, cl := c.Open[i], c.High[i], c.Low[i], c.Close[i]
+	return math.Abs(cl - o), h - max(o, cl), min(o, cl) - l, h - l
+}
+
+// doji: open and close (nearly) meet, indecision
+func doji(c *Candles, i int, tol patternTolerances) (string, bool) {
+	body, _, _, span := barShape(c, i)
+	return "neutral", span > 0 && body <= tol.DojiBody*span
+}
+
+// hammer: a small body atop a long lower wick, buyers pushing back
+func hammer(c *Candles, i int, tol patternTolerances) (string, bool) {
+	body, upper, lower, span := barShape(c, i)
+	return "bullish", span > 0 && body > tol.DojiBody*span &&
+		lower >= tol.WickToBody*body && upper <= tol.OppositeWick*span
+}
+
+// shootingStar: a small body under a long upper wick, sellers pushing back
+func shootingStar(c *Candles, i int, tol patternTolerances) (string, bool) {
+	body, upper, lower, span := barShape(c, i)
+	return "bearish", span > 0 && body > tol.DojiBody*span &&
+		upper >= tol.WickToBody*body && lower <= tol.OppositeWick*span
+}
+
+// bullishEngulfing: a rising bar whose body covers the falling one before
+func bullishEngulfing(c *Candles, i int, _ patternTolerances) (string, bool) {
+	if i == 0 {
+		return "bullish", false
+	}
+	po, pc, o, cl := c.Open[i-1], c.Close[i-1], c.Open[i], c.Close[i]
+	return "bullish", pc < po && cl > o && o <= pc && cl >= po && cl-o > po-pc
+}
+
+// bearishEngulfing: a falling bar whose body covers the rising one before
+func bearishEngulfing(c *Candles, i int, _ patternTolerances) (string, bool) {
+	if i == 0 {
+		return "bearish", false
+	}
+	po, pc, o, cl := c.Open[i-1], c.Close[i-1], c.Open[i], c.Close[i]
+	return "bearish", pc > po && cl < o && o >= pc && cl <= po && o-cl > pc-po
+}
+
+// patternHit is one bar that completed a pattern
+type patternHit struct {
+	Time      int64  `json:"time"` // UNIX seconds, of the completing bar
+	Pattern   string `json:"pattern"`
+	Direction string `json:"direction"`
+}
+
+// findPatterns runs the named detectors over every bar of c, in time order
+func findPatterns(c *Candles, names []string, tol patternTolerances) []patternHit {
+	hits := []patternHit{}
+	for i := range c.Len() {
+		for _, name := range names {
+			if dir, ok := patternDetectors[name](c, i, tol); ok {
+				hits = append(hits, patternHit{Time: c.Time[i], Pattern: name, Direction: dir})
+			}
+		}
+	}
+	return hits
+}
+
+// GET /api/patterns?symbol=TSLA&resolution=5&minutes=390
+// GET /api/patterns?symbol=TSLA&patterns=hammer,doji
+// Candlestick patterns in the candle window (same parameters as
+// /api/candles): doji, hammer, shooting_star, bullish_engulfing and
+// bearish_engulfing, or those listed in patterns. Each hit names the bar
+// that completes it; the proportions tested are echoed as thresholds:
+//
+//	{"symbol":"TSLA","status":"ok","patterns":["doji",...],
+//	 "thresholds":{"dojiBody":0.1,"wickToBody":2,"oppositeWick":0.1},
+//	 "hits":[{"time":1717000200,"pattern":"hammer","direction":"bullish"}]}
+func (s *server) handlePatterns(w http.ResponseWriter, r *http.Request) {
+	q, err := parseCandleQuery(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	all := make([]string, 0, len(patternDetectors))
+	for name := range patternDetectors {
+		all = append(all, name)
+	}
+	slices.Sort(all)
+	names := all
+	if v := r.URL.Query().Get("patterns"); v != "" {
+		names = nil
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := patternDetectors[name]; !ok {
+				badRequest(w, fmt.Sprintf("unknown pattern %q; valid patterns are %s", name, strings.Join(all, ", ")))
+				return
+			}
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	c, _, err := s.candles(r.Context(), q)
+	if err != nil {
+		badGateway(w, r, err)
+		return
+	}
+	hits := []patternHit{}
+	if c.S == "ok" {
+		hits = findPatterns(c, names, defaultPatternTolerances)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"symbol":     q.symbol,
+		"resolution": q.resolution,
+		"from":       q.from.Unix(),
+		"to":         q.to.Unix(),
+		"status":     c.S,
+		"patterns":   names,
+		"thresholds": defaultPatternTolerances,
+		"hits":       hits,
+	})
+}
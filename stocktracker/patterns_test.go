@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// barsOf makes one-minute candles from o, h, l, c rows
+func barsOf(rows ...[4]float64) *Candles {
+	c := &Candles{S: "ok"}
+	for i, r := range rows {
+		c.Time = append(c.Time, 1717000200+int64(i)*60)
+		c.Open = append(c.Open, r[0])
+		c.High = append(c.High, r[1])
+		c.Low = append(c.Low, r[2])
+		c.Close = append(c.Close, r[3])
+		c.Volume = append(c.Volume, 1)
+	}
+	return c
+}
+
+// Hand-built bars, as o, h, l, c
+var (
+	dojiBar    = [4]float64{10, 11, 9, 10.1}     // body 0.1 of a 2 range
+	smallBody  = [4]float64{10, 11, 9, 10.5}     // body a quarter of the range
+	flatBar    = [4]float64{10, 10, 10, 10}      // no range at all
+	hammerBar  = [4]float64{10, 10.6, 7, 10.5}   // lower wick 6x the body
+	tallHammer = [4]float64{10, 12, 7, 10.5}     // upper wick too long
+	tinyHammer = [4]float64{10, 10.1, 7, 10.05}  // body a doji's
+	starBar    = [4]float64{10.5, 14, 9.9, 10}   // upper wick 7x the body
+	falling    = [4]float64{11, 11.5, 9.5, 10}   // body 1
+	rising     = [4]float64{10, 11.5, 9.5, 11}   // body 1
+	engulfsUp  = [4]float64{9.8, 12, 9.5, 11.5}  // rising over falling
+	shortUp    = [4]float64{9.8, 12, 9.5, 10.8}  // closes under the prior open
+	engulfsDn  = [4]float64{11.2, 11.5, 9, 9.8}  // falling over rising
+	shortDown  = [4]float64{11.2, 11.5, 9, 10.2} // closes over the prior open
+)
+
+func TestPatternDetectors(t *testing.T) {
+	def := defaultPatternTolerances
+	tests := []struct {
+		name    string
+		pattern string
+		bars    *Candles // the detector runs on the last one
+		tol     patternTolerances
+		want    bool
+		dir     string
+	}{
+		{"doji", "doji", barsOf(dojiBar), def, true, "neutral"},
+		{"body too big for a doji", "doji", barsOf(smallBody), def, false, "neutral"},
+		{"flat bar is no doji", "doji", barsOf(flatBar), def, false, "neutral"},
+		{"looser doji tolerance", "doji", barsOf(smallBody), patternTolerances{DojiBody: 0.3, WickToBody: 2, OppositeWick: 0.1}, true, "neutral"},
+
+		{"hammer", "hammer", barsOf(hammerBar), def, true, "bullish"},
+		{"hammer with a long upper wick", "hammer", barsOf(tallHammer), def, false, "bullish"},
+		{"hammer with a doji body", "hammer", barsOf(tinyHammer), def, false, "bullish"},
+		{"stricter wick ratio", "hammer", barsOf(hammerBar), patternTolerances{DojiBody: 0.1, WickToBody: 10, OppositeWick: 0.1}, false, "bullish"},
+		{"star is no hammer", "hammer", barsOf(starBar), def, false, "bullish"},
+
+		{"shooting star", "shooting_star", barsOf(starBar), def, true, "bearish"},
+		{"hammer is no star", "shooting_star", barsOf(hammerBar), def, false, "bearish"},
+		{"doji is no star", "shooting_star", barsOf(dojiBar), def, false, "bearish"},
+
+		{"bullish engulfing", "bullish_engulfing", barsOf(falling, engulfsUp), def, true, "bullish"},
+		{"doesn't reach the prior open", "bullish_engulfing", barsOf(falling, shortUp), def, false, "bullish"},
+		{"prior bar rising", "bullish_engulfing", barsOf(rising, engulfsUp), def, false, "bullish"},
+		{"first bar can't engulf", "bullish_engulfing", barsOf(engulfsUp), def, false, "bullish"},
+
+		{"bearish engulfing", "bearish_engulfing", barsOf(rising, engulfsDn), def, true, "bearish"},
+		{"doesn't reach the prior open down", "bearish_engulfing", barsOf(rising, shortDown), def, false, "bearish"},
+		{"prior bar falling", "bearish_engulfing", barsOf(falling, engulfsDn), def, false, "bearish"},
+		{"first bar can't engulf down", "bearish_engulfing", barsOf(engulfsDn), def, false, "bearish"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, ok := patternDetectors[tt.pattern](tt.bars, tt.bars.Len()-1, tt.tol)
+			if ok != tt.want || dir != tt.dir {
+				t.Errorf("%s = %q, %t; want %q, %t", tt.pattern, dir, ok, tt.dir, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindPatterns(t *testing.T) {
+	c := barsOf(falling, engulfsUp, hammerBar, dojiBar)
+	all := []string{"bearish_engulfing", "bullish_engulfing", "doji", "hammer", "shooting_star"}
+	got := findPatterns(c, all, defaultPatternTolerances)
+	want := []patternHit{
+		{c.Time[1], "bullish_engulfing", "bullish"},
+		{c.Time[2], "hammer", "bullish"},
+		{c.Time[3], "doji", "neutral"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("hits %v, want %v", got, want)
+	}
+	if got := findPatterns(c, []string{"shooting_star"}, defaultPatternTolerances); len(got) != 0 {
+		t.Errorf("shooting stars %v, want none", got)
+	}
+}
+
+func TestHandlePatterns(t *testing.T) {
+	s := indicatorServer(barsOf(falling, engulfsUp, hammerBar, dojiBar))
+	tests := []struct {
+		query    string
+		wantCode int
+		patterns []string
+		hits     []string
+	}{
+		{"", http.StatusOK, []string{"bearish_engulfing", "bullish_engulfing", "doji", "hammer", "shooting_star"},
+			[]string{"bullish_engulfing", "hammer", "doji"}},
+		{"&patterns=hammer,doji", http.StatusOK, []string{"hammer", "doji"}, []string{"hammer", "doji"}},
+		{"&patterns=hammer,%20hammer", http.StatusOK, []string{"hammer"}, []string{"hammer"}},
+		{"&patterns=shooting_star", http.StatusOK, []string{"shooting_star"}, []string{}},
+		{"&patterns=morning_star", http.StatusBadRequest, nil, nil},
+		{"&patterns=", http.StatusOK, []string{"bearish_engulfing", "bullish_engulfing", "doji", "hammer", "shooting_star"},
+			[]string{"bullish_engulfing", "hammer", "doji"}},
+		{"&resolution=2", http.StatusBadRequest, nil, nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handlePatterns(rec, httptest.NewRequest(http.MethodGet, "/api/patterns?symbol=TSLA"+tt.query, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%q: status %d, want %d: %s", tt.query, rec.Code, tt.wantCode, rec.Body)
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Patterns   []string          `json:"patterns"`
+			Thresholds patternTolerances `json:"thresholds"`
+			Hits       []patternHit      `json:"hits"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		hits := []string{}
+		for _, h := range body.Hits {
+			hits = append(hits, h.Pattern)
+		}
+		if !slices.Equal(body.Patterns, tt.patterns) || !slices.Equal(hits, tt.hits) {
+			t.Errorf("%q: ran %v, hit %v; want %v, %v", tt.query, body.Patterns, hits, tt.patterns, tt.hits)
+		}
+		if body.Thresholds != defaultPatternTolerances {
+			t.Errorf("%q: thresholds %+v, want the defaults", tt.query, body.Thresholds)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Most /api/poll requests waiting on one symbol at a time
+const pollMaxWaiters = 100
+
+// How long /api/poll holds a request open waiting for a newer quote;
+// under the 30s idle cutoff common in proxies
+var pollWait = 25 * time.Second
+
+// pollWaiters counts the /api/poll requests waiting on each symbol.
+type pollWaiters struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+// acquire reserves a waiter slot for symbol, reporting false if it is full
+func (p *pollWaiters) acquire(symbol string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.n[symbol] >= pollMaxWaiters {
+		return false
+	}
+	if p.n == nil {
+		p.n = make(map[string]int)
+	}
+	p.n[symbol]++
+	return true
+}
+
+func (p *pollWaiters) release(symbol string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.n[symbol]--; p.n[symbol] <= 0 {
+		delete(p.n, symbol)
+	}
+}
+
+// GET /api/poll?symbol=TSLA&since=1717000000000
+// Long-polling fallback for clients that can neither open /ws nor read
+// /events. Answers with the /ws quote message as soon as the shared poller
+// has a quote newer than since (UNIX milliseconds; 0 or missing returns
+// the latest straight away), or 204 No Content after 25s without one.
+// Pass the returned time back as since on the next call. A failed fetch
+// answers 502 like /api/quote.
+func (s *server) handlePoll(w http.ResponseWriter, r *http.Request) {
+	symbol, err := querySymbol(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	var since int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			badRequest(w, "since must be UNIX milliseconds")
+			return
+		}
+		since = n
+	}
+
+	if !s.polls.acquire(symbol) {
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "too_many_waiters"})
+		return
+	}
+	defer s.polls.release(symbol)
+
+	updates := newUpdateQueue()
+	defer s.hub.Unregister(updates)
+	if err := s.hub.Subscribe(symbol, updates, cfg.PollInterval); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(connRetryAfter.Seconds())))
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "symbol_limit"})
+		return
+	}
+
+	timeout := time.NewTimer(pollWait)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return // client gave up
+		case <-s.done:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-timeout.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-updates.ready:
+		}
+		for {
+			u, ok := updates.pop()
+			if !ok {
+				break
+			}
+			switch {
+			case u.Err != nil:
+				badGateway(w, r, u.Err)
+				return
+			case u.Time.UnixMilli() > since:
+				writeJSON(w, http.StatusOK, quoteMsg(u.Symbol, u.Quote, u.Time))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// pollServer serves /api/poll from a hub polling with fetch
+func pollServer(fetch func(context.Context, string) (*Quote, error)) *server {
+	return &server{hub: newHub(fetch), done: make(chan struct{})}
+}
+
+// poll runs one /api/poll request, bounded by ctx
+func poll(ctx context.Context, s *server, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/poll?"+query, nil)
+	rec := httptest.NewRecorder()
+	s.handlePoll(rec, req)
+	return rec
+}
+
+func TestPoll(t *testing.T) {
+	defer func(d time.Duration) { pollWait = d }(pollWait)
+	pollWait = 200 * time.Millisecond
+
+	future := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	tests := []struct {
+		name      string
+		fetchErr  error
+		query     string
+		wantCode  int
+		wantPrice float64
+	}{
+		{"latest straight away", nil, "symbol=TSLA", http.StatusOK, 101},
+		{"newer than since", nil, "symbol=TSLA&since=1", http.StatusOK, 101},
+		{"nothing newer before the timeout", nil, "symbol=TSLA&since=" + future, http.StatusNoContent, 0},
+		{"upstream failure", errors.New("upstream down"), "symbol=TSLA", http.StatusBadGateway, 0},
+		{"rate limited", &RateLimitError{RetryAfter: time.Second}, "symbol=TSLA", http.StatusTooManyRequests, 0},
+		{"bad since", nil, "symbol=TSLA&since=yesterday", http.StatusBadRequest, 0},
+		{"negative since", nil, "symbol=TSLA&since=-1", http.StatusBadRequest, 0},
+		{"bad symbol", nil, "symbol=$$$", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := pollServer((&countingFetch{err: tt.fetchErr}).fetch)
+			start := time.Now()
+			rec := poll(context.Background(), s, tt.query)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			d := time.Since(start)
+			if tt.wantCode == http.StatusNoContent && d < pollWait {
+				t.Errorf("gave up after %s, want %s", d, pollWait)
+			}
+			if tt.wantCode != http.StatusNoContent && d > pollWait/2 {
+				t.Errorf("took %s, want an answer at once", d)
+			}
+			if tt.wantPrice != 0 {
+				var q map[string]any
+				if err := json.Unmarshal(rec.Body.Bytes(), &q); err != nil {
+					t.Fatal(err)
+				}
+				if q["symbol"] != "TSLA" || q["price"] != tt.wantPrice {
+					t.Errorf("quote %v", q)
+				}
+			}
+			waitFor(t, "the subscription to end", func() bool { return len(s.hub.Subscribers()) == 0 })
+		})
+	}
+}
+
+func TestPollCancellation(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(s *server, cancel context.CancelFunc)
+		want int // 0: no answer written
+	}{
+		{"client gives up", func(_ *server, cancel context.CancelFunc) { cancel() }, 0},
+		{"server shuts down", func(s *server, _ context.CancelFunc) { close(s.done) }, http.StatusNoContent},
+	}
+	future := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := pollServer((&countingFetch{}).fetch)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- poll(ctx, s, "symbol=TSLA&since="+future) }()
+			waitFor(t, "the poll to wait", func() bool { return s.hub.Subscribers()["TSLA"] == 1 })
+
+			tt.end(s, cancel)
+			select {
+			case rec := <-done:
+				if tt.want != 0 && rec.Code != tt.want {
+					t.Errorf("status %d, want %d", rec.Code, tt.want)
+				}
+				if tt.want == 0 && rec.Body.Len() != 0 {
+					t.Errorf("answered %q to a gone client", rec.Body)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the poll is still waiting")
+			}
+			waitFor(t, "the subscription to end", func() bool { return len(s.hub.Subscribers()) == 0 })
+			if s.polls.n["TSLA"] != 0 {
+				t.Errorf("%d waiter slots still held", s.polls.n["TSLA"])
+			}
+		})
+	}
+}
+
+func TestPollWaiterCap(t *testing.T) {
+	s := pollServer((&countingFetch{}).fetch)
+	p := &s.polls
+	for i := range pollMaxWaiters {
+		if !p.acquire("TSLA") {
+			t.Fatalf("waiter %d refused below the cap", i+1)
+		}
+	}
+	if p.acquire("TSLA") {
+		t.Error("waiter past the cap admitted")
+	}
+	if !p.acquire("AAPL") {
+		t.Error("the cap is per symbol")
+	}
+	p.release("TSLA")
+	if !p.acquire("TSLA") {
+		t.Error("a released slot is not reusable")
+	}
+
+	// The handler answers a full symbol with 503
+	rec := poll(context.Background(), s, "symbol=TSLA")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status %d, Retry-After %q; want 503 with a Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
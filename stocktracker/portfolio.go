@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Most positions a streamed portfolio may hold
+const maxHoldings = 50
+
+// holding is one long position: a quantity bought at costBasis per share.
+type holding struct {
+	Symbol    string  `json:"symbol"`
+	Quantity  float64 `json:"quantity"`
+	CostBasis float64 `json:"costBasis"`
+}
+
+// heldQuote is the latest fetch result for a held symbol. quote keeps the
+// last good value when a later fetch fails.
+type heldQuote struct {
+	quote  *Quote
+	failed bool
+}
+
+// parseHoldings normalizes symbols and rejects positions that can't be
+// valued: blanks, duplicates, negative quantities or costs. sizeField names
+// the quantity in errors, as the caller's payload calls it.
+func parseHoldings(hs []holding, sizeField string) ([]holding, error) {
+	if len(hs) > maxHoldings {
+		return nil, fmt.Errorf("portfolio limit reached (%d)", maxHoldings)
+	}
+	seen := make(map[string]bool, len(hs))
+	out := make([]holding, 0, len(hs))
+	for _, h := range hs {
+		var err error
+		if h.Symbol, err = normalizeSymbol(h.Symbol); err != nil {
+			return nil, fmt.Errorf("holding: %w", err)
+		}
+		switch {
+		case seen[h.Symbol]:
+			return nil, fmt.Errorf("duplicate holding %s", h.Symbol)
+		case h.Quantity < 0:
+			return nil, fmt.Errorf("holding %s: %s must not be negative", h.Symbol, sizeField)
+		case h.CostBasis < 0:
+			return nil, fmt.Errorf("holding %s: costBasis must not be negative", h.Symbol)
+		}
+		seen[h.Symbol] = true
+		out = append(out, h)
+	}
+	return out, nil
+}
+
+// portfolioMsg values holdings at the given quotes. Positions without a
+// quote yet are "pending"; ones whose latest fetch failed are "stale"
+// (valued at the last good quote) or "error" (no quote at all). Totals
+// only cover valued positions, and partial is set if any position isn't
+// "ok".
+func portfolioMsg(hs []holding, quotes map[string]heldQuote) map[string]any {
+	var value, cost, dayChange, unrealized float64
+	partial := false
+	positions := make([]map[string]any, 0, len(hs))
+	for _, h := range hs {
+		pos := map[string]any{
+			"symbol":    h.Symbol,
+			"quantity":  h.Quantity,
+			"costBasis": h.CostBasis,
+		}
+		positions = append(positions, pos)
+
+		hq, ok := quotes[h.Symbol]
+		switch {
+		case !ok:
+			pos["status"] = "pending"
+		case hq.quote == nil:
+			pos["status"] = "error"
+		case hq.failed:
+			pos["status"] = "stale"
+		default:
+			pos["status"] = "ok"
+		}
+		if pos["status"] != "ok" {
+			partial = true
+		}
+		if hq.quote == nil {
+			continue
+		}
+
+		q := hq.quote
+		posValue := h.Quantity * q.Current
+		posCost := h.Quantity * h.CostBasis
+		posDay := h.Quantity * q.Change()
+		pos["price"] = q.Current
+		pos["marketValue"] = round2(posValue)
+		pos["dayChange"] = round2(posDay)
+		pos["unrealizedPL"] = round2(posValue - posCost)
+
+		value += posValue
+		cost += posCost
+		dayChange += posDay
+		unrealized += posValue - posCost
+	}
+
+	var dayPct float64
+	if prev := value - dayChange; prev != 0 {
+		dayPct = round2(dayChange / prev * 100)
+	}
+	return map[string]any{
+		"type":             "portfolio",
+		"partial":          partial,
+		"marketValue":      round2(value),
+		"costBasis":        round2(cost),
+		"dayChange":        round2(dayChange),
+		"dayChangePercent": dayPct,
+		"unrealizedPL":     round2(unrealized),
+		"positions":        positions,
+	}
+}
+
+// ---------------- HTTP Handler ----------------
+
+// POST /api/portfolio [{"symbol":"AAPL","shares":10,"costBasis":150},...]
+// Values the holdings at current quotes, fetched concurrently like
+// /api/quotes: the /ws portfolio message without its type, with each
+// position's quantity as shares. A quote that can't be fetched, or a
+// symbol Finnhub doesn't know, marks its position "error" with the reason
+// and leaves it out of the totals rather than failing the request:
+//
+//	{"partial":false,"marketValue":1901,"costBasis":1500,"dayChange":21,
+//	 "dayChangePercent":1.12,"unrealizedPL":401,
+//	 "positions":[{"symbol":"AAPL","shares":10,"costBasis":150,"status":"ok",
+//	   "price":190.1,"marketValue":1901,"dayChange":21,"unrealizedPL":401}]}
+func (s *server) handlePortfolio(w http.ResponseWriter, r *http.Request) {
+	var body []struct {
+		Symbol    string   `json:"symbol"`
+		Shares    *float64 `json:"shares"`
+		CostBasis float64  `json:"costBasis"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		badRequest(w, "body must be a list of holdings")
+		return
+	}
+	hs := make([]holding, len(body))
+	for i, b := range body {
+		if b.Shares == nil {
+			badRequest(w, fmt.Sprintf("holding %s: shares is required", b.Symbol))
+			return
+		}
+		hs[i] = holding{Symbol: b.Symbol, Quantity: *b.Shares, CostBasis: b.CostBasis}
+	}
+	hs, err := parseHoldings(hs, "shares")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if len(hs) == 0 {
+		badRequest(w, "no holdings")
+		return
+	}
+
+	symbols := make([]string, len(hs))
+	for i, h := range hs {
+		symbols[i] = h.Symbol
+	}
+	fetched := s.fetchQuotes(r.Context(), symbols)
+	if r.Context().Err() != nil {
+		return // nobody left to answer
+	}
+	quotes := make(map[string]heldQuote, len(hs))
+	reasons := make(map[string]string)
+	for i, sym := range symbols {
+		q := fetched[i]
+		switch {
+		case q == nil:
+			reasons[sym] = "quote unavailable"
+		case q.Empty():
+			reasons[sym] = "unknown symbol"
+			q = nil
+		}
+		quotes[sym] = heldQuote{quote: q, failed: q == nil}
+	}
+	msg := portfolioMsg(hs, quotes)
+	delete(msg, "type")
+	for _, pos := range msg["positions"].([]map[string]any) {
+		pos["shares"] = pos["quantity"]
+		delete(pos, "quantity")
+		if reason, ok := reasons[pos["symbol"].(string)]; ok {
+			pos["error"] = reason
+		}
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
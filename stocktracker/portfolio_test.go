@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseHoldings(t *testing.T) {
+	tooMany := make([]holding, maxHoldings+1)
+	for i := range tooMany {
+		tooMany[i] = holding{Symbol: "A" + strings.Repeat("A", i%5), Quantity: 1}
+	}
+	tests := []struct {
+		name    string
+		in      []holding
+		want    []string // normalized symbols
+		wantErr string
+	}{
+		{"normalizes", []holding{{Symbol: " aapl ", Quantity: 10, CostBasis: 150}}, []string{"AAPL"}, ""},
+		{"zero quantity", []holding{{Symbol: "AAPL", Quantity: 0}}, []string{"AAPL"}, ""},
+		{"zero cost", []holding{{Symbol: "AAPL", Quantity: 1}}, []string{"AAPL"}, ""},
+		{"empty", nil, []string{}, ""},
+		{"negative quantity", []holding{{Symbol: "AAPL", Quantity: -1}}, nil, "shares must not be negative"},
+		{"negative cost", []holding{{Symbol: "AAPL", Quantity: 1, CostBasis: -5}}, nil, "costBasis"},
+		{"duplicate", []holding{{Symbol: "AAPL", Quantity: 1}, {Symbol: "aapl", Quantity: 2}}, nil, "duplicate"},
+		{"blank symbol", []holding{{Symbol: " ", Quantity: 1}}, nil, "holding"},
+		{"too many", tooMany, nil, "limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHoldings(tt.in, "shares")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			syms := make([]string, len(got))
+			for i, h := range got {
+				syms[i] = h.Symbol
+			}
+			if strings.Join(syms, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("symbols = %v, want %v", syms, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortfolioMsg(t *testing.T) {
+	hs := []holding{
+		{Symbol: "AAPL", Quantity: 10, CostBasis: 150},
+		{Symbol: "MSFT", Quantity: 2, CostBasis: 400},
+		{Symbol: "TSLA", Quantity: 5, CostBasis: 200},
+		{Symbol: "NVDA", Quantity: 1, CostBasis: 100},
+	}
+	quotes := map[string]heldQuote{
+		"AAPL": {quote: &Quote{Current: 190, PrevClose: 188}},
+		"MSFT": {quote: &Quote{Current: 410, PrevClose: 415}, failed: true}, // stale
+		"TSLA": {failed: true},                                              // error
+		// NVDA pending
+	}
+	msg := portfolioMsg(hs, quotes)
+
+	positions := msg["positions"].([]map[string]any)
+	for i, want := range []string{"ok", "stale", "error", "pending"} {
+		if got := positions[i]["status"]; got != want {
+			t.Errorf("%s status = %v, want %s", hs[i].Symbol, got, want)
+		}
+	}
+	if positions[0]["unrealizedPL"] != 400.0 || positions[1]["unrealizedPL"] != 20.0 {
+		t.Errorf("unrealizedPL = %v, %v; want 400, 20", positions[0]["unrealizedPL"], positions[1]["unrealizedPL"])
+	}
+	if _, ok := positions[2]["marketValue"]; ok {
+		t.Error("a position without a quote has a market value")
+	}
+
+	// Totals cover AAPL and MSFT only
+	want := map[string]any{
+		"partial":      true,
+		"marketValue":  2720.0,
+		"costBasis":    2300.0,
+		"dayChange":    10.0,
+		"unrealizedPL": 420.0,
+	}
+	for k, v := range want {
+		if msg[k] != v {
+			t.Errorf("%s = %v, want %v", k, msg[k], v)
+		}
+	}
+	if got := msg["dayChangePercent"]; got != 0.37 {
+		t.Errorf("dayChangePercent = %v, want 0.37", got)
+	}
+}
+
+func TestHandlePortfolio(t *testing.T) {
+	s := &server{provider: &stubProvider{quote: func(ctx context.Context, symbol string) (*Quote, error) {
+		switch symbol {
+		case "AAPL":
+			return &Quote{Current: 190, PrevClose: 188}, nil
+		case "DOWN":
+			return nil, errors.New("upstream down")
+		}
+		return &Quote{}, nil // Finnhub's answer for an unknown symbol
+	}}}
+	post := func(body string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		s.handlePortfolio(rec, httptest.NewRequest(http.MethodPost, "/api/portfolio", strings.NewReader(body)))
+		var out map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"not a list", `{"symbol":"AAPL"}`, http.StatusBadRequest},
+		{"no holdings", `[]`, http.StatusBadRequest},
+		{"missing shares", `[{"symbol":"AAPL","costBasis":1}]`, http.StatusBadRequest},
+		{"negative shares", `[{"symbol":"AAPL","shares":-1}]`, http.StatusBadRequest},
+		{"zero shares", `[{"symbol":"AAPL","shares":0,"costBasis":150}]`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code, body := post(tt.body); code != tt.code {
+			t.Errorf("%s: status %d, want %d (%v)", tt.name, code, tt.code, body)
+		}
+	}
+
+	code, body := post(`[{"symbol":"AAPL","shares":10,"costBasis":150},
+		{"symbol":"ZZZZ","shares":1,"costBasis":1},{"symbol":"DOWN","shares":1,"costBasis":1}]`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	positions := body["positions"].([]any)
+	wantErrors := []any{nil, "unknown symbol", "quote unavailable"}
+	for i, p := range positions {
+		pos := p.(map[string]any)
+		if pos["error"] != wantErrors[i] {
+			t.Errorf("%s error = %v, want %v", pos["symbol"], pos["error"], wantErrors[i])
+		}
+		if _, ok := pos["quantity"]; ok || pos["shares"] == nil {
+			t.Errorf("%s: position %v, want shares rather than quantity", pos["symbol"], pos)
+		}
+	}
+	if body["marketValue"] != 1900.0 || body["unrealizedPL"] != 400.0 || body["partial"] != true {
+		t.Errorf("totals %v, want only AAPL valued and partial set", body)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// How long a company profile is reused; names and listings rarely change
+const profileCacheTTL = 6 * time.Hour
+
+// GET /api/profile?symbol=MSFT
+// Company details from Finnhub. marketCap and sharesOutstanding are in
+// millions, marketCap in the listed currency; ipo is YYYY-MM-DD:
+//
+//	{"symbol":"MSFT","name":"Microsoft Corp","exchange":"NASDAQ NMS - GLOBAL MARKET",
+//	 "currency":"USD","country":"US","ipo":"1986-03-13","marketCap":3100000,
+//	 "sharesOutstanding":7430,"industry":"Technology","weburl":"https://www.microsoft.com/",
+//	 "logo":"https://.../MSFT.png"}
+//
+// Profiles are cached for six hours. A symbol Finnhub has no profile for
+// answers 404.
+func (s *server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	symbol, err := querySymbol(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	p, ok := s.profiles.get(symbol)
+	if !ok {
+		var err error
+		if p, err = s.provider.Profile(r.Context(), symbol); err != nil {
+			badGateway(w, r, err)
+			return
+		}
+		if p.Name == "" && p.Ticker == "" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown_symbol"})
+			return
+		}
+		s.profiles.put(symbol, p)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"symbol":            symbol,
+		"name":              p.Name,
+		"exchange":          p.Exchange,
+		"currency":          p.Currency,
+		"country":           p.Country,
+		"ipo":               p.IPO,
+		"marketCap":         p.MarketCap,
+		"sharesOutstanding": p.SharesOutstanding,
+		"industry":          p.Industry,
+		"weburl":            p.WebURL,
+		"logo":              p.Logo,
+	})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrTooManyBars refuses a candle window holding more bars than the
+// server will assemble in memory.
+var ErrTooManyBars = errors.New("too many candles")
+
+// ErrRateLimited is returned (wrapped) when the upstream rejects a call
+// for exceeding its rate limit.
+var ErrRateLimited = errors.New("rate_limited")
+
+// RateLimitError is an ErrRateLimited with a hint of when to try again
+type RateLimitError struct {
+	RetryAfter time.Duration // 0 if unknown
+	Local      bool          // refused by our own limiter, not by Finnhub
+	Detail     string
+}
+
+func (e *RateLimitError) Error() string { return ErrRateLimited.Error() + ": " + e.Detail }
+func (e *RateLimitError) Unwrap() error { return ErrRateLimited }
+
+// Provider is a source of market data. Handlers only talk to this
+// interface so other data sources (or a mock) can be dropped in.
+type Provider interface {
+	Quote(ctx context.Context, symbol string) (*Quote, error)
+	Candles(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error)
+	Search(ctx context.Context, query string) ([]SymbolMatch, error)
+	Profile(ctx context.Context, symbol string) (*Profile, error)
+	News(ctx context.Context, symbol string, from, to time.Time) ([]NewsItem, error)
+	MarketStatus(ctx context.Context, exchange string) (*ExchangeStatus, error)
+	Metrics(ctx context.Context, symbol string) (*Metrics, error)
+}
+
+// Metrics are a few of a company's key figures; nil where Finnhub has none.
+// JSON tags follow Finnhub's REST payload.
+type Metrics struct {
+	High52Week      *float64 `json:"52WeekHigh"`
+	High52WeekDate  *string  `json:"52WeekHighDate"` // YYYY-MM-DD
+	Low52Week       *float64 `json:"52WeekLow"`
+	Low52WeekDate   *string  `json:"52WeekLowDate"`
+	Beta            *float64 `json:"beta"`
+	AvgVolume10Day  *float64 `json:"10DayAverageTradingVolume"` // millions of shares
+	AvgVolume3Month *float64 `json:"3MonthAverageTradingVolume"`
+	PE              *float64 `json:"peTTM"`
+	DividendYield   *float64 `json:"dividendYieldIndicatedAnnual"` // percent
+}
+
+// ExchangeStatus is whether an exchange is trading right now.
+// JSON tags follow Finnhub's REST payload.
+type ExchangeStatus struct {
+	Exchange string `json:"exchange"`
+	IsOpen   bool   `json:"isOpen"`
+	Session  string `json:"session"` // "pre-market", "regular", "post-market" or empty
+	Holiday  string `json:"holiday"` // the holiday's name, if it is one
+	Timezone string `json:"timezone"`
+	T        int64  `json:"t"` // UNIX seconds
+}
+
+// NewsItem is one company news article.
+// JSON tags follow Finnhub's REST payload.
+type NewsItem struct {
+	Datetime int64  `json:"datetime"` // UNIX seconds
+	Headline string `json:"headline"`
+	Source   string `json:"source"`
+	Summary  string `json:"summary"`
+	URL      string `json:"url"`
+	Image    string `json:"image"`
+}
+
+// Profile describes a listed company.
+// JSON tags follow Finnhub's REST payload.
+type Profile struct {
+	Ticker            string  `json:"ticker"`
+	Name              string  `json:"name"`
+	Exchange          string  `json:"exchange"`
+	Currency          string  `json:"currency"`
+	Country           string  `json:"country"`
+	IPO               string  `json:"ipo"`                  // YYYY-MM-DD
+	MarketCap         float64 `json:"marketCapitalization"` // millions of Currency
+	SharesOutstanding float64 `json:"shareOutstanding"`     // millions
+	Industry          string  `json:"finnhubIndustry"`
+	WebURL            string  `json:"weburl"`
+	Logo              string  `json:"logo"`
+}
+
+// SymbolMatch is one result of a symbol lookup.
+// JSON tags follow Finnhub's REST payload.
+type SymbolMatch struct {
+	Symbol        string `json:"symbol"`
+	Description   string `json:"description"`
+	Type          string `json:"type"`
+	DisplaySymbol string `json:"displaySymbol"`
+}
+
+// Quote is the latest price snapshot for a symbol.
+// JSON tags follow Finnhub's REST payload.
+type Quote struct {
+	Current   float64 `json:"c"`
+	High      float64 `json:"h"`
+	Low       float64 `json:"l"`
+	Open      float64 `json:"o"`
+	PrevClose float64 `json:"pc"`
+}
+
+// Change is the move since the previous close, rounded to cents.
+// It is 0 when there is no previous close to compare against.
+func (q *Quote) Change() float64 {
+	if q.PrevClose == 0 {
+		return 0
+	}
+	return round2(q.Current - q.PrevClose)
+}
+
+// ChangePercent is the move as a percentage of the previous close,
+// rounded to two decimals; 0 when there is no previous close.
+func (q *Quote) ChangePercent() float64 {
+	pct, _ := percentChange(q.PrevClose, q.Current)
+	return pct
+}
+
+// percentChange is the move from base to v as a percentage of base,
+// rounded to two decimals. ok is false, and the percentage 0, when base
+// is 0 and there is nothing to compare against.
+func percentChange(base, v float64) (pct float64, ok bool) {
+	if base == 0 {
+		return 0, false
+	}
+	return round2((v - base) / base * 100), true
+}
+
+// Empty reports whether every field is zero, which is how Finnhub
+// answers for a symbol it doesn't know.
+func (q *Quote) Empty() bool {
+	return *q == Quote{}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Candles holds OHLCV bars as parallel arrays.
+type Candles struct {
+	Close  []float64 `json:"c"`
+	High   []float64 `json:"h"`
+	Low    []float64 `json:"l"`
+	Open   []float64 `json:"o"`
+	Time   []int64   `json:"t"` // UNIX seconds
+	Volume []float64 `json:"v"`
+	S      string    `json:"s"` // "ok" or "no_data"
+}
+
+// Len is the number of complete bars: the shortest of the arrays.
+func (c *Candles) Len() int {
+	return min(len(c.Time), len(c.Open), len(c.High), len(c.Low), len(c.Close), len(c.Volume))
+}
+
+// appendAfter appends the bars of next that are later than c's last one,
+// dropping the bar two adjacent windows share at their boundary.
+func (c *Candles) appendAfter(next *Candles) {
+	n := c.Len()
+	*c = *c.head(n)
+	var last int64
+	if n > 0 {
+		last = c.Time[n-1]
+	}
+	for i := range next.Len() {
+		if next.Time[i] <= last {
+			continue
+		}
+		c.Time = append(c.Time, next.Time[i])
+		c.Open = append(c.Open, next.Open[i])
+		c.High = append(c.High, next.High[i])
+		c.Low = append(c.Low, next.Low[i])
+		c.Close = append(c.Close, next.Close[i])
+		c.Volume = append(c.Volume, next.Volume[i])
+	}
+}
+
+// head returns the first n bars, sharing the underlying arrays.
+func (c *Candles) head(n int) *Candles {
+	return &Candles{
+		Close:  c.Close[:n],
+		High:   c.High[:n],
+		Low:    c.Low[:n],
+		Open:   c.Open[:n],
+		Time:   c.Time[:n],
+		Volume: c.Volume[:n],
+		S:      c.S,
+	}
+}
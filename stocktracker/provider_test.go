@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuoteChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		q         Quote
+		change    float64
+		changePct float64
+		percentOK bool
+	}{
+		{"rise", Quote{Current: 190.1, PrevClose: 188}, 2.1, 1.12, true},
+		{"fall", Quote{Current: 180, PrevClose: 200}, -20, -10, true},
+		{"unchanged", Quote{Current: 50, PrevClose: 50}, 0, 0, true},
+		{"no previous close", Quote{Current: 190.1}, 0, 0, false},
+		{"to zero", Quote{Current: 0, PrevClose: 4}, -4, -100, true},
+		{"negative previous close", Quote{Current: -1, PrevClose: -2}, 1, -50, true},
+		{"rounded to cents", Quote{Current: 10.006, PrevClose: 3}, 7.01, 233.53, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Change(); got != tt.change {
+				t.Errorf("Change() = %v, want %v", got, tt.change)
+			}
+			if got := tt.q.ChangePercent(); got != tt.changePct {
+				t.Errorf("ChangePercent() = %v, want %v", got, tt.changePct)
+			}
+			if _, ok := percentChange(tt.q.PrevClose, tt.q.Current); ok != tt.percentOK {
+				t.Errorf("percentChange ok = %v, want %v", ok, tt.percentOK)
+			}
+		})
+	}
+}
+
+func TestQuoteMsgKeepsPriceAndTime(t *testing.T) {
+	at := time.UnixMilli(1717000000123)
+	m := quoteMsg("AAPL", &Quote{Current: 190.1, High: 191, Low: 187.5, Open: 188.2, PrevClose: 188}, at)
+	want := map[string]any{
+		"symbol":        "AAPL",
+		"price":         190.1,
+		"time":          int64(1717000000123),
+		"open":          188.2,
+		"high":          191.0,
+		"low":           187.5,
+		"prevClose":     188.0,
+		"change":        2.1,
+		"changePercent": 1.12,
+	}
+	if len(m) != len(want) {
+		t.Errorf("quoteMsg has %d keys, want %d: %v", len(m), len(want), m)
+	}
+	for k, v := range want {
+		if m[k] != v {
+			t.Errorf("%s = %v (%T), want %v", k, m[k], m[k], v)
+		}
+	}
+}
+
+func TestQuoteMsgWithoutPrevClose(t *testing.T) {
+	m := quoteMsg("AAPL", &Quote{Current: 190.1, High: 191, Low: 187.5, Open: 188.2}, time.UnixMilli(1717000000123))
+	for _, k := range []string{"change", "changePercent"} {
+		if v, ok := m[k]; ok {
+			t.Errorf("%s = %v, want it left out with no previous close", k, v)
+		}
+	}
+	if m["price"] != 190.1 || m["prevClose"] != 0.0 {
+		t.Errorf("quoteMsg = %v, want the price and a zero prevClose", m)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// updateQueue is a subscriber's outbox. It holds at most one pending update
+// per symbol: pushing a newer quote for a symbol that is already queued
+// replaces it in place, so a slow consumer sees fresh data instead of a
+// backlog and memory stays bounded by the number of subscribed symbols.
+// push never blocks, so one slow client can't stall the hub.
+type updateQueue struct {
+	mu      sync.Mutex
+	pending map[string]queuedUpdate
+	order   []string // symbols with a pending update, oldest first
+
+	// ready has room for one signal and is poked on every push
+	ready chan struct{}
+}
+
+type queuedUpdate struct {
+	update quoteUpdate
+	queued time.Time // when the symbol entered the queue; kept on replace
+}
+
+func newUpdateQueue() *updateQueue {
+	return &updateQueue{
+		pending: make(map[string]queuedUpdate),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+func (q *updateQueue) push(u quoteUpdate) {
+	q.mu.Lock()
+	e, ok := q.pending[u.Symbol]
+	if !ok {
+		e.queued = time.Now()
+		q.order = append(q.order, u.Symbol)
+	} else if u.Closed == nil {
+		// Don't let coalescing swallow a completed bar
+		u.Closed = e.update.Closed
+	}
+	e.update = u
+	q.pending[u.Symbol] = e
+	q.mu.Unlock()
+	q.wake()
+}
+
+// wake pokes the consumer without queueing anything
+func (q *updateQueue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes and returns the oldest pending update
+func (q *updateQueue) pop() (quoteUpdate, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.order) == 0 {
+		return quoteUpdate{}, false
+	}
+	sym := q.order[0]
+	q.order = q.order[1:]
+	e := q.pending[sym]
+	delete(q.pending, sym)
+	return e.update, true
+}
+
+// lag is how long the oldest pending update has been waiting, zero if none
+func (q *updateQueue) lag() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.order) == 0 {
+		return 0
+	}
+	return time.Since(q.pending[q.order[0]].queued)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateQueueCoalesces(t *testing.T) {
+	bar := &Bar{Time: 1717000020, Close: 190}
+	tests := []struct {
+		name   string
+		pushes []quoteUpdate
+		want   []quoteUpdate // in pop order
+	}{
+		{
+			"one symbol keeps the newest",
+			[]quoteUpdate{{Symbol: "AAPL", Seq: 1}, {Symbol: "AAPL", Seq: 2}, {Symbol: "AAPL", Seq: 3}},
+			[]quoteUpdate{{Symbol: "AAPL", Seq: 3}},
+		},
+		{
+			"symbols keep their first-queued order",
+			[]quoteUpdate{{Symbol: "AAPL", Seq: 1}, {Symbol: "TSLA", Seq: 1}, {Symbol: "AAPL", Seq: 2}},
+			[]quoteUpdate{{Symbol: "AAPL", Seq: 2}, {Symbol: "TSLA", Seq: 1}},
+		},
+		{
+			"a closed bar survives a newer quote",
+			[]quoteUpdate{{Symbol: "AAPL", Seq: 1, Closed: bar}, {Symbol: "AAPL", Seq: 2}},
+			[]quoteUpdate{{Symbol: "AAPL", Seq: 2, Closed: bar}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newUpdateQueue()
+			for _, u := range tt.pushes {
+				q.push(u)
+			}
+			for i, want := range tt.want {
+				got, ok := q.pop()
+				if !ok || got.Symbol != want.Symbol || got.Seq != want.Seq || got.Closed != want.Closed {
+					t.Errorf("pop %d = %+v, %v; want %+v", i, got, ok, want)
+				}
+			}
+			if u, ok := q.pop(); ok {
+				t.Errorf("left over %+v", u)
+			}
+		})
+	}
+}
+
+func TestUpdateQueueLag(t *testing.T) {
+	q := newUpdateQueue()
+	if lag := q.lag(); lag != 0 {
+		t.Errorf("empty queue lag = %v, want 0", lag)
+	}
+	q.push(quoteUpdate{Symbol: "AAPL", Seq: 1})
+	time.Sleep(20 * time.Millisecond)
+	// Replacing the quote doesn't reset how long the symbol has waited
+	q.push(quoteUpdate{Symbol: "AAPL", Seq: 2})
+	if lag := q.lag(); lag < 20*time.Millisecond {
+		t.Errorf("lag = %v after a replace, want at least 20ms", lag)
+	}
+	q.pop()
+	if lag := q.lag(); lag != 0 {
+		t.Errorf("lag = %v once drained, want 0", lag)
+	}
+}
+
+func TestUpdateQueuePushNeverBlocks(t *testing.T) {
+	q := newUpdateQueue()
+	done := make(chan struct{})
+	go func() {
+		for i := range 10000 {
+			q.push(quoteUpdate{Symbol: "AAPL", Seq: uint64(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push blocked on an unread queue")
+	}
+}
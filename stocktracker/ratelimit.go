@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Calls that may go out back to back before the steady rate applies
+	finnhubBurst = 5
+
+	// Longest a call waits for a token; beyond it the call fails as
+	// rate limited instead of queueing ever longer behind the others
+	finnhubMaxWait = 5 * time.Second
+)
+
+// tokenBucket paces calls to a steady rate with a small burst. Tokens are
+// handed out as reservations, so waiters are served in arrival order.
+type tokenBucket struct {
+	interval time.Duration // one token per interval
+	burst    float64
+
+	mu     sync.Mutex
+	tokens float64 // negative when calls are queued for future tokens
+	last   time.Time
+}
+
+// newTokenBucket allows perMinute calls a minute, burst at once
+func newTokenBucket(perMinute, burst int) *tokenBucket {
+	return &tokenBucket{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until the caller may make a call. It fails with
+// ErrRateLimited, without using a token, if that would take longer than
+// maxWait or past ctx's deadline, or if ctx ends while waiting.
+func (b *tokenBucket) Wait(ctx context.Context, maxWait time.Duration) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+float64(now.Sub(b.last))/float64(b.interval))
+	b.last = now
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) * float64(b.interval))
+	}
+	deadline, ok := ctx.Deadline()
+	if wait > maxWait || (ok && now.Add(wait).After(deadline)) {
+		b.mu.Unlock()
+		return &RateLimitError{RetryAfter: wait, Local: true, Detail: fmt.Sprintf("local limit, next call in %s", wait.Round(time.Millisecond))}
+	}
+	b.tokens--
+	b.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens++ // give the reservation back
+		b.mu.Unlock()
+		return fmt.Errorf("%w: %w", ErrRateLimited, ctx.Err())
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketThrottles(t *testing.T) {
+	tests := []struct {
+		name             string
+		perMinute, burst int
+		calls            int
+		want             time.Duration // least time the calls may take
+	}{
+		{"within the burst", 6000, 5, 5, 0},
+		{"past the burst", 6000, 5, 15, 10 * 10 * time.Millisecond},
+		{"no burst", 12000, 1, 11, 10 * 5 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.perMinute, tt.burst)
+			start := time.Now()
+			var wg sync.WaitGroup
+			for range tt.calls {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := b.Wait(context.Background(), time.Minute); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			// Allow for the refill during the first call
+			if d := time.Since(start); d < tt.want-b.interval || d > tt.want+time.Second {
+				t.Errorf("%d calls took %s, want about %s", tt.calls, d, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketRefusals(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxWait  time.Duration
+		deadline time.Duration // 0: none
+	}{
+		{"longer than maxWait", 10 * time.Millisecond, 0},
+		{"past the deadline", time.Minute, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(60, 1) // the second call waits a second
+			if err := b.Wait(context.Background(), 0); err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+			for range 2 { // a refusal doesn't use up a token
+				start := time.Now()
+				err := b.Wait(ctx, tt.maxWait)
+				var rl *RateLimitError
+				if !errors.As(err, &rl) || !errors.Is(err, ErrRateLimited) || !rl.Local {
+					t.Fatalf("Wait = %v, want a local RateLimitError", err)
+				}
+				if rl.RetryAfter < 900*time.Millisecond || rl.RetryAfter > time.Second {
+					t.Errorf("RetryAfter %s, want about 1s", rl.RetryAfter)
+				}
+				if d := time.Since(start); d > 100*time.Millisecond {
+					t.Errorf("refusal took %s, want it at once", d)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketCancelGivesTokenBack(t *testing.T) {
+	b := newTokenBucket(600, 1) // a token every 100ms
+	b.Wait(context.Background(), 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := b.Wait(ctx, time.Second); !errors.Is(err, ErrRateLimited) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait = %v, want rate limited by the cancellation", err)
+	}
+	// The abandoned reservation is back, so the next caller waits no
+	// longer than the first would have
+	start := time.Now()
+	if err := b.Wait(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("next call waited %s, want under 100ms", d)
+	}
+}
+
+func TestFinnhubLimiter(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"c":190.1,"pc":188}`))
+	}))
+	defer ts.Close()
+	fh := NewFinnhubProvider("test")
+	fh.baseURL = ts.URL
+	fh.limiter = newTokenBucket(60, 2)
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"first of the burst", false},
+		{"second of the burst", false},
+		{"throttled", true},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		_, err := fh.Quote(ctx, "AAPL")
+		cancel()
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: err = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr && !errors.Is(err, ErrRateLimited) {
+			t.Errorf("%s: err = %v, want ErrRateLimited", tt.name, err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d calls reached Finnhub, want 2", n)
+	}
+}
+
+func TestBadGateway(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantCode       int
+		wantRetryAfter string
+	}{
+		{"local limit", &RateLimitError{RetryAfter: 1500 * time.Millisecond, Local: true}, http.StatusTooManyRequests, "2"},
+		{"Finnhub 429", &RateLimitError{RetryAfter: 30 * time.Second}, http.StatusTooManyRequests, "30"},
+		{"wrapped, no hint", errors.Join(errors.New("quote"), ErrRateLimited), http.StatusTooManyRequests, ""},
+		{"too many bars", ErrTooManyBars, http.StatusBadRequest, ""},
+		{"upstream down", errors.New("connection refused"), http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			badGateway(rec, httptest.NewRequest(http.MethodGet, "/api/quote", nil), tt.err)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// How long a lookup's results are reused; listings rarely change
+	searchCacheTTL = 5 * time.Minute
+
+	// Most results /api/search returns
+	searchLimit = 20
+
+	// Longest query accepted
+	maxSearchQuery = 64
+)
+
+// GET /api/search?q=apple&type=Common+Stock
+// Looks symbols up by ticker or name through Finnhub, returning at most 20
+// matches in Finnhub's order, optionally only those of one type:
+//
+//	{"query":"apple","count":1,"results":[{"symbol":"AAPL",
+//	 "description":"APPLE INC","type":"Common Stock","displaySymbol":"AAPL"}]}
+//
+// Results are cached for five minutes per query, ignoring case, so users
+// typing the same names don't each cost a call against the Finnhub quota.
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if query == "" {
+		badRequest(w, "q is required")
+		return
+	}
+	if len(query) > maxSearchQuery {
+		badRequest(w, "q is too long")
+		return
+	}
+	typ := strings.TrimSpace(r.URL.Query().Get("type"))
+
+	matches, ok := s.searches.get(query)
+	if !ok {
+		var err error
+		if matches, err = s.provider.Search(r.Context(), query); err != nil {
+			badGateway(w, r, err)
+			return
+		}
+		s.searches.put(query, matches)
+	}
+
+	results := make([]SymbolMatch, 0, min(len(matches), searchLimit))
+	for _, m := range matches {
+		if len(results) == searchLimit {
+			break
+		}
+		if typ == "" || strings.EqualFold(m.Type, typ) {
+			results = append(results, m)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"query": query, "count": len(results), "results": results})
+}
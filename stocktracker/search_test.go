@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// searchStub is a Finnhub /search answering every query with n matches,
+// alternating stocks and ETPs, and counting the calls
+func searchStub(t *testing.T, n, status int) (*server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/search" || r.URL.Query().Get("q") == "" {
+			t.Errorf("upstream got %s", r.URL)
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		var result []map[string]any
+		for i := range n {
+			typ := "Common Stock"
+			if i%2 == 1 {
+				typ = "ETP"
+			}
+			result = append(result, map[string]any{
+				"symbol": fmt.Sprintf("S%d", i), "description": "MATCH", "type": typ,
+				"displaySymbol": fmt.Sprintf("S%d", i), "primary": []string{"extra"},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"count": n, "result": result})
+	}))
+	t.Cleanup(ts.Close)
+	fh := NewFinnhubProvider("test")
+	fh.baseURL = ts.URL
+	fh.retries = 0
+	return &server{provider: fh, searches: newTTLCache[[]SymbolMatch](searchCacheTTL, lookupCacheSize)}, &calls
+}
+
+func TestHandleSearch(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		upstream  int // matches Finnhub returns
+		status    int // of Finnhub's answer
+		wantCode  int
+		wantCount int
+	}{
+		{"some", "?q=apple", 3, http.StatusOK, http.StatusOK, 3},
+		{"none", "?q=zzzz", 0, http.StatusOK, http.StatusOK, 0},
+		{"limited", "?q=a", 50, http.StatusOK, http.StatusOK, searchLimit},
+		{"type filter", "?q=a&type=ETP", 10, http.StatusOK, http.StatusOK, 5},
+		{"type filter ignores case", "?q=a&type=common+stock", 10, http.StatusOK, http.StatusOK, 5},
+		{"type filter past the limit", "?q=a&type=ETP", 100, http.StatusOK, http.StatusOK, searchLimit},
+		{"unknown type", "?q=a&type=Bond", 10, http.StatusOK, http.StatusOK, 0},
+		{"empty", "?q=", 0, http.StatusOK, http.StatusBadRequest, 0},
+		{"blank", "?q=%20%20", 0, http.StatusOK, http.StatusBadRequest, 0},
+		{"missing", "", 0, http.StatusOK, http.StatusBadRequest, 0},
+		{"too long", "?q=" + strings.Repeat("a", maxSearchQuery+1), 0, http.StatusOK, http.StatusBadRequest, 0},
+		{"upstream down", "?q=apple", 0, http.StatusInternalServerError, http.StatusBadGateway, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := searchStub(t, tt.upstream, tt.status)
+			rec := httptest.NewRecorder()
+			s.handleSearch(rec, httptest.NewRequest(http.MethodGet, "/api/search"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var body struct {
+				Count   int
+				Results []map[string]any
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Count != tt.wantCount || len(body.Results) != tt.wantCount {
+				t.Errorf("count %d with %d results, want %d", body.Count, len(body.Results), tt.wantCount)
+			}
+			for _, m := range body.Results {
+				if len(m) != 4 || m["symbol"] == nil || m["displaySymbol"] == nil {
+					t.Errorf("result %v, want symbol, description, type and displaySymbol only", m)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleSearchCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		queries   []string
+		status    int
+		wantCalls int32
+	}{
+		{"repeat", []string{"apple", "apple"}, http.StatusOK, 1},
+		{"ignores case and spaces", []string{"Apple", "APPLE", "%20apple%20"}, http.StatusOK, 1},
+		{"type filter shares the entry", []string{"apple", "apple&type=ETP"}, http.StatusOK, 1},
+		{"different queries", []string{"apple", "tesla"}, http.StatusOK, 2},
+		{"failures are not cached", []string{"apple", "apple"}, http.StatusInternalServerError, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, calls := searchStub(t, 3, tt.status)
+			for _, q := range tt.queries {
+				s.handleSearch(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/search?q="+q, nil))
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("%d upstream calls, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+// Registers the pure Go (no cgo) SQLite driver behind quote history. It
+// is always linked; history itself stays off unless -db or QUOTE_DB is set.
+import _ "modernc.org/sqlite"
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQuoteStoreInMemory(t *testing.T) {
+	st, err := openQuoteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go st.run(ctx)
+	defer func() {
+		cancel()
+		<-st.done
+	}()
+
+	base := time.UnixMilli(1717000000000)
+	for _, u := range []quoteUpdate{
+		{Symbol: "AAPL", Quote: &Quote{Current: 190.1}, Time: base},
+		{Symbol: "TSLA", Quote: &Quote{Current: 180}, Time: base},
+		{Symbol: "AAPL", Err: context.DeadlineExceeded, Time: base.Add(time.Second)}, // failed poll
+		{Symbol: "AAPL", Quote: &Quote{}, Time: base.Add(2 * time.Second)},           // no price
+		{Symbol: "AAPL", Quote: &Quote{Current: 190.4}, Time: base.Add(3 * time.Second)},
+	} {
+		st.record(u)
+	}
+
+	want := []tick{{190.1, base.UnixMilli()}, {190.4, base.Add(3 * time.Second).UnixMilli()}}
+	var got []tick
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if got, err = st.history(ctx, "AAPL", base, 10); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == len(want) {
+			break
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("history = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("tick %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	tests := []struct {
+		since time.Time
+		limit int
+		want  int
+	}{
+		{base, 10, 2},
+		{base.Add(time.Millisecond), 10, 1},
+		{base.Add(time.Hour), 10, 0},
+		{base, 1, 1},
+	}
+	for _, tt := range tests {
+		got, err := st.history(ctx, "AAPL", tt.since, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("history(since %d, limit %d) = %d ticks, want %d", tt.since.UnixMilli(), tt.limit, len(got), tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Comment line sent on an idle /events stream so proxies keep it open
+	sseKeepalive = 15 * time.Second
+
+	// Reconnect delay suggested to EventSource clients
+	sseRetry = 3 * time.Second
+)
+
+// GET /events?symbols=AAPL,TSLA&interval=5s
+// GET /sse?symbol=AAPL
+// Server-Sent Events alternative to /ws for clients that can't use
+// WebSockets, fed by the same shared pollers. /sse is the same stream
+// under another name, and either takes ?symbol= for a single symbol:
+//
+//	id: 1717000000000
+//	event: quote
+//	data: {"symbol":"AAPL","price":190.1,"time":1717000000000,...}
+//
+// data is the /ws quote message and id is its time in milliseconds. A
+// failed fetch is sent once per run of failures as an "error" event with
+// the /ws error frame as data. On reconnect EventSource sends the last id
+// back as Last-Event-ID, and quotes no newer than it are skipped, so the
+// latest quote replayed on subscribe isn't seen twice. A ": keepalive"
+// comment goes out after 15s without events.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	symbols, err := parseSymbols(r.URL.Query().Get("symbols"))
+	if err == nil && len(symbols) == 0 {
+		symbols, err = parseSymbols(r.URL.Query().Get("symbol"))
+	}
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if len(symbols) == 0 {
+		badRequest(w, "symbols is required")
+		return
+	}
+	if len(symbols) > cfg.MaxSubscriptions {
+		badRequest(w, fmt.Sprintf("at most %d symbols per stream", cfg.MaxSubscriptions))
+		return
+	}
+	interval := cfg.PollInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := parseInterval(v)
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		interval = d
+	}
+	var lastID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		lastID, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	updates := newUpdateQueue()
+	defer s.hub.Unregister(updates)
+	for _, sym := range symbols {
+		if err := s.hub.Subscribe(sym, updates, interval); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(connRetryAfter.Seconds())))
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "symbol_limit"})
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // don't let nginx buffer the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if rc.Flush() != nil {
+		return // the writer can't stream
+	}
+
+	lastSent := make(map[string]time.Time)
+	failing := make(map[string]bool)
+	keepalive := time.NewTimer(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return // server shutting down
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+			keepalive.Reset(sseKeepalive)
+			continue
+		case <-updates.ready:
+		}
+
+		sent := false
+		for {
+			u, ok := updates.pop()
+			if !ok {
+				break
+			}
+			id := u.Time.UnixMilli()
+			var err error
+			switch {
+			case u.Err != nil:
+				if failing[u.Symbol] {
+					continue
+				}
+				failing[u.Symbol] = true
+				err = writeEvent(w, "error", 0, errorMsg(upstreamError(u.Symbol, u.Err)))
+			case id <= lastID || u.Time.Sub(lastSent[u.Symbol]) < interval*9/10:
+				continue
+			default:
+				delete(failing, u.Symbol)
+				lastSent[u.Symbol] = u.Time
+				err = writeEvent(w, "quote", id, quoteMsg(u.Symbol, u.Quote, u.Time))
+			}
+			if err != nil {
+				return
+			}
+			sent = true
+		}
+		if sent {
+			if rc.Flush() != nil {
+				return
+			}
+			keepalive.Reset(sseKeepalive)
+		}
+	}
+}
+
+// writeEvent writes one SSE frame; id 0 leaves the id line out
+func writeEvent(w io.Writer, event string, id int64, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteEvent(t *testing.T) {
+	tests := []struct {
+		event string
+		id    int64
+		v     any
+		want  string
+	}{
+		{"quote", 1717000000000, map[string]any{"symbol": "AAPL"}, "id: 1717000000000\nevent: quote\ndata: {\"symbol\":\"AAPL\"}\n\n"},
+		{"error", 0, map[string]any{"code": "upstream_error"}, "event: error\ndata: {\"code\":\"upstream_error\"}\n\n"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := writeEvent(&b, tt.event, tt.id, tt.v); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("writeEvent(%s, %d) = %q, want %q", tt.event, tt.id, b.String(), tt.want)
+		}
+	}
+}
+
+// sseEvent is one frame of an event stream, keyed by field name
+type sseEvent map[string]string
+
+// readEvent reads up to the blank line ending the next frame
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	ev := sseEvent{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return ev
+		}
+		k, v, _ := strings.Cut(line, ":")
+		ev[k] = strings.TrimPrefix(v, " ")
+	}
+}
+
+// eventsServer serves /events from a hub polling with fetch
+func eventsServer(t *testing.T, fetch func(context.Context, string) (*Quote, error)) (*server, *httptest.Server) {
+	t.Helper()
+	s := &server{hub: newHub(fetch), done: make(chan struct{})}
+	ts := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func TestEventsBadRequests(t *testing.T) {
+	_, ts := eventsServer(t, (&countingFetch{}).fetch)
+	many := make([]string, cfg.MaxSubscriptions+1)
+	for i := range many {
+		many[i] = "S" + strconv.Itoa(i)
+	}
+	tests := []struct {
+		name, query string
+	}{
+		{"no symbols", ""},
+		{"blank symbols", "?symbols=,"},
+		{"bad symbol", "?symbols=AAPL,$$$"},
+		{"too many", "?symbols=" + strings.Join(many, ",")},
+		{"bad interval", "?symbols=AAPL&interval=soon"},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + "/events" + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tt.name, resp.StatusCode)
+		}
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	tests := []struct {
+		name, query string
+		symbols     []string
+	}{
+		{"one symbol", "?symbol=AAPL", []string{"AAPL"}},
+		{"several", "?symbols=AAPL,MSFT&interval=2s", []string{"AAPL", "MSFT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ts := eventsServer(t, (&countingFetch{}).fetch)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events"+tt.query, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type %q", ct)
+			}
+			if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+				t.Errorf("Cache-Control %q", cc)
+			}
+			r := bufio.NewReader(resp.Body)
+			if ev := readEvent(t, r); ev["retry"] != strconv.FormatInt(sseRetry.Milliseconds(), 10) {
+				t.Errorf("first frame %v, want the retry hint", ev)
+			}
+
+			seen := make(map[string]bool)
+			for len(seen) < len(tt.symbols) {
+				ev := readEvent(t, r)
+				if ev["event"] != "quote" {
+					t.Fatalf("frame %v, want a quote", ev)
+				}
+				var q map[string]any
+				if err := json.Unmarshal([]byte(ev["data"]), &q); err != nil {
+					t.Fatal(err)
+				}
+				if ev["id"] != strconv.FormatInt(int64(q["time"].(float64)), 10) {
+					t.Errorf("id %s, want the quote's time %v", ev["id"], q["time"])
+				}
+				seen[q["symbol"].(string)] = true
+			}
+			if len(s.hub.Subscribers()) != len(tt.symbols) {
+				t.Errorf("hub polls %v, want %v", s.hub.Subscribers(), tt.symbols)
+			}
+
+			// Hanging up ends the handler and its subscriptions
+			cancel()
+			waitFor(t, "the subscriptions to end", func() bool { return len(s.hub.Subscribers()) == 0 })
+		})
+	}
+}
+
+func TestEventsLastEventID(t *testing.T) {
+	tests := []struct {
+		name      string
+		lastID    time.Time
+		wantQuote bool
+	}{
+		{"older than the quote", time.Now().Add(-time.Hour), true},
+		{"newer than the quote", time.Now().Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := eventsServer(t, (&countingFetch{}).fetch)
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events?symbol=AAPL", nil)
+			req.Header.Set("Last-Event-ID", strconv.FormatInt(tt.lastID.UnixMilli(), 10))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			r := bufio.NewReader(resp.Body)
+			readEvent(t, r) // retry hint
+
+			// The first poll lands at once; only the deadline ends a skipped one
+			line, err := r.ReadString('\n')
+			gotQuote := err == nil && strings.HasPrefix(line, "id: ")
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal(err)
+			}
+			if gotQuote != tt.wantQuote {
+				t.Errorf("quote sent %t, want %t (read %q, %v)", gotQuote, tt.wantQuote, line, err)
+			}
+		})
+	}
+}
+
+func TestEventsEndOnShutdown(t *testing.T) {
+	s, ts := eventsServer(t, (&countingFetch{}).fetch)
+	resp, err := http.Get(ts.URL + "/events?symbol=AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	readEvent(t, r)
+	close(s.done)
+	for {
+		if _, err := r.ReadString('\n'); err != nil {
+			break // the stream ended
+		}
+	}
+	waitFor(t, "the subscription to end", func() bool { return len(s.hub.Subscribers()) == 0 })
+}
+
+func TestEventsUpstreamError(t *testing.T) {
+	_, ts := eventsServer(t, (&countingFetch{err: errors.New("upstream down")}).fetch)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events?symbol=AAPL", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	readEvent(t, r)
+	ev := readEvent(t, r)
+	if ev["event"] != "error" || ev["id"] != "" {
+		t.Fatalf("frame %v, want an error event without an id", ev)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal([]byte(ev["data"]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["code"] != codeUpstream || msg["symbol"] != "AAPL" {
+		t.Errorf("error data %v", msg)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Window /api/ws/stats averages the message rate over, in seconds
+const statsRateWindow = 10
+
+// wsSent counts frames written to /ws clients
+var wsSent rateMeter
+
+// rateMeter counts events in one-second buckets, keeping the last
+// statsRateWindow of them.
+type rateMeter struct {
+	mu      sync.Mutex
+	total   int64
+	buckets [statsRateWindow + 1]int64 // by UNIX second, modulo the length
+	stamps  [statsRateWindow + 1]int64 // the second each bucket counts
+}
+
+func (m *rateMeter) add(at time.Time) {
+	sec := at.Unix()
+	i := sec % int64(len(m.buckets))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stamps[i] != sec {
+		m.stamps[i], m.buckets[i] = sec, 0
+	}
+	m.buckets[i]++
+	m.total++
+}
+
+// rate is the average per second over the last statsRateWindow complete
+// seconds before now, and the all-time total.
+func (m *rateMeter) rate(now time.Time) (perSecond float64, total int64) {
+	cur := now.Unix()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for i, sec := range m.stamps {
+		if sec < cur && sec >= cur-statsRateWindow {
+			n += m.buckets[i]
+		}
+	}
+	return float64(n) / statsRateWindow, m.total
+}
+
+// stats describes the connection for /api/ws/stats
+func (c *wsClient) stats() map[string]any {
+	c.mu.Lock()
+	symbols := make([]string, 0, len(c.subs))
+	for s := range c.subs {
+		symbols = append(symbols, s)
+	}
+	c.mu.Unlock()
+	slices.Sort(symbols)
+
+	st := map[string]any{
+		"remote":      c.remote,
+		"connectedAt": c.connected.UnixMilli(),
+		"symbols":     symbols,
+		"lastSend":    nil,
+	}
+	if ns := c.lastSend.Load(); ns != 0 {
+		st["lastSend"] = time.Unix(0, ns).UnixMilli()
+	}
+	return st
+}
+
+// GET /api/ws/stats
+// Describes the live WebSocket traffic; times are UNIX milliseconds and
+// lastSend is null until the first frame goes out:
+//
+//	{"connections":2,"messagesPerSecond":3.4,"messagesSent":1523,
+//	 "symbols":{"AAPL":2,"TSLA":1},
+//	 "clients":[{"remote":"10.0.0.5:51234","connectedAt":1717000000000,
+//	   "symbols":["AAPL","TSLA"],"lastSend":1717000042000}]}
+//
+// symbols counts the subscribers of every polled symbol, including
+// portfolio and alert watchers; messagesPerSecond averages the last 10s.
+func (s *server) handleWSStats(w http.ResponseWriter, r *http.Request) {
+	s.conns.mu.Lock()
+	clients := make([]*wsClient, 0, len(s.conns.m))
+	for c := range s.conns.m {
+		clients = append(clients, c)
+	}
+	s.conns.mu.Unlock()
+	slices.SortFunc(clients, func(a, b *wsClient) int { return a.connected.Compare(b.connected) })
+
+	list := make([]map[string]any, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c.stats())
+	}
+	perSecond, total := wsSent.rate(time.Now())
+	writeJSON(w, http.StatusOK, map[string]any{
+		"connections":       len(clients),
+		"messagesPerSecond": perSecond,
+		"messagesSent":      total,
+		"symbols":           s.hub.Subscribers(),
+		"clients":           list,
+	})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	finnhubStreamURL = "wss://ws.finnhub.io"
+
+	// Reconnect backoff bounds
+	streamMinBackoff = 1 * time.Second
+	streamMaxBackoff = 1 * time.Minute
+
+	// Finnhub pings regularly; silence this long means the socket is dead
+	streamReadTimeout = 2 * time.Minute
+
+	// Bound on subscribe writes to the upstream socket
+	streamWriteWait = 5 * time.Second
+)
+
+// finnhubStream keeps one WebSocket open to Finnhub's trade feed and
+// reports every trade for the symbols it is asked to watch. The wanted set
+// survives reconnects, so a new connection resubscribes everything.
+type finnhubStream struct {
+	url     string
+	onTrade func(symbol string, price float64, at time.Time)
+
+	mu        sync.Mutex
+	want      map[string]bool
+	connected bool
+	since     time.Time     // when connected last changed
+	failures  int           // reconnect attempts since the socket was last up
+	lastErr   error         // why the last connection ended
+	dirty     chan struct{} // poked when want changes
+}
+
+// streamState is what /healthz shows of the upstream socket
+type streamState struct {
+	State    string    `json:"state"` // "connected" or "disconnected"
+	Since    time.Time `json:"since"`
+	Attempts int       `json:"reconnectAttempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Upstream message: {"type":"trade","data":[{"s":"AAPL","p":190.1,"t":1717000000000,"v":100}]}
+type streamMsg struct {
+	Type string `json:"type"` // "trade", "ping" or "error"
+	Msg  string `json:"msg"`
+	Data []struct {
+		Symbol string  `json:"s"`
+		Price  float64 `json:"p"`
+		Time   int64   `json:"t"` // UNIX ms
+	} `json:"data"`
+}
+
+func newFinnhubStream(apiKey string, onTrade func(string, float64, time.Time)) *finnhubStream {
+	return &finnhubStream{
+		url:     finnhubStreamURL + "?token=" + url.QueryEscape(apiKey),
+		onTrade: onTrade,
+		want:    make(map[string]bool),
+		dirty:   make(chan struct{}, 1),
+	}
+}
+
+func (s *finnhubStream) Subscribe(symbol string)   { s.set(symbol, true) }
+func (s *finnhubStream) Unsubscribe(symbol string) { s.set(symbol, false) }
+
+func (s *finnhubStream) set(symbol string, on bool) {
+	s.mu.Lock()
+	if on {
+		s.want[symbol] = true
+	} else {
+		delete(s.want, symbol)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// Connected reports whether the upstream socket is currently up.
+func (s *finnhubStream) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+// State reports the upstream socket's state for /healthz.
+func (s *finnhubStream) State() streamState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := streamState{State: "disconnected", Since: s.since, Attempts: s.failures}
+	if s.connected {
+		st.State = "connected"
+	}
+	if s.lastErr != nil && !s.connected {
+		st.Error = s.lastErr.Error()
+	}
+	return st
+}
+
+// run connects and reconnects with exponential backoff until ctx is done.
+func (s *finnhubStream) run(ctx context.Context) {
+	backoff := streamMinBackoff
+	for {
+		up, err := s.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if up {
+			backoff = streamMinBackoff
+		}
+		attempt := s.failed(err)
+		// Full jitter so restarts don't reconnect in lockstep
+		wait := backoff/2 + rand.N(backoff/2+1)
+		slog.Warn("stream disconnected", "err", err, "attempt", attempt, "retry_in_ms", wait.Milliseconds())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, streamMaxBackoff)
+	}
+}
+
+// session runs one upstream connection until it fails. up reports
+// whether the dial succeeded, which resets the backoff.
+func (s *finnhubStream) session(ctx context.Context) (up bool, err error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	slog.Info("stream connected")
+
+	s.setConnected(true)
+	defer s.setConnected(false)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close() // unblocks the read loop
+	}()
+	go s.syncSubscriptions(ctx, conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		var msg streamMsg
+		if err := conn.ReadJSON(&msg); err != nil {
+			return true, err
+		}
+		switch msg.Type {
+		case "trade":
+			for _, t := range msg.Data {
+				s.onTrade(t.Symbol, t.Price, time.UnixMilli(t.Time))
+			}
+		case "error":
+			slog.Warn("stream upstream error", "msg", msg.Msg)
+		}
+	}
+}
+
+// syncSubscriptions sends subscribe/unsubscribe messages until the
+// connection's subscriptions match the wanted set, then waits for changes.
+func (s *finnhubStream) syncSubscriptions(ctx context.Context, conn *websocket.Conn) {
+	sent := make(map[string]bool) // fresh connection: nothing subscribed yet
+	for {
+		s.mu.Lock()
+		var add, drop []string
+		for sym := range s.want {
+			if !sent[sym] {
+				add = append(add, sym)
+			}
+		}
+		for sym := range sent {
+			if !s.want[sym] {
+				drop = append(drop, sym)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, sym := range add {
+			if !s.send(conn, "subscribe", sym) {
+				return
+			}
+			sent[sym] = true
+		}
+		for _, sym := range drop {
+			if !s.send(conn, "unsubscribe", sym) {
+				return
+			}
+			delete(sent, sym)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.dirty:
+		}
+	}
+}
+
+// send writes one control message; only syncSubscriptions writes to conn
+func (s *finnhubStream) send(conn *websocket.Conn, typ, symbol string) bool {
+	conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	if err := conn.WriteJSON(map[string]string{"type": typ, "symbol": symbol}); err != nil {
+		slog.Warn("stream send failed", "type", typ, "symbol", symbol, "err", err)
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+func (s *finnhubStream) setConnected(up bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if up != s.connected {
+		s.since = time.Now()
+	}
+	s.connected = up
+	if up {
+		s.failures, s.lastErr = 0, nil
+	}
+}
+
+// failed records a session that ended with err and returns the number of
+// the reconnect attempt about to be made.
+func (s *finnhubStream) failed(err error) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures++
+	s.lastErr = err
+	if s.since.IsZero() {
+		s.since = time.Now()
+	}
+	return s.failures
+}
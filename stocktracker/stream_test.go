@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeUpstream is a stand-in for Finnhub's trade socket. It reports each
+// accepted connection and every control message received on one.
+type fakeUpstream struct {
+	url   string
+	conns chan *websocket.Conn
+	msgs  chan map[string]string
+}
+
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+	f := &fakeUpstream{
+		conns: make(chan *websocket.Conn, 10),
+		msgs:  make(chan map[string]string, 100),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		f.conns <- conn
+		for {
+			var m map[string]string
+			if err := conn.ReadJSON(&m); err != nil {
+				return
+			}
+			f.msgs <- m
+		}
+	}))
+	t.Cleanup(ts.Close)
+	f.url = "ws" + strings.TrimPrefix(ts.URL, "http")
+	return f
+}
+
+// next returns the next control message, failing the test after a while
+func (f *fakeUpstream) next(t *testing.T) map[string]string {
+	t.Helper()
+	select {
+	case m := <-f.msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no control message from the stream")
+		return nil
+	}
+}
+
+func (f *fakeUpstream) accept(t *testing.T) *websocket.Conn {
+	t.Helper()
+	select {
+	case conn := <-f.conns:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream never connected")
+		return nil
+	}
+}
+
+// runStream starts s against up, stopping it when the test ends
+func runStream(t *testing.T, s *finnhubStream, up *fakeUpstream) {
+	s.url = up.url
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestStreamSubscriptions(t *testing.T) {
+	sub := func(sym string) map[string]string { return map[string]string{"type": "subscribe", "symbol": sym} }
+	unsub := func(sym string) map[string]string { return map[string]string{"type": "unsubscribe", "symbol": sym} }
+	tests := []struct {
+		name   string
+		before []string               // subscribed before connecting
+		then   func(s *finnhubStream) // once those went out
+		want   []map[string]string
+	}{
+		{"wanted symbols are sent on connect", []string{"AAPL"}, nil, []map[string]string{sub("AAPL")}},
+		{"subscribe while connected", nil, func(s *finnhubStream) { s.Subscribe("TSLA") }, []map[string]string{sub("TSLA")}},
+		{"unsubscribe while connected", []string{"AAPL"}, func(s *finnhubStream) { s.Unsubscribe("AAPL") }, []map[string]string{sub("AAPL"), unsub("AAPL")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := newFakeUpstream(t)
+			s := newFinnhubStream("test", func(string, float64, time.Time) {})
+			for _, sym := range tt.before {
+				s.Subscribe(sym)
+			}
+			runStream(t, s, up)
+			up.accept(t)
+			waitFor(t, "the stream to connect", s.Connected)
+
+			for i, want := range tt.want {
+				if i == len(tt.before) && tt.then != nil {
+					tt.then(s)
+				}
+				if got := up.next(t); got["type"] != want["type"] || got["symbol"] != want["symbol"] {
+					t.Errorf("control message %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamTrades(t *testing.T) {
+	type trade struct {
+		symbol string
+		price  float64
+		at     time.Time
+	}
+	got := make(chan trade, 10)
+	up := newFakeUpstream(t)
+	s := newFinnhubStream("test", func(symbol string, price float64, at time.Time) {
+		got <- trade{symbol, price, at}
+	})
+	runStream(t, s, up)
+	conn := up.accept(t)
+
+	frames := []string{
+		`{"type":"ping"}`,
+		`{"type":"error","msg":"Subscribing to too many symbols"}`,
+		`{"type":"trade","data":[{"s":"AAPL","p":190.1,"t":1717000000123,"v":100},{"s":"TSLA","p":180.5,"t":1717000000456,"v":5}]}`,
+	}
+	for _, f := range frames {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []trade{
+		{"AAPL", 190.1, time.UnixMilli(1717000000123)},
+		{"TSLA", 180.5, time.UnixMilli(1717000000456)},
+	}
+	for _, w := range want {
+		select {
+		case tr := <-got:
+			if tr.symbol != w.symbol || tr.price != w.price || !tr.at.Equal(w.at) {
+				t.Errorf("trade %+v, want %+v", tr, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("trade %+v never reported", w)
+		}
+	}
+}
+
+func TestStreamReconnectResubscribes(t *testing.T) {
+	up := newFakeUpstream(t)
+	s := newFinnhubStream("test", func(string, float64, time.Time) {})
+	s.Subscribe("AAPL")
+	runStream(t, s, up)
+	conn := up.accept(t)
+	if m := up.next(t); m["symbol"] != "AAPL" {
+		t.Fatalf("first connection sent %v", m)
+	}
+
+	// Finnhub drops the socket; the stream reports it and comes back
+	conn.Close()
+	waitFor(t, "the drop to be noticed", func() bool { return s.State().Attempts == 1 })
+	if st := s.State(); st.State != "disconnected" || st.Attempts != 1 || st.Error == "" {
+		t.Errorf("state after the drop = %+v", st)
+	}
+	up.accept(t)
+	if m := up.next(t); m["type"] != "subscribe" || m["symbol"] != "AAPL" {
+		t.Errorf("reconnect sent %v, want AAPL resubscribed", m)
+	}
+	waitFor(t, "the stream to reconnect", s.Connected)
+	if st := s.State(); st.Attempts != 0 || st.Error != "" {
+		t.Errorf("state after reconnecting = %+v", st)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// symbolPattern admits tickers (AAPL, BRK.B, RDS-A) and exchange-prefixed
+// pairs (BINANCE:BTCUSDT, OANDA:EUR_USD), and nothing that could change the
+// shape of an upstream URL.
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._:\-]{0,19}$`)
+
+var errSymbolRequired = errors.New("symbol is required")
+
+// normalizeSymbol trims and uppercases s and checks it is a plausible
+// symbol. Every symbol taken from a client goes through it.
+func normalizeSymbol(s string) (string, error) {
+	sym := strings.ToUpper(strings.TrimSpace(s))
+	if sym == "" {
+		return "", errSymbolRequired
+	}
+	if !symbolPattern.MatchString(sym) {
+		return "", fmt.Errorf("invalid symbol %q", s)
+	}
+	return sym, nil
+}
+
+// querySymbol is the normalized ?symbol= of r
+func querySymbol(r *http.Request) (string, error) {
+	return normalizeSymbol(r.URL.Query().Get("symbol"))
+}
+
+// parseSymbols splits a comma-separated symbol list, dropping blanks
+func parseSymbols(s string) ([]string, error) {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		sym, err := normalizeSymbol(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sym)
+	}
+	return out, nil
+}
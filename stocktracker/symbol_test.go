@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNormalizeSymbol(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"AAPL", "AAPL", false},
+		{"aapl", "AAPL", false},
+		{"  tsla\t", "TSLA", false},
+		{"BRK.B", "BRK.B", false},
+		{"RDS-A", "RDS-A", false},
+		{"BINANCE:BTCUSDT", "BINANCE:BTCUSDT", false},
+		{"binance:btcusdt", "BINANCE:BTCUSDT", false},
+		{"OANDA:EUR_USD", "OANDA:EUR_USD", false},
+		{"7203.T", "7203.T", false},
+		{strings.Repeat("A", 20), strings.Repeat("A", 20), false},
+		{strings.Repeat("A", 21), "", true},
+		{"", "", true},
+		{"   ", "", true},
+		{"../../x", "", true},
+		{"AAPL/../quote", "", true},
+		{"AAPL&token=x", "", true},
+		{"AAPL?x=1", "", true},
+		{"AAPL#", "", true},
+		{"AA PL", "", true},
+		{"%2e%2e", "", true},
+		{"AAPL\n", "AAPL", false}, // trailing whitespace is trimmed
+		{"AAPL\x00", "", true},
+		{".AAPL", "", true},
+		{":BTC", "", true},
+		{"-A", "", true},
+		{"ÄAPL", "", true},
+		{"<script>", "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeSymbol(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeSymbol(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeSymbol(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if _, err := normalizeSymbol(" "); !errors.Is(err, errSymbolRequired) {
+		t.Errorf("blank symbol: %v, want errSymbolRequired", err)
+	}
+}
+
+func TestParseSymbols(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"aapl", []string{"AAPL"}, false},
+		{"AAPL, tsla ,,BINANCE:BTCUSDT", []string{"AAPL", "TSLA", "BINANCE:BTCUSDT"}, false},
+		{",,", nil, false},
+		{"AAPL,../x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSymbols(tt.in)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseSymbols(%q) = %q, %v; want %q, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHandlersRejectBadSymbols(t *testing.T) {
+	s := &server{provider: fakeFinnhub(t, http.StatusOK, `{"c":1}`)}
+	handlers := map[string]http.HandlerFunc{
+		"/api/quote":   s.handleQuote,
+		"/api/candles": s.handleCandles,
+	}
+	for path, h := range handlers {
+		for _, sym := range []string{"", "../../x", "AAPL&token=x", "A B"} {
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodGet, path+"?symbol="+url.QueryEscape(sym), nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s?symbol=%q: status %d, want 400", path, sym, rec.Code)
+			}
+		}
+	}
+
+	_, wsURL := wsServer(t, (&countingFetch{}).fetch)
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?symbols=AAPL,..%2F..%2Fx", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("/ws with a bad symbol: %v, want 400", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"stocker/indicators"
+)
+
+// GET /api/vwap?symbol=TSLA&date=2024-06-07
+// The volume-weighted average price over one regular session (9:30-16:00
+// New York time) of 1-minute candles: the final value, and the running
+// value after each candle in t. date is the session's New York date and
+// defaults to today, for which the session so far is used.
+//
+//	{"symbol":"TSLA","date":"2024-06-07","vwap":177.9,"volume":6.1e7,
+//	 "t":[...],"series":[...]}
+//
+// A session with no candles answers 404 no_data, one with no volume 422
+// no_volume.
+func (s *server) handleVWAP(w http.ResponseWriter, r *http.Request) {
+	symbol, err := querySymbol(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	from, to, err := sessionWindow(r.URL.Query().Get("date"), time.Now())
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	c := &Candles{}
+	if to.After(from) { // else today's session hasn't opened yet
+		if c, err = s.provider.Candles(r.Context(), symbol, from, to, "1"); err != nil {
+			badGateway(w, r, err)
+			return
+		}
+	}
+	n := c.Len()
+	if c.S != "ok" || n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no_data"})
+		return
+	}
+	c = c.head(n)
+	var volume float64
+	for _, v := range c.Volume {
+		volume += v
+	}
+	if volume == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "no_volume"})
+		return
+	}
+	series := indicators.VWAP(c.High, c.Low, c.Close, c.Volume)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"symbol": symbol,
+		"date":   from.Format(time.DateOnly),
+		"vwap":   series[n-1],
+		"volume": volume,
+		"t":      c.Time,
+		"series": series,
+	})
+}
+
+// sessionWindow is the regular session on dateStr (YYYY-MM-DD, New York
+// time; today if empty), cut off at now.
+func sessionWindow(dateStr string, now time.Time) (from, to time.Time, err error) {
+	local := now.In(exchangeTZ)
+	y, m, d := local.Date()
+	date := time.Date(y, m, d, 0, 0, 0, 0, exchangeTZ)
+	if dateStr != "" {
+		if date, err = time.ParseInLocation(time.DateOnly, dateStr, exchangeTZ); err != nil {
+			return from, to, errors.New("date must be like 2024-06-07")
+		}
+	}
+	if date.After(local) {
+		return from, to, errors.New("date must not be in the future")
+	}
+	from = date.Add(marketOpensAt)
+	to = date.Add(marketClosesAt)
+	if to.After(now) {
+		to = now
+	}
+	return from, to, nil
+}
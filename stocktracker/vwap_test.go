@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionWindow(t *testing.T) {
+	now := ny("2024-06-07 11:00") // a Friday, mid-session
+	tests := []struct {
+		date     string
+		from, to time.Time
+		wantErr  bool
+	}{
+		{"", ny("2024-06-07 09:30"), now, false}, // today, so far
+		{"2024-06-06", ny("2024-06-06 09:30"), ny("2024-06-06 16:00"), false},
+		{"2024-01-05", ny("2024-01-05 09:30"), ny("2024-01-05 16:00"), false}, // EST
+		{"2024-06-08", time.Time{}, time.Time{}, true},
+		{"06/06/2024", time.Time{}, time.Time{}, true},
+		{"yesterday", time.Time{}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		from, to, err := sessionWindow(tt.date, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("sessionWindow(%q) error = %v, want error %t", tt.date, err, tt.wantErr)
+			continue
+		}
+		if !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("sessionWindow(%q) = %v..%v, want %v..%v", tt.date, from, to, tt.from, tt.to)
+		}
+	}
+
+	// Before today's open the window is empty
+	from, to, err := sessionWindow("", ny("2024-06-07 08:00"))
+	if err != nil || to.After(from) {
+		t.Errorf("before the open: %v..%v, %v; want an empty window", from, to, err)
+	}
+}
+
+func TestHandleVWAP(t *testing.T) {
+	session := &Candles{
+		S:      "ok",
+		Time:   []int64{1717767000, 1717767060, 1717767120},
+		Open:   []float64{9, 13, 12},
+		High:   []float64{12, 13, 14},
+		Low:    []float64{9, 10, 10},
+		Close:  []float64{9, 13, 12},
+		Volume: []float64{100, 300, 100},
+	}
+	quiet := &Candles{S: "ok", Time: []int64{1717767000}, Open: []float64{1}, High: []float64{1}, Low: []float64{1}, Close: []float64{1}, Volume: []float64{0}}
+	tests := []struct {
+		name     string
+		query    string
+		candles  *Candles
+		err      error
+		wantCode int
+		wantVWAP float64
+	}{
+		{"session", "symbol=TSLA&date=2024-06-07", session, nil, http.StatusOK, 11.6},
+		{"no data", "symbol=TSLA&date=2024-06-07", &Candles{S: "no_data"}, nil, http.StatusNotFound, 0},
+		{"no volume", "symbol=TSLA&date=2024-06-07", quiet, nil, http.StatusUnprocessableEntity, 0},
+		{"upstream down", "symbol=TSLA&date=2024-06-07", nil, errors.New("down"), http.StatusBadGateway, 0},
+		{"bad date", "symbol=TSLA&date=June", session, nil, http.StatusBadRequest, 0},
+		{"future date", "symbol=TSLA&date=2999-01-01", session, nil, http.StatusBadRequest, 0},
+		{"missing symbol", "date=2024-06-07", session, nil, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var from, to time.Time
+			var resolution string
+			s := &server{provider: &stubProvider{candles: func(ctx context.Context, symbol string, f, e time.Time, res string) (*Candles, error) {
+				from, to, resolution = f, e, res
+				return tt.candles, tt.err
+			}}}
+			rec := httptest.NewRecorder()
+			s.handleVWAP(rec, httptest.NewRequest(http.MethodGet, "/api/vwap?"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if !from.Equal(ny("2024-06-07 09:30")) || !to.Equal(ny("2024-06-07 16:00")) || resolution != "1" {
+				t.Errorf("fetched %v..%v at %q, want the regular session in 1-minute bars", from, to, resolution)
+			}
+			var body struct {
+				Date   string    `json:"date"`
+				VWAP   float64   `json:"vwap"`
+				Volume float64   `json:"volume"`
+				T      []int64   `json:"t"`
+				Series []float64 `json:"series"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Date != "2024-06-07" || body.VWAP != tt.wantVWAP || body.Volume != 500 {
+				t.Errorf("date %s, vwap %v, volume %v; want 2024-06-07, %v, 500", body.Date, body.VWAP, body.Volume, tt.wantVWAP)
+			}
+			if len(body.T) != 3 || len(body.Series) != 3 || body.Series[0] != 10 || body.Series[1] != 11.5 {
+				t.Errorf("t %v, series %v; want the running VWAP per candle", body.T, body.Series)
+			}
+		})
+	}
+}
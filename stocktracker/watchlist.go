@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Most symbols the watchlist holds
+const maxWatchlist = 200
+
+var errWatchlistFull = fmt.Errorf("watchlist is full (%d symbols)", maxWatchlist)
+
+// watchlist is a list of symbols kept in a JSON file, so it survives
+// restarts. Every change rewrites the file through a temporary one and a
+// rename, so a crash mid-write leaves the previous list intact.
+type watchlist struct {
+	path string
+
+	mu      sync.Mutex
+	symbols []string // in the order added
+}
+
+// openWatchlist loads the list at path; a missing file is an empty list.
+func openWatchlist(path string) (*watchlist, error) {
+	wl := &watchlist{path: path, symbols: []string{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return wl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &wl.symbols); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return wl, nil
+}
+
+func (wl *watchlist) list() []string {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	return slices.Clone(wl.symbols)
+}
+
+// add appends symbol, reporting false if it was already listed
+func (wl *watchlist) add(symbol string) (bool, error) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	if slices.Contains(wl.symbols, symbol) {
+		return false, nil
+	}
+	if len(wl.symbols) >= maxWatchlist {
+		return false, errWatchlistFull
+	}
+	next := append(slices.Clone(wl.symbols), symbol)
+	if err := wl.saveLocked(next); err != nil {
+		return false, err
+	}
+	wl.symbols = next
+	return true, nil
+}
+
+// remove drops symbol, reporting false if it wasn't listed
+func (wl *watchlist) remove(symbol string) (bool, error) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	i := slices.Index(wl.symbols, symbol)
+	if i < 0 {
+		return false, nil
+	}
+	next := slices.Delete(slices.Clone(wl.symbols), i, i+1)
+	if err := wl.saveLocked(next); err != nil {
+		return false, err
+	}
+	wl.symbols = next
+	return true, nil
+}
+
+// saveLocked writes symbols to the file, atomically. Must be called with
+// wl.mu held.
+func (wl *watchlist) saveLocked(symbols []string) error {
+	data, err := json.MarshalIndent(symbols, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(wl.path), ".watchlist-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), wl.path)
+}
+
+// ---------------- HTTP Handlers ----------------
+
+// GET /api/watchlist
+// POST /api/watchlist {"symbol":"AAPL"}
+// DELETE /api/watchlist?symbol=AAPL
+// The saved symbol list, in the order added; every call answers with it:
+//
+//	{"symbols":["AAPL","TSLA"]}
+//
+// A POST that adds the symbol answers 201, one for a listed symbol 200;
+// removing an unlisted symbol is not an error. Answers 404 when the server
+// runs without -watchlist.
+func (s *server) handleWatchlist(w http.ResponseWriter, r *http.Request) {
+	if s.watchlist == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "watchlist_disabled"})
+		return
+	}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodPost:
+		var body struct {
+			Symbol string `json:"symbol"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			badRequest(w, "malformed request body")
+			return
+		}
+		symbol, err := normalizeSymbol(body.Symbol)
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		added, err := s.watchlist.add(symbol)
+		if err != nil {
+			s.watchlistError(w, r, err)
+			return
+		}
+		if added {
+			status = http.StatusCreated
+		}
+	case http.MethodDelete:
+		symbol, err := querySymbol(r)
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		if _, err := s.watchlist.remove(symbol); err != nil {
+			s.watchlistError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, status, map[string]any{"symbols": s.watchlist.list()})
+}
+
+// watchlistError reports a full list as 409 and a failed save as 500
+func (s *server) watchlistError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errWatchlistFull) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	logFrom(r.Context()).Error("watchlist save failed", "err", err)
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "watchlist_save_failed"})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// callWatchlist sends method to /api/watchlist with body (POST) or query
+// (DELETE), returning the status and the symbols answered
+func callWatchlist(t *testing.T, s *server, method, arg string) (int, []string) {
+	t.Helper()
+	var req *http.Request
+	if method == http.MethodPost {
+		req = httptest.NewRequest(method, "/api/watchlist", strings.NewReader(arg))
+	} else {
+		req = httptest.NewRequest(method, "/api/watchlist"+arg, nil)
+	}
+	rec := httptest.NewRecorder()
+	s.handleWatchlist(rec, req)
+	var body struct {
+		Symbols []string `json:"symbols"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body.Symbols
+}
+
+func TestHandleWatchlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchlist.json")
+	wl, err := openWatchlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{watchlist: wl}
+
+	// Each step runs on the list the ones before it left
+	steps := []struct {
+		name     string
+		method   string
+		arg      string
+		wantCode int
+		want     []string // nil: not checked
+	}{
+		{"empty", http.MethodGet, "", http.StatusOK, []string{}},
+		{"add", http.MethodPost, `{"symbol":"AAPL"}`, http.StatusCreated, []string{"AAPL"}},
+		{"add normalized", http.MethodPost, `{"symbol":" tsla "}`, http.StatusCreated, []string{"AAPL", "TSLA"}},
+		{"add a pair", http.MethodPost, `{"symbol":"BINANCE:BTCUSDT"}`, http.StatusCreated, []string{"AAPL", "TSLA", "BINANCE:BTCUSDT"}},
+		{"duplicate", http.MethodPost, `{"symbol":"aapl"}`, http.StatusOK, []string{"AAPL", "TSLA", "BINANCE:BTCUSDT"}},
+		{"invalid symbol", http.MethodPost, `{"symbol":"../etc"}`, http.StatusBadRequest, nil},
+		{"missing symbol", http.MethodPost, `{}`, http.StatusBadRequest, nil},
+		{"malformed body", http.MethodPost, `{"symbol":`, http.StatusBadRequest, nil},
+		{"remove", http.MethodDelete, "?symbol=tsla", http.StatusOK, []string{"AAPL", "BINANCE:BTCUSDT"}},
+		{"remove unlisted", http.MethodDelete, "?symbol=MSFT", http.StatusOK, []string{"AAPL", "BINANCE:BTCUSDT"}},
+		{"remove invalid", http.MethodDelete, "?symbol=a%20b", http.StatusBadRequest, nil},
+		{"remove without symbol", http.MethodDelete, "", http.StatusBadRequest, nil},
+		{"list", http.MethodGet, "", http.StatusOK, []string{"AAPL", "BINANCE:BTCUSDT"}},
+	}
+	for _, st := range steps {
+		code, got := callWatchlist(t, s, st.method, st.arg)
+		if code != st.wantCode {
+			t.Fatalf("%s: status %d, want %d", st.name, code, st.wantCode)
+		}
+		if st.want != nil && !slices.Equal(got, st.want) {
+			t.Fatalf("%s: symbols %q, want %q", st.name, got, st.want)
+		}
+	}
+
+	// The file holds the list, with no temporary files left beside it
+	reopened, err := openWatchlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.list(); !slices.Equal(got, []string{"AAPL", "BINANCE:BTCUSDT"}) {
+		t.Errorf("reopened list %q", got)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("%d files in the directory, want only the watchlist", len(entries))
+	}
+}
+
+func TestHandleWatchlistDisabled(t *testing.T) {
+	s := &server{}
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		if code, _ := callWatchlist(t, s, method, `{"symbol":"AAPL"}`); code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", method, code)
+		}
+	}
+}
+
+func TestWatchlistFull(t *testing.T) {
+	wl, err := openWatchlist(filepath.Join(t.TempDir(), "watchlist.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range maxWatchlist {
+		wl.symbols = append(wl.symbols, fmt.Sprintf("S%d", i))
+	}
+	s := &server{watchlist: wl}
+	if code, _ := callWatchlist(t, s, http.MethodPost, `{"symbol":"AAPL"}`); code != http.StatusConflict {
+		t.Errorf("adding to a full list: status %d, want 409", code)
+	}
+	if code, _ := callWatchlist(t, s, http.MethodPost, `{"symbol":"S0"}`); code != http.StatusOK {
+		t.Errorf("re-adding to a full list: status %d, want 200", code)
+	}
+}
+
+func TestOpenWatchlist(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string // "" leaves the file missing
+		want    []string
+		wantErr bool
+	}{
+		{"missing file", "", []string{}, false},
+		{"saved list", `["AAPL","TSLA"]`, []string{"AAPL", "TSLA"}, false},
+		{"empty list", `[]`, []string{}, false},
+		{"corrupt", `["AAPL"`, nil, true},
+		{"not a list", `{"symbols":["AAPL"]}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wl, err := openWatchlist(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && !slices.Equal(wl.list(), tt.want) {
+				t.Errorf("list %q, want %q", wl.list(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchlistConcurrentAdds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchlist.json")
+	wl, err := openWatchlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := wl.add(fmt.Sprintf("S%d", i%10)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	reopened, err := openWatchlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.list(); len(got) != 10 || !slices.Equal(got, wl.list()) {
+		t.Errorf("saved %q, in memory %q; want the same 10 symbols", got, wl.list())
+	}
+}
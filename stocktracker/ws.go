@@ -0,0 +1,1362 @@
+package main
+
+import (
+	"cmp"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocols naming the /ws message schema. v1, the default, sends
+// nothing but the original bare {symbol, price, time} quotes; v2 sends
+// the full quotes tagged "type":"quote" alongside every other typed frame,
+// so clients can switch on type alone.
+const (
+	schemaV1 = "stocktracker.v1"
+	schemaV2 = "stocktracker.v2"
+)
+
+const (
+	// Symbol streamed when the client names none
+	defaultSymbol = "AAPL"
+
+	// A client that leaves updates unread for this long is disconnected
+	stallTimeout = 10 * time.Second
+
+	// Most minutes of history a subscribe snapshot may ask for
+	maxSnapshotMinutes = 1440
+
+	// Retry-After sent when the connection caps are reached
+	connRetryAfter = 30 * time.Second
+
+	// Frames other than quotes (control replies, broadcasts, alerts,
+	// heartbeats) a connection may have waiting; a client that lets this
+	// many pile up is disconnected as a slow consumer
+	sendBuffer = 128
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: checkOrigin,
+}
+
+// Control message sent by the client over /ws
+type controlMsg struct {
+	Action   string          `json:"action"` // "subscribe", "resume", "unsubscribe" or "interval"
+	Symbol   string          `json:"symbol"`
+	Candles  bool            `json:"candles"`  // subscribe: also stream live 1-minute bars
+	Interval json.RawMessage `json:"interval"` // "2s" or a number of seconds
+	Snapshot int             `json:"snapshot"` // subscribe: minutes of 1-minute bars to send first
+	Style    string          `json:"style"`    // subscribe: snapshot bars as "candles" or "heikin-ashi"
+	Holdings []holding       `json:"holdings"` // portfolio: the positions to value
+	Alerts   bool            `json:"alerts"`   // subscribe: also deliver this user's price alerts
+	Fields   []string        `json:"fields"`   // subscribe: quote fields to send; empty for all
+	Seq      uint64          `json:"seq"`      // resume: the last sequence number received
+}
+
+// Per-symbol options chosen at subscribe time
+type subOptions struct {
+	candles bool
+	fields  []string // quote fields sent besides symbol; nil for all
+	gen     uint64   // distinguishes a re-subscription from the one before
+	after   uint64   // quotes numbered up to this were delivered by a resume
+	style   string   // of the snapshot bars; empty for plain candles
+}
+
+// resumeBacklog is what a resume owes the client ahead of the symbol's
+// live quotes
+type resumeBacklog struct {
+	opts   subOptions
+	missed []quoteUpdate
+	gap    bool
+	since  uint64 // the sequence number the client resumed from
+	latest uint64
+}
+
+// sentQuote is what writePump last sent for a symbol
+type sentQuote struct {
+	gen   uint64
+	at    time.Time // quote time, not wall time
+	quote Quote
+}
+
+// wsClient is the per-connection state: the socket plus its subscriptions.
+type wsClient struct {
+	conn     *websocket.Conn
+	hub      *Hub
+	provider Provider // for subscribe snapshots
+	log      *slog.Logger
+
+	// Cancelled the moment the connection is finished, from whichever
+	// side notices first: the read pump, a failed write, or a failed ping.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// The hub fans quotes for every subscribed symbol into this queue;
+	// writePump is its only consumer.
+	updates  *updateQueue
+	schema   int                  // message schema negotiated at upgrade: 1 or 2
+	lastSent map[string]sentQuote // owned by writePump
+	failing  map[string]bool      // symbols whose last fetch failed; owned by writePump
+	dedupe   bool                 // skip quotes identical to the last one sent
+
+	// Bound on every write so a stalled peer can't block us forever
+	writeWait time.Duration
+
+	// When a quote or heartbeat last went out, in UnixNano
+	lastData atomic.Int64
+
+	// For /api/ws/stats
+	remote    string
+	connected time.Time
+	lastSend  atomic.Int64 // when any frame last went out, in UnixNano
+
+	// Every other frame is queued here; writePump is the only goroutine
+	// that writes data frames to conn.
+	send chan any
+
+	mu       sync.Mutex
+	subs     map[string]subOptions
+	interval time.Duration
+	gen      uint64
+	resumes  map[string]resumeBacklog // written by writePump before the next quote
+
+	// Streamed portfolio; its symbols share the hub subscription with subs
+	holdings       []holding
+	heldQuotes     map[string]heldQuote
+	portfolioDirty bool // recalculated since the last portfolio message
+
+	// Alert delivery: owner is the authOwner of the upgrade request; nil
+	// inbox means alerts are off.
+	owner       string
+	inbox       *alertInbox
+	alertsOptIn atomic.Bool
+}
+
+func newWSClient(ctx context.Context, conn *websocket.Conn, hub *Hub, provider Provider, interval time.Duration) *wsClient {
+	ctx, cancel := context.WithCancel(ctx)
+	return &wsClient{
+		conn:      conn,
+		hub:       hub,
+		provider:  provider,
+		log:       logFrom(ctx).With("remote", conn.RemoteAddr().String()),
+		remote:    conn.RemoteAddr().String(),
+		connected: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+		updates:   newUpdateQueue(),
+		send:      make(chan any, sendBuffer),
+		lastSent:  make(map[string]sentQuote),
+		failing:   make(map[string]bool),
+		subs:      make(map[string]subOptions),
+		resumes:   make(map[string]resumeBacklog),
+		interval:  interval,
+		writeWait: cfg.WriteWait,
+
+		heldQuotes: make(map[string]heldQuote),
+	}
+}
+
+// queue hands v to writePump without blocking. A connection whose send
+// buffer is full is closed as a slow consumer, so a broadcast never waits
+// on one peer.
+func (c *wsClient) queue(v any) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case c.send <- v:
+		return nil
+	default:
+		c.log.Warn("ws dropping slow client", "send_buffer", sendBuffer)
+		go c.closeWith(websocket.ClosePolicyViolation, errSlowConsumer.message)
+		return errSlowConsumer
+	}
+}
+
+// write sends v on the socket; only writePump calls it. Schema v1
+// clients only understand bare quotes, so typed frames (control, error,
+// alert and the rest) are dropped for them.
+func (c *wsClient) write(v any) error {
+	if m, ok := v.(map[string]any); ok && c.schema < 2 && m["type"] != nil {
+		return nil
+	}
+	now := time.Now()
+	c.conn.SetWriteDeadline(now.Add(c.writeWait))
+	if err := c.conn.WriteJSON(v); err != nil {
+		return err
+	}
+	c.lastSend.Store(now.UnixNano())
+	wsSent.add(now)
+	return nil
+}
+
+// closeReq, queued behind any pending frames, makes writePump close the
+// connection
+type closeReq struct {
+	code   int
+	reason string
+}
+
+// keepalive pings the peer every cfg.PingPeriod until the connection ends.
+// WriteControl may run concurrently with writePump, so no lock is needed.
+func (c *wsClient) keepalive() {
+	ticker := time.NewTicker(cfg.PingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeWait)); err != nil {
+				c.log.Debug("ws ping failed", "err", err)
+				c.cancel()
+				return
+			}
+		}
+	}
+}
+
+// watchdog disconnects a client whose queue has been waiting too long,
+// i.e. one that reads slower than quotes arrive.
+func (c *wsClient) watchdog() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if lag := c.updates.lag(); lag > stallTimeout {
+				c.log.Warn("ws dropping slow client", "lag_ms", lag.Milliseconds())
+				c.fail(errSlowConsumer, websocket.ClosePolicyViolation)
+				return
+			}
+		}
+	}
+}
+
+// heartbeat sends {"type":"heartbeat"} whenever no quote has gone out
+// for every (cfg.Heartbeat), so a client can tell a quiet market from a
+// wedged connection.
+func (c *wsClient) heartbeat(every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	c.lastData.Store(time.Now().UnixNano())
+	for {
+		wait := every - time.Since(time.Unix(0, c.lastData.Load()))
+		if wait <= 0 {
+			now := time.Now()
+			err := c.queue(map[string]any{
+				"type":       "heartbeat",
+				"serverTime": now.UnixMilli(),
+				"marketOpen": marketOpen(now),
+			})
+			if err != nil {
+				return
+			}
+			c.lastData.Store(now.UnixNano())
+			wait = every
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// closeWith sends a close frame and ends the connection. Closing the
+// socket also unblocks a writer stuck on a stalled peer.
+func (c *wsClient) closeWith(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.writeWait))
+	c.cancel()
+	c.conn.Close()
+}
+
+// Codes of {"type":"error"} frames, for clients to switch on. Retryable
+// ones clear up on their own; the rest need the client to change course.
+const (
+	codeBadRequest        = "bad_request"           // malformed or invalid control message
+	codeSubscriptionLimit = "subscription_limit"    // too many symbols on this connection
+	codeSymbolLimit       = "symbol_limit"          // retryable; the server is polling all the symbols it may
+	codeNotSubscribed     = "not_subscribed"        // unsubscribe of a symbol not subscribed
+	codeRateLimited       = "upstream_rate_limited" // retryable; Finnhub returned 429
+	codeUpstream          = "upstream_unavailable"  // retryable; Finnhub unreachable or failing
+	codeSlowConsumer      = "slow_consumer"         // the connection is closed with 1008
+	codeShuttingDown      = "shutting_down"         // retryable; closed with 1001
+)
+
+// wsError is an error reported to the client as an error frame
+type wsError struct {
+	code      string
+	message   string
+	symbol    string // set when the error concerns one symbol
+	limit     int    // set for the *_limit codes
+	retryable bool
+}
+
+func (e *wsError) Error() string { return e.message }
+
+var (
+	errSlowConsumer = &wsError{code: codeSlowConsumer, message: "client too slow"}
+	errShuttingDown = &wsError{code: codeShuttingDown, message: "server shutting down", retryable: true}
+)
+
+// upstreamError describes a failed quote fetch for symbol. The poller
+// retries on its next tick, so both kinds are retryable.
+func upstreamError(symbol string, err error) *wsError {
+	if errors.Is(err, ErrRateLimited) {
+		return &wsError{code: codeRateLimited, message: "upstream rate limited", symbol: symbol, retryable: true}
+	}
+	return &wsError{code: codeUpstream, message: "quote unavailable", symbol: symbol, retryable: true}
+}
+
+// sendError queues an error frame
+func (c *wsClient) sendError(err error) error {
+	return c.queue(errorMsg(err))
+}
+
+// errorMsg is the error frame for err. Errors that aren't a *wsError are
+// reported as bad_request.
+func errorMsg(err error) map[string]any {
+	var we *wsError
+	if !errors.As(err, &we) {
+		we = &wsError{code: codeBadRequest, message: err.Error()}
+	}
+	msg := map[string]any{
+		"type":      "error",
+		"code":      we.code,
+		"message":   we.message,
+		"retryable": we.retryable,
+	}
+	if we.symbol != "" {
+		msg["symbol"] = we.symbol
+	}
+	if we.limit > 0 {
+		msg["limit"] = we.limit
+	}
+	return msg
+}
+
+// fail reports an unrecoverable error, then closes the connection. The
+// frames go out behind anything already queued; if writePump can't get
+// to them in time (or the buffer is full) the connection is closed
+// without them.
+func (c *wsClient) fail(err *wsError, closeCode int) {
+	if c.queue(errorMsg(err)) == nil && c.queue(closeReq{closeCode, err.message}) == nil {
+		select {
+		case <-c.ctx.Done():
+		case <-time.After(2 * c.writeWait):
+		}
+	}
+	c.closeWith(closeCode, err.message)
+}
+
+// checkSubscribeLocked reports why symbol can't be subscribed, if it can't.
+// c.mu must be held.
+func (c *wsClient) checkSubscribeLocked(symbol string) error {
+	if c.ctx.Err() != nil {
+		return c.ctx.Err() // connection is being torn down
+	}
+	if _, ok := c.subs[symbol]; !ok && len(c.subs) >= cfg.MaxSubscriptions {
+		return &wsError{
+			code:    codeSubscriptionLimit,
+			message: fmt.Sprintf("subscription limit reached (%d)", cfg.MaxSubscriptions),
+			symbol:  symbol,
+			limit:   cfg.MaxSubscriptions,
+		}
+	}
+	return nil
+}
+
+// hubSubscribeLocked subscribes the connection to symbol on the hub, turning a
+// refusal for the server-wide symbol cap into an error frame.
+// c.mu must be held.
+func (c *wsClient) hubSubscribeLocked(symbol string) error {
+	return symbolLimitError(symbol, c.hub.Subscribe(symbol, c.updates, c.interval))
+}
+
+// symbolLimitError turns the hub's ErrSymbolLimit into an error frame
+func symbolLimitError(symbol string, err error) error {
+	if errors.Is(err, ErrSymbolLimit) {
+		return &wsError{
+			code:      codeSymbolLimit,
+			message:   fmt.Sprintf("server symbol limit reached (%d)", cfg.MaxSymbols),
+			symbol:    symbol,
+			limit:     cfg.MaxSymbols,
+			retryable: true,
+		}
+	}
+	return err
+}
+
+// subscribeWithSnapshot sends the last snapshot minutes of candles before
+// subscribing, so the snapshot always precedes the symbol's live updates.
+func (c *wsClient) subscribeWithSnapshot(symbol string, opts subOptions, snapshot int) error {
+	if snapshot > 0 {
+		c.mu.Lock()
+		err := c.checkSubscribeLocked(symbol)
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := c.sendSnapshot(symbol, snapshot, opts.style); err != nil {
+			c.cancel()
+			return err
+		}
+	}
+	return c.subscribe(symbol, opts)
+}
+
+// snapshotBar is a Bar with its volume, as sent in snapshots
+type snapshotBar struct {
+	Bar
+	Volume float64 `json:"v"`
+}
+
+// sendSnapshot writes the symbol's recent 1-minute candles, as Heikin-Ashi
+// bars if style asks. A failed or empty fetch still sends a snapshot, with
+// an empty list and its status.
+func (c *wsClient) sendSnapshot(symbol string, minutes int, style string) error {
+	minutes = min(minutes, maxSnapshotMinutes)
+	to := time.Now()
+	from := to.Add(-time.Duration(minutes) * time.Minute)
+
+	bars := []snapshotBar{}
+	status := "error"
+	cs, err := c.provider.Candles(c.ctx, symbol, from, to, "1")
+	if err != nil {
+		c.log.Warn("ws snapshot failed", "symbol", symbol, "err", err)
+	} else {
+		status = cs.S
+		if style == styleHeikinAshi {
+			cs = heikinAshi(cs)
+		}
+		for i := range cs.Len() {
+			bars = append(bars, snapshotBar{
+				Bar:    Bar{Time: cs.Time[i], Open: cs.Open[i], High: cs.High[i], Low: cs.Low[i], Close: cs.Close[i]},
+				Volume: cs.Volume[i],
+			})
+		}
+	}
+	return c.queue(map[string]any{
+		"type":    "snapshot",
+		"symbol":  symbol,
+		"status":  status,
+		"minutes": minutes,
+		"style":   cmp.Or(style, styleCandles),
+		"candles": bars,
+	})
+}
+
+// subscribe starts streaming quotes for symbol. Subscribing again only
+// updates the options.
+func (c *wsClient) subscribe(symbol string, opts subOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkSubscribeLocked(symbol); err != nil {
+		return err
+	}
+	if cur, ok := c.subs[symbol]; ok {
+		opts.gen = cur.gen
+		c.subs[symbol] = opts
+		return nil
+	}
+	if err := c.hubSubscribeLocked(symbol); err != nil {
+		return err
+	}
+	c.gen++
+	opts.gen = c.gen
+	c.subs[symbol] = opts
+	return nil
+}
+
+// resume subscribes to symbol like subscribe, first replaying the quotes
+// numbered after seq that the client missed, or a gap notice if they are
+// no longer kept.
+func (c *wsClient) resume(symbol string, opts subOptions, seq uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkSubscribeLocked(symbol); err != nil {
+		return err
+	}
+	missed, gap, latest, err := c.hub.Resume(symbol, c.updates, c.interval, seq)
+	if err != nil {
+		return symbolLimitError(symbol, err)
+	}
+	if cur, ok := c.subs[symbol]; ok {
+		opts.gen = cur.gen
+	} else {
+		c.gen++
+		opts.gen = c.gen
+	}
+	switch {
+	case len(missed) > 0:
+		opts.after = missed[len(missed)-1].Seq
+	case !gap:
+		opts.after = seq
+	}
+	c.subs[symbol] = opts
+	c.resumes[symbol] = resumeBacklog{opts: opts, missed: missed, gap: gap, since: seq, latest: latest}
+	c.updates.wake()
+	return nil
+}
+
+// setInterval changes how often this connection receives quotes
+func (c *wsClient) setInterval(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interval = d
+	c.hub.SetInterval(c.updates, d)
+}
+
+// sendInterval tells the client which interval it actually got
+func (c *wsClient) sendInterval() error {
+	c.mu.Lock()
+	d := c.interval
+	c.mu.Unlock()
+	return c.queue(map[string]any{"type": "interval", "interval": d.Milliseconds()})
+}
+
+func (c *wsClient) unsubscribe(symbol string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[symbol]; !ok {
+		return &wsError{code: codeNotSubscribed, message: "not subscribed to " + symbol, symbol: symbol}
+	}
+	if !holds(c.holdings, symbol) {
+		c.hub.Unsubscribe(symbol, c.updates)
+	}
+	delete(c.subs, symbol)
+	return nil
+}
+
+// setPortfolio replaces the streamed portfolio, subscribing to newly held
+// symbols and dropping ones no longer needed. An empty list stops it. If
+// a new symbol is refused the portfolio is left as it was.
+func (c *wsClient) setPortfolio(hs []holding) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
+	old := c.holdings
+	var added []string
+	for _, h := range hs {
+		if holds(old, h.Symbol) {
+			continue
+		}
+		// Replays the latest quote
+		if err := c.hubSubscribeLocked(h.Symbol); err != nil {
+			for _, sym := range added {
+				if _, direct := c.subs[sym]; !direct {
+					c.hub.Unsubscribe(sym, c.updates)
+				}
+			}
+			return err
+		}
+		added = append(added, h.Symbol)
+	}
+	c.holdings = hs
+	for _, h := range old {
+		if holds(hs, h.Symbol) {
+			continue
+		}
+		delete(c.heldQuotes, h.Symbol)
+		if _, direct := c.subs[h.Symbol]; !direct {
+			c.hub.Unsubscribe(h.Symbol, c.updates)
+		}
+	}
+	// Acknowledge with a message right away, even if nothing is priced yet
+	c.portfolioDirty = true
+	c.updates.wake()
+	return nil
+}
+
+func holds(hs []holding, symbol string) bool {
+	return slices.ContainsFunc(hs, func(h holding) bool { return h.Symbol == symbol })
+}
+
+// recordHeld stores u for the portfolio if its symbol is held, marking
+// the portfolio for recalculation when the position's value changed.
+func (c *wsClient) recordHeld(u quoteUpdate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !holds(c.holdings, u.Symbol) {
+		return
+	}
+	prev, seen := c.heldQuotes[u.Symbol]
+	next := heldQuote{quote: u.Quote}
+	if u.Err != nil {
+		next = heldQuote{quote: prev.quote, failed: true}
+	}
+	c.heldQuotes[u.Symbol] = next
+
+	changed := !seen || next.failed != prev.failed ||
+		(next.quote != prev.quote && (prev.quote == nil || quoteChanged(prev.quote, next.quote)))
+	if changed || !c.dedupe {
+		c.portfolioDirty = true
+	}
+}
+
+// flushPortfolio sends the portfolio if it was recalculated
+func (c *wsClient) flushPortfolio() error {
+	c.mu.Lock()
+	if !c.portfolioDirty {
+		c.mu.Unlock()
+		return nil
+	}
+	c.portfolioDirty = false
+	msg := portfolioMsg(c.holdings, c.heldQuotes)
+	c.mu.Unlock()
+
+	now := time.Now()
+	msg["time"] = now.UnixMilli()
+	if err := c.write(msg); err != nil {
+		return err
+	}
+	c.lastData.Store(now.UnixNano())
+	return nil
+}
+
+// listenAlerts opts the connection in to its owner's alerts, once
+func (c *wsClient) listenAlerts() error {
+	if c.inbox == nil || c.alertsOptIn.Swap(true) {
+		return nil
+	}
+	return c.inbox.listen(c)
+}
+
+// close unregisters the connection from the hub
+func (c *wsClient) close() {
+	if c.inbox != nil {
+		c.inbox.forget(c)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hub.Unregister(c.updates)
+	clear(c.subs)
+}
+
+// subscription returns the options for symbol and the connection's
+// interval; ok is false if symbol is no longer subscribed.
+func (c *wsClient) subscription(symbol string) (opts subOptions, interval time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	opts, ok = c.subs[symbol]
+	return opts, c.interval, ok
+}
+
+// due reports whether an update should be written now; the shared poller
+// may be running faster than this connection asked for.
+func (c *wsClient) due(u quoteUpdate, opts subOptions, interval time.Duration) bool {
+	last, sent := c.lastSent[u.Symbol]
+	if !sent || last.gen != opts.gen {
+		return true // first quote of this subscription
+	}
+	// Allow some slack so poll jitter doesn't skip every other tick
+	elapsed := u.Time.Sub(last.at)
+	if elapsed < interval*9/10 {
+		return false
+	}
+	if !c.dedupe || elapsed >= cfg.ForceSend {
+		return true
+	}
+	return quoteChanged(&last.quote, u.Quote)
+}
+
+// quoteChanged reports whether b differs from a in any field a client
+// displays.
+func quoteChanged(a, b *Quote) bool {
+	return a.Current != b.Current || a.High != b.High || a.Low != b.Low || a.PrevClose != b.PrevClose
+}
+
+// writePump is the connection's only writer: it sends queued frames and
+// quote updates until the connection ends.
+func (c *wsClient) writePump() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case v := <-c.send:
+			if !c.writeQueued(v) {
+				return
+			}
+		case <-c.updates.ready:
+		}
+		for {
+			// Queued frames go first, so a snapshot queued before its
+			// subscription always precedes the symbol's quotes
+			if !c.drainSend() {
+				return
+			}
+			u, ok := c.updates.pop()
+			// A resume's backlog goes out ahead of any live quote
+			if !c.flushResumes() {
+				return
+			}
+			if !ok {
+				break
+			}
+			c.recordHeld(u)
+			opts, interval, ok := c.subscription(u.Symbol)
+			if !ok {
+				continue // unsubscribed while queued, or only held in the portfolio
+			}
+			if u.Err != nil {
+				// Reported once per failure streak; the poller retries on
+				// its next tick and the next quote ends the streak.
+				if !c.failing[u.Symbol] {
+					c.failing[u.Symbol] = true
+					if err := c.write(errorMsg(upstreamError(u.Symbol, u.Err))); err != nil {
+						c.log.Debug("ws send failed", "err", err)
+						c.cancel()
+						return
+					}
+				}
+				continue
+			}
+			delete(c.failing, u.Symbol)
+			// Completed bars go out even when the quote itself is throttled
+			if opts.candles && u.Closed != nil {
+				if err := c.write(barMsg("candle_closed", u.Symbol, u.Closed)); err != nil {
+					c.log.Debug("ws send failed", "err", err)
+					c.cancel()
+					return
+				}
+			}
+			if u.Seq <= opts.after || !c.due(u, opts, interval) {
+				continue
+			}
+			if err := c.writeUpdate(u, opts); err != nil {
+				c.log.Debug("ws send failed", "err", err)
+				c.cancel()
+				return
+			}
+			c.lastSent[u.Symbol] = sentQuote{gen: opts.gen, at: u.Time, quote: *u.Quote}
+		}
+		// One portfolio recalculation per drained batch
+		if err := c.flushPortfolio(); err != nil {
+			c.log.Debug("ws send failed", "err", err)
+			c.cancel()
+			return
+		}
+	}
+}
+
+// drainSend writes every queued frame, reporting false once the
+// connection is finished
+func (c *wsClient) drainSend() bool {
+	for {
+		select {
+		case v := <-c.send:
+			if !c.writeQueued(v) {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+func (c *wsClient) writeQueued(v any) bool {
+	if req, ok := v.(closeReq); ok {
+		c.closeWith(req.code, req.reason)
+		return false
+	}
+	if err := c.write(v); err != nil {
+		c.log.Debug("ws send failed", "err", err)
+		c.cancel()
+		return false
+	}
+	return true
+}
+
+// flushResumes writes every pending resume backlog, reporting false once
+// the connection is finished
+func (c *wsClient) flushResumes() bool {
+	c.mu.Lock()
+	if len(c.resumes) == 0 {
+		c.mu.Unlock()
+		return true
+	}
+	resumes := c.resumes
+	c.resumes = make(map[string]resumeBacklog)
+	c.mu.Unlock()
+
+	for symbol, b := range resumes {
+		if err := c.writeResume(symbol, b); err != nil {
+			c.log.Debug("ws send failed", "err", err)
+			c.cancel()
+			return false
+		}
+	}
+	return true
+}
+
+func (c *wsClient) writeResume(symbol string, b resumeBacklog) error {
+	if b.gap {
+		return c.write(map[string]any{"type": "gap", "symbol": symbol, "since": b.since, "latest": b.latest})
+	}
+	for _, u := range b.missed {
+		if err := c.write(c.quote(u, b.opts.fields)); err != nil {
+			return err
+		}
+		c.lastSent[symbol] = sentQuote{gen: b.opts.gen, at: u.Time, quote: *u.Quote}
+	}
+	return nil
+}
+
+// quoteFrame is the /ws message for a quote: quoteMsg plus its sequence
+// number, trimmed to fields
+func quoteFrame(u quoteUpdate, fields []string) map[string]any {
+	msg := quoteMsg(u.Symbol, u.Quote, u.Time)
+	msg["seq"] = u.Seq
+	return shapeFields(msg, fields)
+}
+
+// quote is u's frame in c's schema. v1 clients get the original bare
+// {symbol, price, time}; v2 clients get quoteFrame with its type.
+func (c *wsClient) quote(u quoteUpdate, fields []string) map[string]any {
+	if c.schema < 2 {
+		return map[string]any{"symbol": u.Symbol, "price": u.Quote.Current, "time": u.Time.UnixMilli()}
+	}
+	msg := quoteFrame(u, fields)
+	msg["type"] = "quote"
+	return msg
+}
+
+func (c *wsClient) writeUpdate(u quoteUpdate, opts subOptions) error {
+	if err := c.write(c.quote(u, opts.fields)); err != nil {
+		return err
+	}
+	c.lastData.Store(time.Now().UnixNano())
+	if opts.candles && u.Bar != nil {
+		return c.write(barMsg("candle", u.Symbol, u.Bar))
+	}
+	return nil
+}
+
+func barMsg(typ, symbol string, b *Bar) map[string]any {
+	return map[string]any{
+		"type":   typ,
+		"symbol": symbol,
+		"t":      b.Time,
+		"o":      b.Open,
+		"h":      b.High,
+		"l":      b.Low,
+		"c":      b.Close,
+	}
+}
+
+// wsConns tracks open connections so they can all be closed on shutdown,
+// and caps how many may be open at once, overall and per remote IP.
+type wsConns struct {
+	mu    sync.Mutex
+	m     map[*wsClient]struct{}
+	total int
+	perIP map[string]int
+}
+
+// reserve claims a connection slot for ip, reporting false when either
+// cap is reached. Every successful reserve must be paired with release.
+func (s *wsConns) reserve(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.total >= cfg.MaxConns || s.perIP[ip] >= cfg.MaxConnsPerIP {
+		return false
+	}
+	if s.perIP == nil {
+		s.perIP = make(map[string]int)
+	}
+	s.total++
+	s.perIP[ip]++
+	return true
+}
+
+func (s *wsConns) release(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total--
+	if s.perIP[ip]--; s.perIP[ip] <= 0 {
+		delete(s.perIP, ip)
+	}
+}
+
+// count is the number of reserved connection slots
+func (s *wsConns) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+func (s *wsConns) add(c *wsClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[*wsClient]struct{})
+	}
+	s.m[c] = struct{}{}
+}
+
+func (s *wsConns) remove(c *wsClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, c)
+}
+
+// each runs fn on every open connection concurrently, so one that is
+// slow to close can't hold up the rest, and waits for all of them.
+func (s *wsConns) each(fn func(c *wsClient)) {
+	s.mu.Lock()
+	clients := make([]*wsClient, 0, len(s.m))
+	for c := range s.m {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(c)
+		}()
+	}
+	wg.Wait()
+}
+
+// closeAll fails every open connection with err and close code, waiting
+// until each frame has been sent (or timed out).
+func (s *wsConns) closeAll(err *wsError, code int) {
+	s.each(func(c *wsClient) { c.fail(err, code) })
+}
+
+// broadcast queues v on every open connection; it never blocks on a peer
+func (s *wsConns) broadcast(v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.m {
+		c.queue(v)
+	}
+}
+
+// remoteIP is the peer address without its port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseResume reads ?resume=AAPL:41,TSLA:7 into symbol -> last sequence
+// number received
+func parseResume(s string) (map[string]uint64, error) {
+	out := make(map[string]uint64)
+	if s == "" {
+		return out, nil
+	}
+	for _, p := range strings.Split(s, ",") {
+		// The symbol itself may contain a colon (BINANCE:BTCUSDT)
+		i := strings.LastIndex(p, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid resume %q, want SYMBOL:seq", p)
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(p[i+1:]), 10, 64)
+		sym, serr := normalizeSymbol(p[:i])
+		if err != nil || serr != nil {
+			return nil, fmt.Errorf("invalid resume %q, want SYMBOL:seq", p)
+		}
+		out[sym] = n
+	}
+	return out, nil
+}
+
+// negotiateSchema picks the newest message schema the client offers among
+// its subprotocols, returning the version and the protocol to echo; with
+// none offered it is version 1 and nothing is echoed.
+func negotiateSchema(r *http.Request) (version int, protocol string) {
+	version = 1
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for p := range strings.SplitSeq(h, ",") {
+			switch strings.TrimSpace(p) {
+			case schemaV2:
+				return 2, schemaV2
+			case schemaV1:
+				protocol = schemaV1
+			}
+		}
+	}
+	return version, protocol
+}
+
+// parseInterval accepts a Go duration ("2s", "1m") or a bare number of
+// seconds ("30") and clamps it to the configured bounds.
+func parseInterval(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if n, aerr := strconv.Atoi(s); aerr == nil {
+		d, err = time.Duration(n)*time.Second, nil
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", s)
+	}
+	return min(max(d, cfg.MinPollInterval), cfg.MaxPollInterval), nil
+}
+
+// WS /ws?symbols=AAPL,TSLA&interval=2s
+// Streams quotes for every subscribed symbol, each tagged with its symbol:
+//
+//	{"symbol":"AAPL","price":190.1,"time":1717000000000,"open":189,"high":191,
+//	 "low":188.5,"prevClose":188,"change":2.1,"changePercent":1.12,"seq":42}
+//
+// change and changePercent are rounded to two decimals, and are left out
+// when the previous close is unknown (zero). GET /api/quote returns the
+// same shape, without seq.
+//
+// That shape, with "type":"quote" added, and every typed frame below are
+// schema version 2, for clients that offer the stocktracker.v2
+// subprotocol. Everyone else, including clients offering stocktracker.v1
+// or nothing, gets schema version 1: only quotes, as the original
+//
+//	{"symbol":"AAPL","price":190.1,"time":1717000000000}
+//
+// The chosen version is echoed back as the connection's protocol.
+//
+// seq numbers the server's quotes for each symbol, increasing by one per
+// quote; a connection throttled to a slower interval sees some numbers
+// skipped. After a dropped connection, reconnect with
+// ?resume=AAPL:42,TSLA:17 (or send {"action":"resume","symbol":"AAPL","seq":42})
+// to subscribe and first receive the quotes after those numbers that were
+// kept, up to 256 per symbol. When some are no longer kept, or the symbol
+// was never polled or the numbering restarted, a gap notice goes out
+// instead and the client should refetch candles:
+//
+//	{"type":"gap","symbol":"AAPL","since":42,"latest":391}
+//
+// A quote identical to the last one sent for that symbol (same price, high,
+// low and previous close) is skipped, except that one goes out at least
+// every WS_FORCE_SEND. Connect with ?dedupe=0 to get every poll regardless.
+//
+// Symbols subscribed with candles (?candles=1, or "candles":true in the
+// subscribe message) also get the in-progress 1-minute bar after each
+// quote, and a final copy when the minute rolls over:
+//
+//	{"type":"candle","symbol":"AAPL","t":1717000020,"o":190,"h":190.4,"l":189.9,"c":190.1}
+//	{"type":"candle_closed","symbol":"AAPL","t":1717000020,"o":190,"h":190.6,"l":189.9,"c":190.5}
+//
+// To receive only some fields, subscribe with ?fields=price,changePercent
+// (or "fields":["price","changePercent"] in the subscribe message); symbol
+// and seq are always included. Naming an unknown field is an error that lists the
+// valid ones:
+//
+//	{"symbol":"AAPL","price":190.1,"changePercent":1.12,"seq":42}
+//
+// To draw a chart from a single connection, subscribe with ?snapshot=60
+// (or "snapshot":60 in the subscribe message) to first receive up to that
+// many minutes of 1-minute history, capped at 1440, before any live update:
+//
+//	{"type":"snapshot","symbol":"AAPL","status":"ok","minutes":60,"style":"candles",
+//	 "candles":[{"t":1717000020,"o":190,"h":190.6,"l":189.9,"c":190.5,"v":1200},...]}
+//
+// ?style=heikin-ashi (or "style":"heikin-ashi") sends the snapshot as
+// Heikin-Ashi bars; live candles stay plain.
+//
+// status is "no_data" (or "error" if the fetch failed) with an empty list
+// when there is no history; the subscription goes ahead either way. A live
+// candle with the same t as the last snapshot bar replaces it.
+//
+// The first message is the US market status, which is pushed again to
+// every connection whenever the session changes (pre-market, regular,
+// after-hours, closed):
+//
+//	{"type":"market_status","exchange":"US","open":false,"session":"after-hours"}
+//
+// It is followed by the effective interval in milliseconds:
+//
+//	{"type":"interval","interval":2000}
+//
+// The interval defaults to the server's poll interval and is clamped to the
+// configured min/max; one that doesn't parse refuses the upgrade with 400.
+// The connection can be changed at runtime with
+// control messages:
+//
+//	{"action":"subscribe","symbol":"TSLA","candles":true,"snapshot":60}
+//	{"action":"unsubscribe","symbol":"AAPL"}
+//	{"action":"interval","interval":"10s"}
+//	{"action":"portfolio","holdings":[{"symbol":"AAPL","quantity":10,"costBasis":150}]}
+//
+// Adding "alerts":true to a subscribe message (or connecting with
+// ?alerts=1) opts the connection in to price alerts created through
+// POST /api/alerts. Each alert fires once and goes to every opted-in
+// connection of the user that created it (all of them when AUTH_TOKENS is
+// unset); ones that fire while none is open are held, up to 50, and sent
+// when the next one opts in:
+//
+//	{"type":"alert","id":"3","symbol":"AAPL","condition":"above","price":200,
+//	 "triggerPrice":200.4,"time":1717000000000}
+//
+// A control message that can't be applied (malformed JSON, unknown action,
+// missing or malformed symbol, too many subscriptions, unsubscribing a
+// symbol that isn't subscribed) leaves the connection as it was and is
+// answered with an error frame:
+//
+//	{"type":"error","code":"bad_request","message":"unknown action \"foo\"","retryable":false}
+//
+// A connection may subscribe to WS_MAX_SUBSCRIPTIONS symbols, and the
+// server polls at most MAX_SYMBOLS distinct ones across all clients (any
+// symbol already polled is always accepted). Either refusal carries the
+// limit and leaves existing subscriptions alone:
+//
+//	{"type":"error","code":"subscription_limit","message":"subscription limit reached (20)",
+//	 "symbol":"NVDA","limit":20,"retryable":false}
+//
+// code is one of the code* constants. A failed quote fetch is reported
+// the same way, with the symbol, once per run of failures; the connection
+// stays open and the next poll retries:
+//
+//	{"type":"error","code":"upstream_rate_limited","message":"upstream rate limited",
+//	 "symbol":"AAPL","retryable":true}
+//
+// When no quote has been sent for WS_HEARTBEAT (e.g. outside market
+// hours), a heartbeat goes out instead:
+//
+//	{"type":"heartbeat","serverTime":1717000000000,"marketOpen":false}
+//
+// The portfolio action streams the value of a set of long positions,
+// recalculated whenever one of their quotes changes:
+//
+//	{"type":"portfolio","time":1717000000000,"partial":false,"marketValue":1901,
+//	 "costBasis":1500,"dayChange":21,"dayChangePercent":1.12,"unrealizedPL":401,
+//	 "positions":[{"symbol":"AAPL","quantity":10,"costBasis":150,"price":190.1,
+//	   "marketValue":1901,"dayChange":21,"unrealizedPL":401,"status":"ok"}]}
+//
+// A position's status is "pending" until its first quote, "stale" when the
+// latest fetch failed (it is valued at the last good quote), or "error"
+// when it has never been priced; any of these sets partial. Held symbols
+// don't count against the subscription limit, and a failing one never
+// closes the connection. An empty holdings list stops the stream.
+//
+// The legacy ?symbol=TSLA form is still accepted as a seed; with neither
+// parameter the connection starts on AAPL.
+//
+// The server only ends a connection for an unrecoverable condition, with
+// an error frame followed by a close frame carrying one of:
+//
+//	1001 going away         shutting_down: the server is stopping
+//	1008 policy violation   slow_consumer: the client read too slowly to keep up
+//
+// Beyond WS_MAX_CONNS open connections, or WS_MAX_CONNS_PER_IP from one
+// address, the upgrade is refused with 503 and a Retry-After header.
+// Upgrades from a browser page on another origin are refused with 403
+// unless ALLOWED_ORIGINS admits it; see checkOrigin.
+func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
+	seed, err := parseSymbols(r.URL.Query().Get("symbols"))
+	if err == nil && len(seed) == 0 {
+		seed, err = parseSymbols(r.URL.Query().Get("symbol"))
+	}
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	resume, resumeErr := parseResume(r.URL.Query().Get("resume"))
+	for sym := range resume {
+		if !slices.Contains(seed, sym) {
+			seed = append(seed, sym)
+		}
+	}
+	if len(seed) == 0 {
+		seed = []string{defaultSymbol}
+	}
+	seedOpts := subOptions{candles: r.URL.Query().Get("candles") == "1"}
+	seedSnapshot, _ := strconv.Atoi(r.URL.Query().Get("snapshot"))
+	var fieldsErr error
+	if v := r.URL.Query().Get("fields"); v != "" {
+		seedOpts.fields, fieldsErr = parseFields(strings.Split(v, ","), quoteFields)
+	}
+	seedStyle, styleErr := parseStyle(r.URL.Query().Get("style"))
+	if styleErr == nil {
+		seedOpts.style = seedStyle
+	}
+
+	interval := cfg.PollInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		if interval, err = parseInterval(v); err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+	}
+
+	// Claim a slot before upgrading so a refusal is still a plain HTTP reply
+	ip := remoteIP(r)
+	if !s.conns.reserve(ip) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(connRetryAfter.Seconds())))
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "too_many_connections"})
+		return
+	}
+	defer s.conns.release(ip)
+
+	// The chosen subprotocol must be echoed back: the schema version if
+	// the client offered one, else a token passed as a subprotocol
+	schema, schemaProto := negotiateSchema(r)
+	var hdr http.Header
+	if p := cmp.Or(schemaProto, tokenProtocol(r)); p != "" {
+		hdr = http.Header{"Sec-WebSocket-Protocol": {p}}
+	}
+	conn, err := upgrader.Upgrade(w, r, hdr)
+	if err != nil {
+		logFrom(r.Context()).Warn("ws upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+	// Oversized frames fail the read and close the connection with 1009
+	conn.SetReadLimit(cfg.MaxMessageSize)
+	// Only used when the client negotiated compression; favor CPU over
+	// ratio since quote frames are small and frequent.
+	conn.SetCompressionLevel(flate.BestSpeed)
+
+	c := newWSClient(r.Context(), conn, s.hub, s.provider, interval)
+	c.dedupe = r.URL.Query().Get("dedupe") != "0"
+	c.schema = schema
+	c.owner, c.inbox = authOwner(r), s.inbox
+	defer c.close()
+	s.conns.add(c)
+	defer s.conns.remove(c)
+	wsActive.Inc()
+	defer wsActive.Dec()
+
+	// Every pong pushes the read deadline out; if they stop arriving,
+	// ReadMessage fails and the connection is torn down.
+	pongWait := cfg.pongWait()
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	if s.market != nil {
+		c.queue(s.market.status().msg())
+	}
+	if fieldsErr != nil {
+		c.sendError(fieldsErr) // the seeds get every field
+	}
+	if styleErr != nil {
+		c.sendError(styleErr) // the seeds get plain candles
+	}
+	if resumeErr != nil {
+		c.sendError(resumeErr)
+	}
+	c.sendInterval()
+
+	go c.readPump()
+	go c.writePump()
+	go c.keepalive()
+	go c.watchdog()
+	go c.heartbeat(cfg.Heartbeat)
+
+	for _, sym := range seed {
+		var err error
+		if seq, ok := resume[sym]; ok {
+			err = c.resume(sym, seedOpts, seq)
+		} else {
+			err = c.subscribeWithSnapshot(sym, seedOpts, seedSnapshot)
+		}
+		if err != nil {
+			c.sendError(err)
+			break
+		}
+	}
+	if r.URL.Query().Get("alerts") == "1" {
+		if err := c.listenAlerts(); err != nil {
+			c.cancel()
+		}
+	}
+
+	// Unregistering on return cancels any poller (and its in-flight fetch)
+	// that we were the last user of; closing the conn ends the read pump.
+	<-c.ctx.Done()
+}
+
+// readPump consumes control messages (and, inside gorilla, ping/pong/close
+// frames) until the peer goes away, then cancels the connection context.
+func (c *wsClient) readPump() {
+	defer c.cancel()
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg controlMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.sendError(errors.New("malformed control message"))
+			continue
+		}
+		if err := c.handleControl(msg); err != nil {
+			c.sendError(err)
+		}
+	}
+}
+
+func (c *wsClient) handleControl(msg controlMsg) error {
+	if msg.Action == "interval" {
+		d, err := parseInterval(strings.Trim(string(msg.Interval), `"`))
+		if err != nil {
+			return err
+		}
+		c.setInterval(d)
+		return c.sendInterval()
+	}
+	if msg.Action == "portfolio" {
+		hs, err := parseHoldings(msg.Holdings, "quantity")
+		if err != nil {
+			return err
+		}
+		return c.setPortfolio(hs)
+	}
+
+	symbol, err := normalizeSymbol(msg.Symbol)
+	if err != nil {
+		return err
+	}
+	switch msg.Action {
+	case "subscribe", "resume":
+		if msg.Alerts {
+			if err := c.listenAlerts(); err != nil {
+				c.cancel()
+				return nil
+			}
+		}
+		fields, err := parseFields(msg.Fields, quoteFields)
+		if err != nil {
+			return err
+		}
+		style, err := parseStyle(msg.Style)
+		if err != nil {
+			return err
+		}
+		opts := subOptions{candles: msg.Candles, fields: fields, style: style}
+		if msg.Action == "resume" {
+			return c.resume(symbol, opts, msg.Seq)
+		}
+		return c.subscribeWithSnapshot(symbol, opts, msg.Snapshot)
+	case "unsubscribe":
+		return c.unsubscribe(symbol)
+	default:
+		return fmt.Errorf("unknown action %q", msg.Action)
+	}
+}
@@ -0,0 +1,1435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"2s", 2 * time.Second, false},
+		{"10", 10 * time.Second, false},
+		{"1500ms", 1500 * time.Millisecond, false},
+		{"1ms", cfg.MinPollInterval, false}, // clamped up
+		{"24h", cfg.MaxPollInterval, false}, // clamped down
+		{"0", 0, true},
+		{"-5s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseInterval(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseInterval(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWSRefusesBadInterval(t *testing.T) {
+	s := &server{}
+	for _, v := range []string{"soon", "0", "-1s"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ws?symbol=AAPL&interval="+v, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		s.handleWS(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("interval=%s: status %d, want 400 before the upgrade", v, rec.Code)
+		}
+	}
+}
+
+// wsServer serves /ws on a real listener with quotes from fetch, returning
+// the server and its ws:// URL.
+func wsServer(t *testing.T, fetch func(context.Context, string) (*Quote, error)) (*server, string) {
+	t.Helper()
+	s := &server{hub: newHub(fetch)}
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWS))
+	t.Cleanup(ts.Close)
+	return s, "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+// dialWS connects to url offering schema v2, so every frame is typed,
+// closing the connection when the test ends
+func dialWS(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	d := websocket.Dialer{Subprotocols: []string{schemaV2}}
+	conn, _, err := d.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestWSKeepalive(t *testing.T) {
+	pongWait := cfg.pongWait()
+	tests := []struct {
+		name    string
+		answers bool // the client reads, so its library answers pings
+	}{
+		{"answering client stays", true},
+		{"silent client is reaped", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &countingFetch{}
+			s, url := wsServer(t, f.fetch)
+			start := time.Now()
+			conn := dialWS(t, url)
+			if tt.answers {
+				go func() {
+					for {
+						if _, _, err := conn.ReadMessage(); err != nil {
+							return
+						}
+					}
+				}()
+				time.Sleep(5 * pongWait)
+				if n := s.conns.count(); n != 1 {
+					t.Fatalf("%d connections open after %v of pongs, want 1", n, 5*pongWait)
+				}
+				return
+			}
+
+			waitFor(t, "the stalled connection to be closed", func() bool { return s.conns.count() == 0 })
+			// The read deadline set at connect is only extended by pongs
+			if took := time.Since(start); took < pongWait || took > pongWait+time.Second {
+				t.Errorf("stalled connection closed after %v, want about %v", took, pongWait)
+			}
+		})
+	}
+}
+
+func TestWSAbruptDisconnectLeaksNothing(t *testing.T) {
+	tests := []struct {
+		name     string
+		inFlight bool // the quote fetch is still running when clients vanish
+	}{
+		{"quotes flowing", false},
+		{"fetch in flight", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			aborted := make(chan error, 1)
+			f := &countingFetch{}
+			fetch := f.fetch
+			if tt.inFlight {
+				fetch = func(ctx context.Context, symbol string) (*Quote, error) {
+					close(started)
+					<-ctx.Done()
+					aborted <- ctx.Err()
+					return nil, ctx.Err()
+				}
+			}
+			s, url := wsServer(t, fetch)
+			before := runtime.NumGoroutine()
+
+			const clients = 20
+			conns := make([]*websocket.Conn, clients)
+			for i := range conns {
+				conns[i] = dialWS(t, url)
+				// Reading answers the server's pings
+				go func() {
+					for {
+						if _, _, err := conns[i].ReadMessage(); err != nil {
+							return
+						}
+					}
+				}()
+			}
+			waitFor(t, "every connection to subscribe", func() bool { return s.hub.Subscribers()["AAPL"] == clients })
+			if tt.inFlight {
+				<-started
+			} else {
+				waitFor(t, "a quote", func() bool { return f.count("AAPL") > 0 })
+			}
+
+			// Drop the TCP connections without a close frame
+			for _, conn := range conns {
+				conn.NetConn().Close()
+			}
+			waitFor(t, "every connection to be closed", func() bool { return s.conns.count() == 0 })
+			if subs := s.hub.Subscribers(); len(subs) != 0 {
+				t.Errorf("hub still has subscribers %v", subs)
+			}
+			if tt.inFlight {
+				select {
+				case err := <-aborted:
+					if !errors.Is(err, context.Canceled) {
+						t.Errorf("in-flight fetch ended with %v, want context.Canceled", err)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("in-flight fetch was never cancelled")
+				}
+			}
+			waitFor(t, "the connection goroutines to exit", func() bool { return runtime.NumGoroutine() <= before })
+		})
+	}
+}
+
+// readFrames collects conn's JSON frames until it is closed, returning
+// them and the close error.
+func readFrames(conn *websocket.Conn) ([]map[string]any, error) {
+	var frames []map[string]any
+	for {
+		var m map[string]any
+		if err := conn.ReadJSON(&m); err != nil {
+			return frames, err
+		}
+		frames = append(frames, m)
+	}
+}
+
+// readQuote reads frames from conn up to the first quote
+func readQuote(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	for {
+		var m map[string]any
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m["price"]; ok {
+			return m
+		}
+	}
+}
+
+// closeCode is the code of the close frame behind err, or 1006 if the
+// connection ended without one.
+func closeCode(err error) int {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	return websocket.CloseAbnormalClosure
+}
+
+func TestWSDropsStalledClient(t *testing.T) {
+	// One quote, then polls that never return, so nothing new is pushed
+	var once sync.Once
+	s, url := wsServer(t, func(ctx context.Context, symbol string) (*Quote, error) {
+		var q *Quote
+		once.Do(func() { q = &Quote{Current: 190.1, PrevClose: 188} })
+		if q != nil {
+			return q, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	slow := dialWS(t, url)
+	readQuote(t, slow)
+	slowDone := make(chan error, 1)
+	var slowFrames []map[string]any
+	go func() {
+		var err error
+		slowFrames, err = readFrames(slow)
+		slowDone <- err
+	}()
+
+	// Make the slow client's oldest update look older than stallTimeout
+	// without waking its writer, as if its socket had stopped draining
+	s.conns.each(func(c *wsClient) {
+		c.updates.mu.Lock()
+		c.updates.pending["MSFT"] = queuedUpdate{update: quoteUpdate{Symbol: "MSFT"}, queued: time.Now().Add(-2 * stallTimeout)}
+		c.updates.order = append([]string{"MSFT"}, c.updates.order...)
+		c.updates.mu.Unlock()
+	})
+
+	// A client connected meanwhile keeps getting quotes
+	fast := dialWS(t, url)
+	readQuote(t, fast)
+	go readFrames(fast)
+
+	select {
+	case err := <-slowDone:
+		if code := closeCode(err); code != websocket.ClosePolicyViolation {
+			t.Errorf("stalled client closed with %d, want %d", code, websocket.ClosePolicyViolation)
+		}
+		last := slowFrames[len(slowFrames)-1]
+		if last["type"] != "error" || last["code"] != codeSlowConsumer {
+			t.Errorf("last frame before the close = %v, want a slow_consumer error", last)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled client was never dropped")
+	}
+	waitFor(t, "the slow connection to be released", func() bool { return s.conns.count() <= 1 })
+	if n := s.conns.count(); n != 1 {
+		t.Errorf("%d connections open, want only the fast one", n)
+	}
+}
+
+func TestWSCandlesOptIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"quotes only", "", false},
+		{"opted in", "?candles=1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &countingFetch{}
+			_, url := wsServer(t, f.fetch)
+			conn := dialWS(t, url+tt.query)
+			readQuote(t, conn)
+
+			// The bar follows its quote; without it, nothing else comes
+			conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			var m map[string]any
+			err := conn.ReadJSON(&m)
+			if got := err == nil && m["type"] == "candle"; got != tt.want {
+				t.Fatalf("candle sent = %v (frame %v, err %v), want %v", got, m, err, tt.want)
+			}
+			if tt.want && (m["symbol"] != "AAPL" || m["o"] != 101.0 || m["c"] != 101.0) {
+				t.Errorf("candle = %v, want AAPL's first tick", m)
+			}
+		})
+	}
+}
+
+func TestWSPingsAndReapsStalledClient(t *testing.T) {
+	f := &countingFetch{}
+	s, url := wsServer(t, f.fetch)
+	conn := dialWS(t, url+"?interval=1s")
+	var pings atomic.Int32
+	conn.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	// Reading answers pings, which come every cfg.PingPeriod
+	readFor := 10 * cfg.PingPeriod
+	conn.SetReadDeadline(time.Now().Add(readFor))
+	readFrames(conn)
+	if n := pings.Load(); n < 5 || n > 11 {
+		t.Errorf("%d pings in %v, want about %d", n, readFor, readFor/cfg.PingPeriod)
+	}
+	if n := s.conns.count(); n != 1 {
+		t.Fatalf("%d connections open while answering pings, want 1", n)
+	}
+
+	// Now silent: the client is reaped and its poller stopped
+	waitFor(t, "the stalled client to be reaped", func() bool { return s.conns.count() == 0 })
+	if subs := s.hub.Subscribers(); len(subs) != 0 {
+		t.Errorf("hub still polls %v", subs)
+	}
+	polls := f.count("AAPL")
+	time.Sleep(1500 * time.Millisecond)
+	if n := f.count("AAPL"); n != polls {
+		t.Errorf("%d polls after the client was reaped", n-polls)
+	}
+}
+
+func TestWSCloseCodes(t *testing.T) {
+	failing := func(err error) func(context.Context, string) (*Quote, error) {
+		return func(context.Context, string) (*Quote, error) { return nil, err }
+	}
+	tests := []struct {
+		name      string
+		fetch     func(context.Context, string) (*Quote, error)
+		trigger   func(s *server, conn *websocket.Conn) error
+		wantCode  int    // 0: the connection stays open
+		wantError string // code of the last error frame, if any
+	}{
+		{
+			name: "server shutdown",
+			trigger: func(s *server, _ *websocket.Conn) error {
+				s.conns.closeAll(errShuttingDown, websocket.CloseGoingAway)
+				return nil
+			},
+			wantCode:  websocket.CloseGoingAway,
+			wantError: codeShuttingDown,
+		},
+		{
+			name: "slow consumer",
+			trigger: func(s *server, _ *websocket.Conn) error {
+				s.conns.each(func(c *wsClient) { c.fail(errSlowConsumer, websocket.ClosePolicyViolation) })
+				return nil
+			},
+			wantCode:  websocket.ClosePolicyViolation,
+			wantError: codeSlowConsumer,
+		},
+		{
+			name: "send buffer overflow",
+			trigger: func(s *server, _ *websocket.Conn) error {
+				// Queue faster than writePump drains until the buffer fills
+				s.conns.each(func(c *wsClient) {
+					for c.queue(map[string]any{"type": "filler"}) == nil {
+					}
+				})
+				return nil
+			},
+			wantCode: websocket.ClosePolicyViolation,
+		},
+		{
+			name: "oversized control message",
+			trigger: func(_ *server, conn *websocket.Conn) error {
+				return conn.WriteMessage(websocket.TextMessage, make([]byte, cfg.MaxMessageSize+1))
+			},
+			wantCode: websocket.CloseMessageTooBig,
+		},
+		{
+			name:      "upstream rate limit",
+			fetch:     failing(fmt.Errorf("quote: %w", ErrRateLimited)),
+			wantError: codeRateLimited,
+		},
+		{
+			name:      "upstream failure",
+			fetch:     failing(errors.New("upstream down")),
+			wantError: codeUpstream,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetch := tt.fetch
+			if fetch == nil {
+				fetch = (&countingFetch{}).fetch
+			}
+			s, url := wsServer(t, fetch)
+			conn := dialWS(t, url)
+			errorFrames := make(chan string, 100)
+			closed := make(chan error, 1)
+			go func() {
+				for {
+					var m map[string]any
+					if err := conn.ReadJSON(&m); err != nil {
+						closed <- err
+						return
+					}
+					if m["type"] == "error" {
+						code, _ := m["code"].(string)
+						errorFrames <- code
+					}
+				}
+			}()
+			waitFor(t, "the connection to open", func() bool { return s.conns.count() == 1 })
+
+			if tt.trigger != nil {
+				if err := tt.trigger(s, conn); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var lastError string
+			if tt.wantCode == 0 {
+				for lastError != tt.wantError {
+					select {
+					case lastError = <-errorFrames:
+					case err := <-closed:
+						t.Fatalf("connection closed (%v), want it kept open", err)
+					case <-time.After(5 * time.Second):
+						t.Fatalf("no %s error frame", tt.wantError)
+					}
+				}
+				if n := s.conns.count(); n != 1 {
+					t.Errorf("%d connections open, want 1", n)
+				}
+				return
+			}
+
+			select {
+			case err := <-closed:
+				if code := closeCode(err); code != tt.wantCode {
+					t.Errorf("closed with %d (%v), want %d", code, err, tt.wantCode)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("connection never closed")
+			}
+			for len(errorFrames) > 0 {
+				lastError = <-errorFrames
+			}
+			if lastError != tt.wantError {
+				t.Errorf("last error frame %q, want %q", lastError, tt.wantError)
+			}
+			waitFor(t, "the connection to be released", func() bool { return s.conns.count() == 0 })
+		})
+	}
+}
+
+func TestWSConnectionCaps(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxConns  int
+		maxPerIP  int
+		dials     int
+		wantOpen  int
+		wantError string
+	}{
+		{"global cap", 5, 20, 12, 5, "too_many_connections"},
+		{"per-IP cap", 1000, 3, 12, 3, "too_many_connections"},
+		{"under both", 10, 10, 4, 4, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevMax, prevPerIP := cfg.MaxConns, cfg.MaxConnsPerIP
+			cfg.MaxConns, cfg.MaxConnsPerIP = tt.maxConns, tt.maxPerIP
+			t.Cleanup(func() { cfg.MaxConns, cfg.MaxConnsPerIP = prevMax, prevPerIP })
+
+			f := &countingFetch{}
+			s, url := wsServer(t, f.fetch)
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			var open []*websocket.Conn
+			var refused []*http.Response
+			for range tt.dials {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+					mu.Lock()
+					defer mu.Unlock()
+					if err == nil {
+						open = append(open, conn)
+						go readFrames(conn) // answer pings
+					} else if resp != nil {
+						refused = append(refused, resp)
+					} else {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if len(open) != tt.wantOpen || len(refused) != tt.dials-tt.wantOpen {
+				t.Fatalf("%d open, %d refused; want %d open", len(open), len(refused), tt.wantOpen)
+			}
+			if n := s.conns.count(); n != tt.wantOpen {
+				t.Errorf("count() = %d, want %d", n, tt.wantOpen)
+			}
+			for _, resp := range refused {
+				var body map[string]string
+				json.NewDecoder(resp.Body).Decode(&body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "30" || body["error"] != tt.wantError {
+					t.Errorf("refusal %d, Retry-After %q, body %v", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+				}
+			}
+
+			// Every slot comes back once the clients leave
+			for _, conn := range open {
+				conn.Close()
+			}
+			waitFor(t, "the slots to be released", func() bool { return s.conns.count() == 0 })
+			s.conns.mu.Lock()
+			leftover := len(s.conns.perIP)
+			s.conns.mu.Unlock()
+			if leftover != 0 {
+				t.Errorf("%d per-IP counters left behind", leftover)
+			}
+		})
+	}
+}
+
+func TestWSCompression(t *testing.T) {
+	tests := []struct {
+		name   string
+		server bool // WS_COMPRESSION
+		client bool // the client offers permessage-deflate
+		want   bool
+	}{
+		{"both", true, true, true},
+		{"client doesn't offer it", true, false, false},
+		{"switched off", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := upgrader.EnableCompression
+			upgrader.EnableCompression = tt.server
+			t.Cleanup(func() { upgrader.EnableCompression = prev })
+
+			f := &countingFetch{}
+			_, url := wsServer(t, f.fetch)
+			d := websocket.Dialer{EnableCompression: tt.client}
+			conn, resp, err := d.Dial(url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			ext := resp.Header.Get("Sec-WebSocket-Extensions")
+			if got := strings.Contains(ext, "permessage-deflate"); got != tt.want {
+				t.Errorf("negotiated %q, want compression %v", ext, tt.want)
+			}
+			// Either way the quotes decode
+			if q := readQuote(t, conn); q["symbol"] != "AAPL" || q["price"] != 101.0 {
+				t.Errorf("quote %v", q)
+			}
+		})
+	}
+}
+
+func TestWSHeartbeat(t *testing.T) {
+	const every = 50 * time.Millisecond
+	tests := []struct {
+		name     string
+		dataGap  time.Duration // between quotes; 0 for none at all
+		min, max int           // heartbeats expected in the window
+	}{
+		{"quiet connection", 0, 5, 11},
+		{"quotes flowing", every / 5, 0, 0},
+		{"quotes slower than the heartbeat", 3 * every, 3, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			c := &wsClient{ctx: ctx, cancel: cancel, send: make(chan any, sendBuffer)}
+			done := make(chan struct{})
+			go func() {
+				c.heartbeat(every)
+				close(done)
+			}()
+
+			window := time.After(10 * every)
+			var data <-chan time.Time
+			if tt.dataGap > 0 {
+				ticker := time.NewTicker(tt.dataGap)
+				defer ticker.Stop()
+				data = ticker.C
+			}
+			beats := 0
+		loop:
+			for {
+				select {
+				case v := <-c.send:
+					m := v.(map[string]any)
+					if m["type"] != "heartbeat" || m["serverTime"] == nil || m["marketOpen"] == nil {
+						t.Errorf("heartbeat frame %v", m)
+					}
+					beats++
+				case <-data:
+					c.lastData.Store(time.Now().UnixNano())
+				case <-window:
+					break loop
+				}
+			}
+			cancel()
+			<-done
+			if beats < tt.min || beats > tt.max {
+				t.Errorf("%d heartbeats in %v, want %d..%d", beats, 10*every, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestWSDue(t *testing.T) {
+	t0 := time.Unix(1717000000, 0)
+	q := func(price, high float64) *Quote {
+		return &Quote{Current: price, High: high, Low: 180, PrevClose: 188}
+	}
+	type tick struct {
+		at  time.Duration // since t0
+		q   *Quote
+		gen uint64 // subscription generation, 1 unless resubscribed
+	}
+	every5s := func(quotes ...*Quote) []tick {
+		ticks := make([]tick, len(quotes))
+		for i, qu := range quotes {
+			ticks[i] = tick{time.Duration(i) * 5 * time.Second, qu, 1}
+		}
+		return ticks
+	}
+	same := q(190, 191)
+	tests := []struct {
+		name   string
+		dedupe bool
+		ticks  []tick
+		want   []int // indexes of the ticks written
+	}{
+		{"identical quotes are skipped", true, every5s(same, same, same, same), []int{0}},
+		{"price changes go out", true, every5s(q(190, 191), q(190.1, 191), q(190, 191)), []int{0, 1, 2}},
+		{"a new high goes out", true, every5s(q(190, 191), q(190, 192)), []int{0, 1}},
+		{"dedupe off sends every tick", false, every5s(same, same, same), []int{0, 1, 2}},
+		{
+			"force-send after WS_FORCE_SEND",
+			true,
+			[]tick{{0, same, 1}, {30 * time.Second, same, 1}, {cfg.ForceSend, same, 1}, {cfg.ForceSend + 5*time.Second, same, 1}},
+			[]int{0, 2},
+		},
+		{
+			"a resubscription starts over",
+			true,
+			[]tick{{0, same, 1}, {5 * time.Second, same, 2}},
+			[]int{0, 1},
+		},
+		{
+			"throttled to the connection's interval",
+			false,
+			[]tick{{0, same, 1}, {time.Second, same, 1}, {4 * time.Second, same, 1}, {4600 * time.Millisecond, same, 1}, {6 * time.Second, same, 1}, {9200 * time.Millisecond, same, 1}},
+			[]int{0, 3, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &wsClient{dedupe: tt.dedupe, lastSent: make(map[string]sentQuote)}
+			var written []int
+			for i, tk := range tt.ticks {
+				u := quoteUpdate{Symbol: "AAPL", Quote: tk.q, Time: t0.Add(tk.at)}
+				opts := subOptions{gen: tk.gen}
+				if c.due(u, opts, 5*time.Second) {
+					written = append(written, i)
+					c.lastSent[u.Symbol] = sentQuote{gen: opts.gen, at: u.Time, quote: *u.Quote}
+				}
+			}
+			if !slices.Equal(written, tt.want) {
+				t.Errorf("wrote ticks %v, want %v", written, tt.want)
+			}
+		})
+	}
+}
+
+func TestWSPortfolio(t *testing.T) {
+	var mu sync.Mutex
+	aapl := 190.0
+	s, url := wsServer(t, func(ctx context.Context, symbol string) (*Quote, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch symbol {
+		case "AAPL":
+			return &Quote{Current: aapl, PrevClose: 188}, nil
+		case "MSFT":
+			return &Quote{Current: 410, PrevClose: 415}, nil
+		}
+		return nil, errors.New("upstream down")
+	})
+	conn := dialWS(t, url+"?interval=1s")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err := conn.WriteJSON(map[string]any{"action": "portfolio", "holdings": []holding{
+		{Symbol: "AAPL", Quantity: 10, CostBasis: 150},
+		{Symbol: "MSFT", Quantity: 2, CostBasis: 400},
+		{Symbol: "DOWN", Quantity: 1, CostBasis: 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nextPortfolio reads up to the next portfolio frame with no position
+	// still pending
+	nextPortfolio := func() map[string]any {
+		t.Helper()
+		for {
+			var m map[string]any
+			if err := conn.ReadJSON(&m); err != nil {
+				t.Fatal(err)
+			}
+			if m["type"] != "portfolio" {
+				continue
+			}
+			pending := false
+			for _, p := range m["positions"].([]any) {
+				pending = pending || p.(map[string]any)["status"] == "pending"
+			}
+			if !pending {
+				return m
+			}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		aapl        float64
+		marketValue float64
+		dayChange   float64
+	}{
+		{"first valuation", 190, 2720, 10},
+		{"recalculated on a new quote", 191, 2730, 20},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		aapl = tt.aapl
+		mu.Unlock()
+		var m map[string]any
+		for m == nil || m["marketValue"] != tt.marketValue {
+			m = nextPortfolio()
+		}
+		if m["dayChange"] != tt.dayChange || m["partial"] != true {
+			t.Errorf("%s: %v, want dayChange %v and partial set", tt.name, m, tt.dayChange)
+		}
+		statuses := []string{}
+		for _, p := range m["positions"].([]any) {
+			statuses = append(statuses, p.(map[string]any)["status"].(string))
+		}
+		if want := []string{"ok", "ok", "error"}; !slices.Equal(statuses, want) {
+			t.Errorf("%s: statuses %v, want %v", tt.name, statuses, want)
+		}
+	}
+	if subs := s.hub.Subscribers(); len(subs) != 3 {
+		t.Errorf("hub polls %v, want the 3 held symbols", subs)
+	}
+}
+
+func TestWSErrorFrames(t *testing.T) {
+	_, url := wsServer(t, (&countingFetch{}).fetch)
+	conn := dialWS(t, url)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readQuote(t, conn)
+
+	tests := []struct {
+		name string
+		send string
+		want map[string]any
+	}{
+		{
+			"malformed control message",
+			`{"action":`,
+			map[string]any{"type": "error", "code": codeBadRequest, "message": "malformed control message", "retryable": false},
+		},
+		{
+			"unsubscribe of an unknown symbol",
+			`{"action":"unsubscribe","symbol":"TSLA"}`,
+			map[string]any{"type": "error", "code": codeNotSubscribed, "message": "not subscribed to TSLA", "retryable": false, "symbol": "TSLA"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.send)); err != nil {
+				t.Fatal(err)
+			}
+			for {
+				var m map[string]any
+				if err := conn.ReadJSON(&m); err != nil {
+					t.Fatal(err)
+				}
+				if m["type"] == "error" {
+					if !maps.Equal(m, tt.want) {
+						t.Errorf("error frame = %v, want %v", m, tt.want)
+					}
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestWSUpstreamErrorStreak(t *testing.T) {
+	var calls atomic.Int32
+	_, url := wsServer(t, func(ctx context.Context, symbol string) (*Quote, error) {
+		if calls.Add(1) <= 2 {
+			return nil, fmt.Errorf("quote: %w", ErrRateLimited)
+		}
+		return &Quote{Current: 190.1, PrevClose: 188}, nil
+	})
+	conn := dialWS(t, url+"?interval=1s")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Two failed polls are reported once, the socket stays open and the
+	// next good poll comes through
+	errorFrames := 0
+	for {
+		var m map[string]any
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("after %d error frames: %v", errorFrames, err)
+		}
+		if m["type"] == "error" {
+			errorFrames++
+			if m["code"] != codeRateLimited || m["retryable"] != true {
+				t.Errorf("error frame %v", m)
+			}
+		}
+		if m["price"] != nil {
+			break
+		}
+	}
+	if errorFrames != 1 {
+		t.Errorf("%d error frames for one failure streak, want 1", errorFrames)
+	}
+	if n := calls.Load(); n < 3 {
+		t.Errorf("quote arrived after %d polls, want it from the third", n)
+	}
+}
+
+func TestWSFirstFrameIsMarketStatus(t *testing.T) {
+	f := &countingFetch{}
+	s, url := wsServer(t, f.fetch)
+	s.market = newMarketWatcher(func() time.Time { return ny("2024-06-03 10:00") }, func(marketStatus) {})
+	conn := dialWS(t, url)
+	var m map[string]any
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m["type"] != "market_status" || m["open"] != true || m["session"] != sessionRegular {
+		t.Errorf("first frame %v, want the market status", m)
+	}
+
+	// Transitions go to every open connection
+	s.conns.broadcast(marketStatusAt(ny("2024-06-03 16:00")).msg())
+	for {
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m["type"] == "market_status" {
+			break
+		}
+	}
+	if m["session"] != sessionAfterHours {
+		t.Errorf("broadcast %v, want after-hours", m)
+	}
+}
+
+func TestWSMaxMessageSize(t *testing.T) {
+	defer func(n int64) { cfg.MaxMessageSize = n }(cfg.MaxMessageSize)
+	cfg.MaxMessageSize = 64
+
+	tests := []struct {
+		name     string
+		frame    string
+		wantCode int // 0: subscribed and still open
+	}{
+		{"within the limit", `{"action":"subscribe","symbol":"TSLA"}`, 0},
+		{"exactly the limit", `{"action":"subscribe","symbol":"TSLA","pad":"` + strings.Repeat("x", 17) + `"}`, 0},
+		{"oversized", `{"action":"subscribe","symbol":"TSLA","pad":"` + strings.Repeat("x", 18) + `"}`, websocket.CloseMessageTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantCode == 0 && int64(len(tt.frame)) > cfg.MaxMessageSize || tt.wantCode != 0 && int64(len(tt.frame)) <= cfg.MaxMessageSize {
+				t.Fatalf("frame is %d bytes, on the wrong side of the limit", len(tt.frame))
+			}
+			s, url := wsServer(t, (&countingFetch{}).fetch)
+			conn := dialWS(t, url)
+			closed := make(chan error, 1)
+			go func() {
+				_, err := readFrames(conn)
+				closed <- err
+			}()
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.frame)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantCode == 0 {
+				waitFor(t, "the TSLA subscription", func() bool { return s.hub.Subscribers()["TSLA"] == 1 })
+				return
+			}
+			select {
+			case err := <-closed:
+				if code := closeCode(err); code != tt.wantCode {
+					t.Errorf("closed with %d (%v), want %d", code, err, tt.wantCode)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("connection still open after an oversized frame")
+			}
+		})
+	}
+}
+
+func TestWSWriteDeadline(t *testing.T) {
+	tests := []struct {
+		name      string
+		writeWait time.Duration
+	}{
+		{"short", 50 * time.Millisecond},
+		{"longer", 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocked := make(chan time.Duration, 1)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				c := newWSClient(context.Background(), conn, nil, nil, time.Second)
+				defer c.cancel()
+				c.writeWait = tt.writeWait
+				// The peer never reads, so writes block once the socket
+				// buffers fill; the deadline must cut the stuck one short.
+				big := strings.Repeat("x", 1<<20)
+				for {
+					start := time.Now()
+					if err := c.write(map[string]string{"pad": big}); err != nil {
+						var ne net.Error
+						if !errors.As(err, &ne) || !ne.Timeout() {
+							t.Errorf("write failed with %v, want a timeout", err)
+						}
+						blocked <- time.Since(start)
+						return
+					}
+				}
+			}))
+			defer ts.Close()
+			dialWS(t, "ws"+strings.TrimPrefix(ts.URL, "http"))
+
+			select {
+			case d := <-blocked:
+				if d < tt.writeWait || d > tt.writeWait+time.Second {
+					t.Errorf("stuck write gave up after %s, want about %s", d, tt.writeWait)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("writes never timed out")
+			}
+		})
+	}
+}
+
+func TestWSConnsBroadcastDuringChurn(t *testing.T) {
+	tests := []struct {
+		name           string
+		stable, churny int
+		broadcasts     int
+	}{
+		{"no churn", 4, 0, 50},
+		{"churn", 4, 8, 50},
+		{"only churn", 0, 16, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s wsConns
+			newClient := func() *wsClient {
+				ctx, cancel := context.WithCancel(context.Background())
+				t.Cleanup(cancel)
+				return &wsClient{ctx: ctx, cancel: cancel, send: make(chan any, tt.broadcasts)}
+			}
+			stable := make([]*wsClient, tt.stable)
+			for i := range stable {
+				stable[i] = newClient()
+				s.add(stable[i])
+			}
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for range tt.churny {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						c := newClient()
+						s.add(c)
+						s.remove(c)
+						c.cancel()
+					}
+				}()
+			}
+			for i := range tt.broadcasts {
+				s.broadcast(i)
+			}
+			close(stop)
+			wg.Wait()
+
+			// Every connection registered throughout got every frame, in order
+			for _, c := range stable {
+				if len(c.send) != tt.broadcasts {
+					t.Fatalf("%d frames queued, want %d", len(c.send), tt.broadcasts)
+				}
+				for i := range tt.broadcasts {
+					if v := <-c.send; v != i {
+						t.Fatalf("frame %d is %v", i, v)
+					}
+				}
+			}
+			s.mu.Lock()
+			n := len(s.m)
+			s.mu.Unlock()
+			if n != tt.stable {
+				t.Errorf("%d connections registered, want %d", n, tt.stable)
+			}
+		})
+	}
+}
+
+func TestWSClientQueue(t *testing.T) {
+	tests := []struct {
+		name       string
+		closed     bool
+		wantErr    error
+		wantQueued int
+	}{
+		{"open", false, nil, 1},
+		{"closed", true, context.Canceled, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c := &wsClient{ctx: ctx, cancel: cancel, send: make(chan any, 1)}
+			if tt.closed {
+				cancel()
+			}
+			if err := c.queue("frame"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("queue = %v, want %v", err, tt.wantErr)
+			}
+			if len(c.send) != tt.wantQueued {
+				t.Errorf("%d frames queued, want %d", len(c.send), tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestErrorMsg(t *testing.T) {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	tests := []struct {
+		name string
+		err  error
+		want map[string]any
+	}{
+		{
+			"rate limited",
+			upstreamError("AAPL", fmt.Errorf("quote: %w", ErrRateLimited)),
+			map[string]any{"type": "error", "code": codeRateLimited, "message": "upstream rate limited", "retryable": true, "symbol": "AAPL"},
+		},
+		{
+			"network error",
+			upstreamError("AAPL", fmt.Errorf("quote: %w", netErr)),
+			map[string]any{"type": "error", "code": codeUpstream, "message": "quote unavailable", "retryable": true, "symbol": "AAPL"},
+		},
+		{
+			"slow consumer",
+			errSlowConsumer,
+			map[string]any{"type": "error", "code": codeSlowConsumer, "message": "client too slow", "retryable": false},
+		},
+		{
+			"with a limit",
+			&wsError{code: codeSubscriptionLimit, message: "too many symbols", limit: 50},
+			map[string]any{"type": "error", "code": codeSubscriptionLimit, "message": "too many symbols", "retryable": false, "limit": 50},
+		},
+		{
+			"plain error is a bad request",
+			errors.New("malformed control message"),
+			map[string]any{"type": "error", "code": codeBadRequest, "message": "malformed control message", "retryable": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorMsg(tt.err); !maps.Equal(got, tt.want) {
+				t.Errorf("errorMsg = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWSSubscriptionLimits(t *testing.T) {
+	// The connection starts out subscribed to defaultSymbol
+	extra := func(n int) []string {
+		syms := make([]string, n)
+		for i := range syms {
+			syms[i] = fmt.Sprintf("SYM%c", 'A'+i)
+		}
+		return syms
+	}
+	n := cfg.MaxSubscriptions
+	tests := []struct {
+		name       string
+		hubLimit   int      // 0: unlimited
+		others     []string // symbols another connection polls first
+		subscribe  []string
+		wantErrors []string // error codes, in order
+		wantSubs   int      // symbols this connection ends up with
+	}{
+		{"below the connection cap", 0, nil, extra(n - 2), nil, n - 1},
+		{"at the connection cap", 0, nil, extra(n - 1), nil, n},
+		{"over the connection cap", 0, nil, extra(n + 1), []string{codeSubscriptionLimit, codeSubscriptionLimit}, n},
+		{"resubscribing at the cap", 0, nil, append(extra(n-1), "SYMA", defaultSymbol), nil, n},
+		{"at the server cap", 2, nil, []string{"MSFT"}, nil, 2},
+		{"over the server cap", 2, []string{"MSFT"}, []string{"TSLA"}, []string{codeSymbolLimit}, 1},
+		{"joining a polled symbol at the server cap", 2, []string{"MSFT"}, []string{"MSFT"}, nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, url := wsServer(t, (&countingFetch{}).fetch)
+			s.hub.limit = tt.hubLimit
+			if len(tt.others) > 0 {
+				// Holds defaultSymbol plus others, using up the server cap
+				other := dialWS(t, url+"?symbols="+strings.Join(append([]string{defaultSymbol}, tt.others...), ","))
+				go readFrames(other)
+				waitFor(t, "the other connection's symbols", func() bool { return len(s.hub.Subscribers()) == 1+len(tt.others) })
+			}
+			conn := dialWS(t, url)
+			for _, sym := range tt.subscribe {
+				if err := conn.WriteJSON(map[string]string{"action": "subscribe", "symbol": sym}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The interval reply is queued behind every subscribe's answer;
+			// the connection announced the default interval on opening
+			barrier := cfg.MaxPollInterval
+			if err := conn.WriteJSON(map[string]string{"action": "interval", "interval": barrier.String()}); err != nil {
+				t.Fatal(err)
+			}
+			var codes []string
+			for {
+				var m map[string]any
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if err := conn.ReadJSON(&m); err != nil {
+					t.Fatal(err)
+				}
+				if m["type"] == "interval" && m["interval"] == float64(barrier.Milliseconds()) {
+					break
+				}
+				if m["type"] != "error" {
+					continue
+				}
+				codes = append(codes, m["code"].(string))
+				switch m["code"] {
+				case codeSubscriptionLimit:
+					if m["limit"] != float64(n) || m["retryable"] == true {
+						t.Errorf("refusal %v, want limit %d and not retryable", m, n)
+					}
+				case codeSymbolLimit:
+					if m["symbol"] != "TSLA" || m["retryable"] != true {
+						t.Errorf("refusal %v, want TSLA and retryable", m)
+					}
+				}
+			}
+			if !slices.Equal(codes, tt.wantErrors) {
+				t.Errorf("errors %v, want %v", codes, tt.wantErrors)
+			}
+			var got int
+			s.conns.each(func(c *wsClient) {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				if c.remote == conn.LocalAddr().String() {
+					got = len(c.subs)
+				}
+			})
+			if got != tt.wantSubs {
+				t.Errorf("%d subscriptions, want %d", got, tt.wantSubs)
+			}
+		})
+	}
+}
+
+func TestWSResume(t *testing.T) {
+	tests := []struct {
+		name     string
+		trades   int    // published after the first poll
+		resume   string // ?resume=
+		wantSeqs []uint64
+		wantGap  bool
+	}{
+		{"clean", 4, "AAPL:2", []uint64{3, 4, 5}, false},
+		{"up to date", 4, "AAPL:5", nil, false},
+		{"aged out", journalSize + 4, "AAPL:2", nil, true},
+		{"ahead after a restart", 4, "AAPL:99", nil, true},
+		{"never polled", 0, "MSFT:7", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, url := wsServer(t, (&countingFetch{}).fetch)
+			keep := newUpdateQueue()
+			s.hub.Subscribe("AAPL", keep, time.Hour)
+			t.Cleanup(func() { s.hub.Unregister(keep) })
+			waitFor(t, "the first poll", func() bool { return s.hub.journalSeq("AAPL") == 1 })
+			for i := range tt.trades {
+				s.hub.Trade("AAPL", float64(200+i), time.Now())
+			}
+
+			conn := dialWS(t, url+"?resume="+tt.resume)
+			symbol, _, _ := strings.Cut(tt.resume, ":")
+			if symbol == "AAPL" {
+				// A marker trade after the resume ends an empty replay; the
+				// connection's interval holds it back after a replayed quote
+				waitFor(t, "the resumed subscription", func() bool { return s.hub.Subscribers()["AAPL"] == 2 })
+				s.hub.Trade("AAPL", 999, time.Now())
+			}
+			marker := s.hub.journalSeq("AAPL")
+			var seqs []uint64
+			var gap bool
+			for {
+				var m map[string]any
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if err := conn.ReadJSON(&m); err != nil {
+					t.Fatal(err)
+				}
+				if m["type"] == "gap" {
+					gap = m["symbol"] == symbol
+					break
+				}
+				if m["symbol"] != "AAPL" || m["seq"] == nil {
+					continue
+				}
+				seq := uint64(m["seq"].(float64))
+				if seq == marker {
+					break
+				}
+				seqs = append(seqs, seq)
+				if len(seqs) == len(tt.wantSeqs) {
+					break
+				}
+			}
+			if gap != tt.wantGap {
+				t.Errorf("gap %t, want %t", gap, tt.wantGap)
+			}
+			if !slices.Equal(seqs, tt.wantSeqs) {
+				t.Errorf("replayed %v, want %v", seqs, tt.wantSeqs)
+			}
+		})
+	}
+}
+
+func TestNegotiateSchema(t *testing.T) {
+	tests := []struct {
+		name         string
+		offered      []string // Sec-WebSocket-Protocol header values
+		wantVersion  int
+		wantProtocol string
+	}{
+		{"nothing", nil, 1, ""},
+		{"v1", []string{schemaV1}, 1, schemaV1},
+		{"v2", []string{schemaV2}, 2, schemaV2},
+		{"both, v1 first", []string{schemaV1 + ", " + schemaV2}, 2, schemaV2},
+		{"split across headers", []string{schemaV1, schemaV2}, 2, schemaV2},
+		{"token only", []string{"bearer.abc"}, 1, ""},
+		{"token and v1", []string{"bearer.abc, " + schemaV1}, 1, schemaV1},
+		{"unknown version", []string{"stocktracker.v3"}, 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			for _, v := range tt.offered {
+				r.Header.Add("Sec-WebSocket-Protocol", v)
+			}
+			version, protocol := negotiateSchema(r)
+			if version != tt.wantVersion || protocol != tt.wantProtocol {
+				t.Errorf("negotiateSchema = %d, %q; want %d, %q", version, protocol, tt.wantVersion, tt.wantProtocol)
+			}
+		})
+	}
+}
+
+func TestWSSchemaPayloads(t *testing.T) {
+	tests := []struct {
+		name      string
+		offer     []string
+		wantProto string
+		wantV2    bool
+	}{
+		{"legacy client", nil, "", false},
+		{"v1 client", []string{schemaV1}, schemaV1, false},
+		{"v2 client", []string{schemaV2}, schemaV2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, url := wsServer(t, (&countingFetch{}).fetch)
+			d := websocket.Dialer{Subprotocols: tt.offer}
+			// The unknown style queues an error frame ahead of the first quote
+			conn, _, err := d.Dial(url+"?symbols=AAPL&style=renko", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if conn.Subprotocol() != tt.wantProto {
+				t.Errorf("protocol %q, want %q", conn.Subprotocol(), tt.wantProto)
+			}
+
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantV2 {
+				// Nothing but the original quote, byte for byte
+				var got struct{ Time int64 }
+				json.Unmarshal(raw, &got)
+				want := fmt.Sprintf(`{"price":101,"symbol":"AAPL","time":%d}`, got.Time) + "\n"
+				if string(raw) != want {
+					t.Errorf("first frame\n%swant\n%s", raw, want)
+				}
+				return
+			}
+
+			var msg map[string]any
+			json.Unmarshal(raw, &msg)
+			if msg["type"] != "error" {
+				t.Fatalf("first frame %s, want the error", raw)
+			}
+			// The first quote, byte for byte
+			for !strings.Contains(string(raw), `"type":"quote"`) {
+				if _, raw, err = conn.ReadMessage(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got struct{ Time int64 }
+			json.Unmarshal(raw, &got)
+			want := quoteMsg("AAPL", &Quote{Current: 101, PrevClose: 100}, time.UnixMilli(got.Time))
+			want["seq"] = 1
+			want["type"] = "quote"
+			b, _ := json.Marshal(want)
+			if string(raw) != string(b)+"\n" {
+				t.Errorf("quote frame\n%s\nwant\n%s", raw, b)
+			}
+		})
+	}
+}
+
+func TestWSSnapshotStyle(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		subscribe string // sent after connecting, if set
+		wantStyle string
+		wantOpen  float64 // of the first snapshot bar
+		wantError bool    // an error frame comes first
+	}{
+		{"plain", "?symbols=AAPL&snapshot=5", "", styleCandles, 10, false},
+		{"heikin-ashi", "?symbols=AAPL&snapshot=5&style=heikin-ashi", "", styleHeikinAshi, 10.5, false},
+		{"unknown style falls back to plain", "?symbols=AAPL&snapshot=5&style=renko", "", styleCandles, 10, true},
+		{"subscribe message", "?symbols=MSFT", `{"action":"subscribe","symbol":"AAPL","snapshot":5,"style":"heikin-ashi"}`, styleHeikinAshi, 10.5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, url := wsServer(t, (&countingFetch{}).fetch)
+			s.provider = &stubProvider{candles: func(ctx context.Context, symbol string, from, to time.Time, resolution string) (*Candles, error) {
+				return &Candles{
+					S: "ok", Time: []int64{1717000200, 1717000260},
+					Open: []float64{10, 11}, High: []float64{12, 13}, Low: []float64{9, 10}, Close: []float64{11, 12}, Volume: []float64{100, 200},
+				}, nil
+			}}
+			conn := dialWS(t, url+tt.query)
+			if tt.subscribe != "" {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.subscribe)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var sawError bool
+			for {
+				var m struct {
+					Type    string `json:"type"`
+					Symbol  string `json:"symbol"`
+					Style   string `json:"style"`
+					Candles []struct {
+						O float64 `json:"o"`
+						V float64 `json:"v"`
+					} `json:"candles"`
+				}
+				if err := conn.ReadJSON(&m); err != nil {
+					t.Fatal(err)
+				}
+				if m.Type == "error" {
+					sawError = true
+				}
+				if m.Type != "snapshot" || m.Symbol != "AAPL" {
+					continue
+				}
+				if m.Style != tt.wantStyle || len(m.Candles) != 2 || m.Candles[0].O != tt.wantOpen || m.Candles[1].V != 200 {
+					t.Errorf("snapshot style %q, bars %+v; want %q opening at %v with volume kept", m.Style, m.Candles, tt.wantStyle, tt.wantOpen)
+				}
+				break
+			}
+			if sawError != tt.wantError {
+				t.Errorf("error frame sent = %t, want %t", sawError, tt.wantError)
+			}
+		})
+	}
+}